@@ -5,10 +5,11 @@ go 1.23.0
 toolchain go1.24.1
 
 require (
-	github.com/klauspost/cpuid/v2 v2.2.6 // indirect
-	github.com/klauspost/reedsolomon v1.12.1 // indirect
-	github.com/pierrec/lz4/v4 v4.1.21 // indirect
-	github.com/zeebo/blake3 v0.2.4 // indirect
-	golang.org/x/crypto v0.40.0 // indirect
-	golang.org/x/sys v0.34.0 // indirect
+	github.com/klauspost/reedsolomon v1.12.1
+	github.com/pierrec/lz4/v4 v4.1.21
+	github.com/zeebo/blake3 v0.2.4
+	golang.org/x/crypto v0.40.0
+	golang.org/x/sys v0.34.0
 )
+
+require github.com/klauspost/cpuid/v2 v2.2.6 // indirect
@@ -0,0 +1,94 @@
+// Package bufpool hands out reference-counted byte buffers from
+// power-of-two size classes, so the per-packet hot path (read, compress,
+// seal, send) reuses memory instead of allocating per chunk.
+package bufpool
+
+import (
+	"math/bits"
+	"sync"
+	"sync/atomic"
+)
+
+const (
+	minShift = 9  // 512 B
+	maxShift = 22 // 4 MiB
+)
+
+// Buffer is a pooled byte slice with a reference count. B has the length
+// requested from Get and at least that much capacity; holders may reslice
+// it within its capacity. The buffer returns to the pool when the last
+// reference is released and must not be touched afterwards.
+type Buffer struct {
+	B     []byte
+	refs  atomic.Int32
+	class int8
+}
+
+var pools [maxShift - minShift + 1]sync.Pool
+
+func init() {
+	for i := range pools {
+		size := 1 << (minShift + i)
+		class := int8(i)
+		pools[i].New = func() any {
+			return &Buffer{B: make([]byte, size), class: class}
+		}
+	}
+}
+
+func classFor(size int) int {
+	if size <= 1<<minShift {
+		return 0
+	}
+	c := bits.Len(uint(size-1)) - minShift
+	if c >= len(pools) {
+		return -1
+	}
+	return c
+}
+
+// Get returns a buffer of len size holding one reference. Contents are
+// not zeroed. Sizes above the largest class are allocated directly and
+// simply dropped on release.
+func Get(size int) *Buffer {
+	c := classFor(size)
+	var b *Buffer
+	if c < 0 {
+		b = &Buffer{B: make([]byte, size), class: -1}
+	} else {
+		b = pools[c].Get().(*Buffer)
+		b.B = b.B[:size]
+	}
+	b.refs.Store(1)
+	return b
+}
+
+// Retain adds a reference, for a second holder such as a retransmit queue
+// keeping a packet that is also being sent.
+func (b *Buffer) Retain() *Buffer {
+	if b.refs.Add(1) <= 1 {
+		panic("bufpool: retain of released buffer")
+	}
+	return b
+}
+
+// Release drops a reference. Nil buffers are ignored so callers can
+// release descriptors whose data was never pooled.
+func (b *Buffer) Release() {
+	if b == nil {
+		return
+	}
+	switch n := b.refs.Add(-1); {
+	case n > 0:
+		return
+	case n < 0:
+		panic("bufpool: buffer released twice")
+	}
+	if b.class >= 0 {
+		b.B = b.B[:cap(b.B)]
+		pools[b.class].Put(b)
+	}
+}
+
+// Refs reports the current reference count.
+func (b *Buffer) Refs() int { return int(b.refs.Load()) }
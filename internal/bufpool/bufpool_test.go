@@ -0,0 +1,72 @@
+package bufpool
+
+import (
+	"sync"
+	"testing"
+)
+
+func TestGetSizeClasses(t *testing.T) {
+	for _, size := range []int{0, 1, 512, 513, 1400, 64 << 10, 4 << 20, 4<<20 + 1} {
+		b := Get(size)
+		if len(b.B) != size {
+			t.Fatalf("Get(%d) len %d", size, len(b.B))
+		}
+		if c := cap(b.B); size > 0 && size <= 4<<20 && c&(c-1) != 0 {
+			t.Fatalf("Get(%d) cap %d is not a size class", size, c)
+		}
+		b.Release()
+	}
+}
+
+func TestRefCounting(t *testing.T) {
+	b := Get(100)
+	b.Retain()
+	if b.Refs() != 2 {
+		t.Fatalf("refs %d want 2", b.Refs())
+	}
+	b.Release()
+	b.Release()
+	if b.Refs() != 0 {
+		t.Fatalf("refs %d want 0", b.Refs())
+	}
+	defer func() {
+		if recover() == nil {
+			t.Fatalf("double release did not panic")
+		}
+	}()
+	b.Release()
+}
+
+func TestConcurrentRelease(t *testing.T) {
+	for i := 0; i < 100; i++ {
+		b := Get(2048)
+		var wg sync.WaitGroup
+		for j := 0; j < 8; j++ {
+			b.Retain()
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				_ = b.B[0]
+				b.Release()
+			}()
+		}
+		b.Release()
+		wg.Wait()
+	}
+}
+
+func TestGetDoesNotAllocate(t *testing.T) {
+	if raceEnabled {
+		t.Skip("allocation counts are unreliable under -race")
+	}
+	Get(1500).Release()
+	allocs := testing.AllocsPerRun(1000, func() {
+		b := Get(1500)
+		b.Retain()
+		b.Release()
+		b.Release()
+	})
+	if allocs != 0 {
+		t.Fatalf("%.1f allocs per Get/Release", allocs)
+	}
+}
@@ -9,10 +9,9 @@ import (
 type Sum128 [16]byte
 
 func Compute128(data []byte) Sum128 {
-	h := blake3.New()
-	_, _ = h.Write(data)
+	full := blake3.Sum256(data)
 	var out Sum128
-	copy(out[:], h.Sum(nil)[:16])
+	copy(out[:], full[:16])
 	return out
 }
 
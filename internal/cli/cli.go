@@ -5,32 +5,50 @@ import (
 	"flag"
 	"fmt"
 	"io"
+	"net"
 	"strconv"
 	"strings"
 )
 
 type FECConfig struct {
-	Auto bool
-	K    int
-	N    int
+	Auto     bool
+	Fountain bool
+	K        int
+	N        int
+	Depth    int
 }
 
 type Config struct {
-	Src        string
-	Dest       string
-	MTU        int
-	FEC        FECConfig
-	Congestion string
-	IDKey      string
-	PeerKey    string
-	PSK        string
-	Cipher     string
-	Port       int
-	Parallel   int
-	Resume     bool
-	NoCompress bool
-	Checksum   bool
-	DryRun     bool
+	Src             string
+	Dest            string
+	SrcLoc          Location
+	DestLoc         Location
+	Daemon          bool
+	Rendezvous      string
+	RendezvousServe bool
+	MTU             int
+	FEC             FECConfig
+	Broadcast       bool
+	Overhead        float64
+	Congestion      string
+	IDKey           string
+	PeerKey         string
+	PSK             string
+	Cipher          string
+	Port            int
+	Parallel        int
+	Resume          bool
+	NoCompress      bool
+	Checksum        bool
+	Delete          bool
+	DryRun          bool
+	Archive         bool
+	HardLinks       bool
+	CopyLinks       bool
+	SafeLinks       bool
+	NumericIDs      bool
+	XAttrs          bool
+	ACLs            bool
 }
 
 func ParseArgs(args []string) (Config, error) {
@@ -38,7 +56,8 @@ func ParseArgs(args []string) (Config, error) {
 	fs := flag.NewFlagSet("riptide", flag.ContinueOnError)
 	fs.SetOutput(io.Discard)
 	fs.IntVar(&cfg.MTU, "mtu", 1400, "payload sizing ceiling")
-	fecStr := fs.String("fec", "auto", "fec ratio k/n or 'auto'")
+	fecStr := fs.String("fec", "auto", "fec ratio k/n, 'auto' or 'fountain'")
+	fecDepth := fs.Int("fec-interleave", 1, "fec interleaving depth (1 = off)")
 	fs.StringVar(&cfg.Congestion, "congestion", "bbr", "congestion controller")
 	fs.StringVar(&cfg.IDKey, "id-key", "", "identity key path")
 	fs.StringVar(&cfg.PeerKey, "peer-key", "", "peer public key")
@@ -49,22 +68,60 @@ func ParseArgs(args []string) (Config, error) {
 	fs.BoolVar(&cfg.Resume, "resume", false, "resume transfers")
 	fs.BoolVar(&cfg.NoCompress, "no-compress", false, "disable compression")
 	fs.BoolVar(&cfg.Checksum, "checksum", false, "force strong checksum compare")
+	fs.BoolVar(&cfg.Delete, "delete", false, "delete destination files missing from the source")
 	fs.BoolVar(&cfg.DryRun, "dry-run", false, "plan only")
+	fs.BoolVar(&cfg.Archive, "archive", false, "preserve permissions, ownership, times, symlinks and special files")
+	fs.BoolVar(&cfg.Archive, "a", false, "short for -archive")
+	fs.BoolVar(&cfg.HardLinks, "hard-links", false, "preserve hardlinks within the transfer set")
+	fs.BoolVar(&cfg.HardLinks, "H", false, "short for -hard-links")
+	fs.BoolVar(&cfg.CopyLinks, "copy-links", false, "transfer what symlinks point to instead of the links")
+	fs.BoolVar(&cfg.CopyLinks, "L", false, "short for -copy-links")
+	fs.BoolVar(&cfg.SafeLinks, "safe-links", false, "ignore symlinks that point outside the tree")
+	fs.BoolVar(&cfg.NumericIDs, "numeric-ids", false, "keep uid/gid numbers instead of mapping by name")
+	fs.BoolVar(&cfg.XAttrs, "xattrs", false, "preserve extended attributes")
+	fs.BoolVar(&cfg.XAttrs, "X", false, "short for -xattrs")
+	fs.BoolVar(&cfg.ACLs, "acls", false, "preserve POSIX ACLs")
+	fs.BoolVar(&cfg.ACLs, "A", false, "short for -acls")
+	fs.BoolVar(&cfg.Daemon, "daemon", false, "serve on both address families")
+	fs.BoolVar(&cfg.Broadcast, "broadcast", false, "one-way send with no ACKs (fountain coded)")
+	fs.Float64Var(&cfg.Overhead, "broadcast-overhead", 0.3, "repair symbols per data symbol in broadcast mode")
+	fs.StringVar(&cfg.Rendezvous, "rendezvous", "", "rendezvous helper host:port for NAT traversal")
+	fs.BoolVar(&cfg.RendezvousServe, "rendezvous-serve", false, "run as a rendezvous helper (with -daemon)")
 
 	if err := fs.Parse(args); err != nil {
 		return Config{}, err
 	}
 	rest := fs.Args()
-	if len(rest) != 2 {
-		return Config{}, errors.New("expected SRC and DEST")
+	if cfg.Daemon {
+		if len(rest) != 0 {
+			return Config{}, errors.New("daemon mode takes no SRC or DEST")
+		}
+	} else {
+		if len(rest) != 2 {
+			return Config{}, errors.New("expected SRC and DEST")
+		}
+		cfg.Src = rest[0]
+		cfg.Dest = rest[1]
+		var err error
+		if cfg.SrcLoc, err = ParseLocation(cfg.Src); err != nil {
+			return Config{}, fmt.Errorf("src: %w", err)
+		}
+		if cfg.DestLoc, err = ParseLocation(cfg.Dest); err != nil {
+			return Config{}, fmt.Errorf("dest: %w", err)
+		}
+		if cfg.SrcLoc.IsRemote() && cfg.DestLoc.IsRemote() {
+			return Config{}, errors.New("src and dest cannot both be remote")
+		}
 	}
-	cfg.Src = rest[0]
-	cfg.Dest = rest[1]
 
 	fec, err := parseFEC(*fecStr)
 	if err != nil {
 		return Config{}, err
 	}
+	fec.Depth = *fecDepth
+	if cfg.Broadcast && fec.Auto {
+		fec = FECConfig{Fountain: true, Depth: fec.Depth}
+	}
 	cfg.FEC = fec
 
 	if err := validate(&cfg); err != nil {
@@ -88,10 +145,32 @@ func validate(c *Config) error {
 	if c.Port <= 0 || c.Port > 65535 {
 		return errors.New("invalid port")
 	}
+	if c.RendezvousServe && !c.Daemon {
+		return errors.New("rendezvous-serve requires daemon mode")
+	}
+	if c.Rendezvous != "" {
+		if c.RendezvousServe {
+			return errors.New("rendezvous and rendezvous-serve are exclusive")
+		}
+		if _, _, err := net.SplitHostPort(c.Rendezvous); err != nil {
+			return fmt.Errorf("invalid rendezvous address: %w", err)
+		}
+	}
 	if c.Parallel <= 0 {
 		return errors.New("parallel must be > 0")
 	}
-	if !c.FEC.Auto {
+	if c.FEC.Depth <= 0 || c.FEC.Depth > 0xffff {
+		return errors.New("invalid fec interleave depth")
+	}
+	if c.Broadcast {
+		if !c.FEC.Fountain {
+			return errors.New("broadcast requires fountain fec")
+		}
+		if c.Overhead < 0 {
+			return errors.New("broadcast overhead must be >= 0")
+		}
+	}
+	if !c.FEC.Auto && !c.FEC.Fountain {
 		if c.FEC.K <= 0 || c.FEC.N <= 0 || c.FEC.K >= c.FEC.N {
 			return errors.New("invalid fec ratio")
 		}
@@ -100,8 +179,11 @@ func validate(c *Config) error {
 }
 
 func parseFEC(s string) (FECConfig, error) {
-	if s == "auto" {
+	switch s {
+	case "auto":
 		return FECConfig{Auto: true}, nil
+	case "fountain":
+		return FECConfig{Fountain: true}, nil
 	}
 	parts := strings.Split(s, "/")
 	if len(parts) != 2 {
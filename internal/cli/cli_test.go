@@ -16,7 +16,7 @@ func TestParseArgs_DefaultsAndPositions(t *testing.T) {
 	if cfg.MTU != 1400 {
 		t.Fatalf("default mtu expected 1400, got %d", cfg.MTU)
 	}
-	wantFEC := FECConfig{Auto: true}
+	wantFEC := FECConfig{Auto: true, Depth: 1}
 	if !reflect.DeepEqual(cfg.FEC, wantFEC) {
 		t.Fatalf("fec mismatch: %+v", cfg.FEC)
 	}
@@ -29,7 +29,7 @@ func TestParseArgs_DefaultsAndPositions(t *testing.T) {
 	if cfg.Port != 3703 {
 		t.Fatalf("default port 3703, got %d", cfg.Port)
 	}
-	if cfg.Parallel != 1 || cfg.Resume || cfg.NoCompress || cfg.Checksum || cfg.DryRun {
+	if cfg.Parallel != 1 || cfg.Resume || cfg.NoCompress || cfg.Checksum || cfg.Delete || cfg.DryRun || cfg.Archive {
 		t.Fatalf("default flags unexpected: %+v", cfg)
 	}
 }
@@ -48,6 +48,7 @@ func TestParseArgs_AllFlagsValid(t *testing.T) {
 		"-resume",
 		"-no-compress",
 		"-checksum",
+		"-delete",
 		"-dry-run",
 		"srcX", "destY",
 	}
@@ -76,7 +77,7 @@ func TestParseArgs_AllFlagsValid(t *testing.T) {
 	if cfg.Parallel != 4 {
 		t.Fatalf("parallel mismatch: %d", cfg.Parallel)
 	}
-	if !cfg.Resume || !cfg.NoCompress || !cfg.Checksum || !cfg.DryRun {
+	if !cfg.Resume || !cfg.NoCompress || !cfg.Checksum || !cfg.Delete || !cfg.DryRun {
 		t.Fatalf("bool flags mismatch: %+v", cfg)
 	}
 	if cfg.Src != "srcX" || cfg.Dest != "destY" {
@@ -126,3 +127,66 @@ func TestParseArgs_Validations(t *testing.T) {
 		t.Fatalf("expected positional args error")
 	}
 }
+
+func TestParseArgs_Rendezvous(t *testing.T) {
+	cfg, err := ParseArgs([]string{"-rendezvous=helper.example:3704", "a", "host:/b"})
+	if err != nil || cfg.Rendezvous != "helper.example:3704" {
+		t.Fatalf("rendezvous client: %+v %v", cfg, err)
+	}
+	if _, err := ParseArgs([]string{"-rendezvous=nohost", "a", "b"}); err == nil {
+		t.Fatalf("expected rendezvous address error")
+	}
+	cfg, err = ParseArgs([]string{"-daemon", "-rendezvous-serve"})
+	if err != nil || !cfg.RendezvousServe {
+		t.Fatalf("rendezvous serve: %+v %v", cfg, err)
+	}
+	if _, err := ParseArgs([]string{"-rendezvous-serve", "a", "b"}); err == nil {
+		t.Fatalf("expected rendezvous-serve without daemon error")
+	}
+}
+
+func TestParseArgs_FECInterleave(t *testing.T) {
+	cfg, err := ParseArgs([]string{"a", "b"})
+	if err != nil || cfg.FEC.Depth != 1 {
+		t.Fatalf("default depth: %+v %v", cfg.FEC, err)
+	}
+	cfg, err = ParseArgs([]string{"-fec=8/10", "-fec-interleave=8", "a", "b"})
+	if err != nil || cfg.FEC != (FECConfig{K: 8, N: 10, Depth: 8}) {
+		t.Fatalf("interleave: %+v %v", cfg.FEC, err)
+	}
+	if _, err := ParseArgs([]string{"-fec-interleave=0", "a", "b"}); err == nil {
+		t.Fatalf("expected interleave depth error")
+	}
+}
+
+func TestParseArgs_FountainAndBroadcast(t *testing.T) {
+	cfg, err := ParseArgs([]string{"-fec=fountain", "a", "b"})
+	if err != nil || !cfg.FEC.Fountain || cfg.FEC.Auto || cfg.Broadcast {
+		t.Fatalf("fountain: %+v %v", cfg.FEC, err)
+	}
+	cfg, err = ParseArgs([]string{"-broadcast", "-broadcast-overhead=0.5", "a", "b"})
+	if err != nil || !cfg.FEC.Fountain || cfg.Overhead != 0.5 {
+		t.Fatalf("broadcast: %+v %v", cfg, err)
+	}
+	if _, err := ParseArgs([]string{"-broadcast", "-fec=8/10", "a", "b"}); err == nil {
+		t.Fatalf("expected broadcast with fixed ratio error")
+	}
+	if _, err := ParseArgs([]string{"-broadcast", "-broadcast-overhead=-1", "a", "b"}); err == nil {
+		t.Fatalf("expected overhead error")
+	}
+}
+
+func TestParseArgs_Archive(t *testing.T) {
+	cfg, err := ParseArgs([]string{"-a", "-H", "-safe-links", "-numeric-ids", "a", "b"})
+	if err != nil || !cfg.Archive || !cfg.HardLinks || !cfg.SafeLinks || !cfg.NumericIDs || cfg.CopyLinks {
+		t.Fatalf("short archive flags: %+v %v", cfg, err)
+	}
+	cfg, err = ParseArgs([]string{"-archive", "-copy-links", "a", "b"})
+	if err != nil || !cfg.Archive || !cfg.CopyLinks || cfg.HardLinks || cfg.XAttrs || cfg.ACLs {
+		t.Fatalf("long archive flags: %+v %v", cfg, err)
+	}
+	cfg, err = ParseArgs([]string{"-X", "-A", "a", "b"})
+	if err != nil || !cfg.XAttrs || !cfg.ACLs {
+		t.Fatalf("xattr/acl flags: %+v %v", cfg, err)
+	}
+}
@@ -0,0 +1,55 @@
+package cli
+
+import (
+	"errors"
+	"net/netip"
+	"strings"
+)
+
+type Location struct {
+	User string
+	Host string
+	Path string
+}
+
+func (l Location) IsRemote() bool {
+	return l.Host != ""
+}
+
+// ParseLocation splits an rsync-style operand into [user@]host:path. IPv6
+// literals must be bracketed, as in user@[::1]:/path. Operands with a '/'
+// before the first ':' are local paths.
+func ParseLocation(s string) (Location, error) {
+	if s == "" {
+		return Location{}, errors.New("empty location")
+	}
+	rest := s
+	var user string
+	if at := strings.IndexByte(s, '@'); at >= 0 && !strings.ContainsAny(s[:at], "/:[") {
+		user = s[:at]
+		rest = s[at+1:]
+	}
+	if strings.HasPrefix(rest, "[") {
+		end := strings.IndexByte(rest, ']')
+		if end < 0 {
+			return Location{}, errors.New("unterminated ipv6 literal")
+		}
+		host := rest[1:end]
+		a, err := netip.ParseAddr(host)
+		if err != nil || !a.Is6() {
+			return Location{}, errors.New("invalid ipv6 literal: " + host)
+		}
+		if !strings.HasPrefix(rest[end+1:], ":") {
+			return Location{}, errors.New("expected ':' after ipv6 literal")
+		}
+		return Location{User: user, Host: host, Path: rest[end+2:]}, nil
+	}
+	colon := strings.IndexByte(rest, ':')
+	if colon < 0 || strings.IndexByte(rest[:colon], '/') >= 0 {
+		return Location{Path: s}, nil
+	}
+	if colon == 0 {
+		return Location{}, errors.New("missing host")
+	}
+	return Location{User: user, Host: rest[:colon], Path: rest[colon+1:]}, nil
+}
@@ -0,0 +1,59 @@
+package cli
+
+import "testing"
+
+func TestParseLocation(t *testing.T) {
+	cases := []struct {
+		in   string
+		want Location
+	}{
+		{"/local/path", Location{Path: "/local/path"}},
+		{"rel/dir:with:colons", Location{Path: "rel/dir:with:colons"}},
+		{"host:/data", Location{Host: "host", Path: "/data"}},
+		{"bob@host:rel", Location{User: "bob", Host: "host", Path: "rel"}},
+		{"bob@10.0.0.1:/x", Location{User: "bob", Host: "10.0.0.1", Path: "/x"}},
+		{"user@[::1]:/path", Location{User: "user", Host: "::1", Path: "/path"}},
+		{"[2001:db8::7]:", Location{Host: "2001:db8::7", Path: ""}},
+		{"[fe80::1%eth0]:/p", Location{Host: "fe80::1%eth0", Path: "/p"}},
+	}
+	for _, c := range cases {
+		got, err := ParseLocation(c.in)
+		if err != nil {
+			t.Fatalf("%q: %v", c.in, err)
+		}
+		if got != c.want {
+			t.Fatalf("%q: got %+v want %+v", c.in, got, c.want)
+		}
+	}
+	if !(Location{Host: "h"}).IsRemote() || (Location{Path: "p"}).IsRemote() {
+		t.Fatalf("IsRemote mismatch")
+	}
+}
+
+func TestParseLocationErrors(t *testing.T) {
+	for _, in := range []string{"", "[::1", "[::1]/p", "[10.0.0.1]:/p", "[zz]:/p", ":/p"} {
+		if _, err := ParseLocation(in); err == nil {
+			t.Fatalf("%q: expected error", in)
+		}
+	}
+}
+
+func TestParseArgs_RemoteAndDaemon(t *testing.T) {
+	cfg, err := ParseArgs([]string{"./src", "me@[::1]:/dst"})
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	if cfg.SrcLoc.IsRemote() || cfg.DestLoc.Host != "::1" || cfg.DestLoc.User != "me" {
+		t.Fatalf("locations mismatch: %+v %+v", cfg.SrcLoc, cfg.DestLoc)
+	}
+	if _, err := ParseArgs([]string{"a:/x", "b:/y"}); err == nil {
+		t.Fatalf("expected error for two remotes")
+	}
+	cfg, err = ParseArgs([]string{"-daemon"})
+	if err != nil || !cfg.Daemon {
+		t.Fatalf("daemon parse: %+v %v", cfg, err)
+	}
+	if _, err := ParseArgs([]string{"-daemon", "a", "b"}); err == nil {
+		t.Fatalf("expected error for daemon with operands")
+	}
+}
@@ -9,16 +9,38 @@ type State struct {
 	minRTT       time.Duration
 	maxBandwidth float64
 	lastTime     time.Time
+	lastLoss     time.Time
+
+	// Loss rate: packets delivered and lost, halved every round so the
+	// rate follows recent rounds.
+	lossAcked      float64
+	lossLost       float64
+	lossRoundStart time.Time
+
+	// ECN state: alpha, the share of the rate given up to CE marks, and
+	// the counts of the round trip in progress.
+	ecnAlpha      float64
+	ecnCut        float64
+	ecnAcked      uint64
+	ecnMarked     uint64
+	ecnRoundStart time.Time
 }
 
+// noRTT stands in for the minimum RTT until the first sample.
+const noRTT = time.Hour
+
 func New() *State {
 	return &State{
-		minRTT:       time.Hour,
+		minRTT:       noRTT,
 		maxBandwidth: 0,
 	}
 }
 
+// Update feeds one acknowledged packet. Besides the bandwidth and RTT
+// samples, each call counts as a delivery toward the loss rate.
 func (s *State) Update(deliveredBytes uint64, interval time.Duration, rttSample time.Duration, now time.Time) {
+	s.lossRound(now)
+	s.lossAcked++
 	if interval > 0 {
 		bw := float64(deliveredBytes) / interval.Seconds()
 		if bw > s.maxBandwidth {
@@ -35,14 +57,14 @@ func (s *State) PacingRate() float64 {
 	if s.maxBandwidth <= 0 {
 		return 0
 	}
-	return s.maxBandwidth
+	return s.maxBandwidth * s.ecnScale()
 }
 
 func (s *State) CongestionWindow(payloadBytes int) int {
 	if s.maxBandwidth <= 0 || s.minRTT <= 0 || payloadBytes <= 0 {
 		return 1
 	}
-	bdp := s.maxBandwidth * s.minRTT.Seconds()
+	bdp := s.maxBandwidth * s.ecnScale() * s.minRTT.Seconds()
 	cwnd := int(math.Ceil(bdp / float64(payloadBytes)))
 	if cwnd < 1 {
 		return 1
@@ -50,6 +72,108 @@ func (s *State) CongestionWindow(payloadBytes int) int {
 	return cwnd
 }
 
+// lossBeta is what remains of the bandwidth estimate after a loss.
+const lossBeta = 0.85
+
+// lossCutRate is the share of recent packets that must be lost before a
+// loss cuts the rate. Below it loss is taken for random (radio) loss, as
+// with ECN, which carries the early signal of a queue building; above it
+// a queue is overflowing, with or without ECN on the path.
+const lossCutRate = 0.02
+
+// noRTTRound is the round length until the first RTT sample, the same
+// second the RTT estimator starts its RTO at.
+const noRTTRound = time.Second
+
+// OnLoss reports a packet declared lost. If the recent loss rate is at
+// least lossCutRate, the bandwidth estimate, and with it the pacing rate
+// and window, is cut by lossBeta at most once per round, so the losses of
+// one congestion episode count once.
+func (s *State) OnLoss(now time.Time) {
+	s.lossRound(now)
+	s.lossLost++
+	if s.maxBandwidth <= 0 {
+		return
+	}
+	if !s.lastLoss.IsZero() && now.Sub(s.lastLoss) < s.round() {
+		return
+	}
+	if s.lossLost < lossCutRate*(s.lossAcked+s.lossLost) {
+		return
+	}
+	s.lastLoss = now
+	s.maxBandwidth *= lossBeta
+}
+
+// round is the minimum RTT, or noRTTRound before the first sample.
+func (s *State) round() time.Duration {
+	if s.minRTT == noRTT {
+		return noRTTRound
+	}
+	return s.minRTT
+}
+
+func (s *State) lossRound(now time.Time) {
+	if s.lossRoundStart.IsZero() {
+		s.lossRoundStart = now
+		return
+	}
+	if now.Sub(s.lossRoundStart) >= s.round() {
+		s.lossAcked /= 2
+		s.lossLost /= 2
+		s.lossRoundStart = now
+	}
+}
+
+// The ECN response follows DCTCP: alpha moves toward the CE-marked
+// fraction of each round trip by ecnGain, and a round that saw any mark
+// cuts the rate once by alpha/2. Clean rounds give back ecnRecovery of the
+// full rate, and the cuts never take more than 1-ecnMinScale.
+const (
+	ecnGain     = 1.0 / 16
+	ecnRecovery = 1.0 / 16
+	ecnMinScale = 1.0 / 8
+)
+
+// OnECN feeds newly acknowledged ECN-capable packets and how many of them
+// were CE-marked. Unlike random loss, CE marks mean a queue is building.
+// Counts accumulate until a minimum RTT has passed since the round began;
+// alpha and the rate then change once for the whole round. Before the
+// first RTT sample every call is a round of its own.
+func (s *State) OnECN(acked uint64, marked uint64, now time.Time) {
+	if marked > acked {
+		marked = acked
+	}
+	s.ecnAcked += acked
+	s.ecnMarked += marked
+	if s.ecnRoundStart.IsZero() {
+		s.ecnRoundStart = now
+	}
+	if s.minRTT != noRTT && now.Sub(s.ecnRoundStart) < s.minRTT {
+		return
+	}
+	if s.ecnAcked > 0 {
+		frac := float64(s.ecnMarked) / float64(s.ecnAcked)
+		s.ecnAlpha = (1-ecnGain)*s.ecnAlpha + ecnGain*frac
+		if s.ecnMarked > 0 {
+			s.ecnCut = 1 - (1-s.ecnCut)*(1-s.ecnAlpha/2)
+		} else {
+			s.ecnCut = math.Max(0, s.ecnCut-ecnRecovery)
+		}
+		s.ecnCut = math.Min(s.ecnCut, 1-ecnMinScale)
+	}
+	s.ecnAcked, s.ecnMarked = 0, 0
+	s.ecnRoundStart = now
+}
+
+func (s *State) ECNAlpha() float64 {
+	return s.ecnAlpha
+}
+
+func (s *State) ecnScale() float64 {
+	return 1 - s.ecnCut
+}
+
 func AdjustPayload(current int, min int, max int, lossRate float64, corruptionRate float64) int {
 	if current < min {
 		current = min
@@ -62,3 +62,54 @@ func TestAdjustPayload(t *testing.T) {
 		t.Fatalf("max bound violated: %d", got)
 	}
 }
+
+func TestState_OnLossOncePerRTT(t *testing.T) {
+	s := New()
+	now := time.Unix(0, 0)
+	s.Update(100000, 100*time.Millisecond, 50*time.Millisecond, now)
+	rate := s.PacingRate()
+	s.OnLoss(now)
+	cut := s.PacingRate()
+	if cut >= rate {
+		t.Fatalf("loss did not reduce the rate: %v -> %v", rate, cut)
+	}
+	s.OnLoss(now.Add(10 * time.Millisecond))
+	if s.PacingRate() != cut {
+		t.Fatalf("second loss within one RTT cut again")
+	}
+	s.OnLoss(now.Add(60 * time.Millisecond))
+	if s.PacingRate() >= cut {
+		t.Fatalf("loss in the next round not applied")
+	}
+}
+
+func TestState_OnLossBeforeRTTSample(t *testing.T) {
+	s := New()
+	now := time.Unix(0, 0)
+	s.Update(100000, 100*time.Millisecond, 0, now)
+	s.OnLoss(now)
+	cut := s.PacingRate()
+	s.OnLoss(now.Add(noRTTRound))
+	if s.PacingRate() >= cut {
+		t.Fatalf("loss a round later not applied before the first RTT sample")
+	}
+}
+
+func TestState_OnLossIgnoresRandomLoss(t *testing.T) {
+	s := New()
+	now := time.Unix(0, 0)
+	for i := 0; i < 200; i++ {
+		s.Update(1000, time.Millisecond, 50*time.Millisecond, now)
+	}
+	rate := s.PacingRate()
+	s.OnLoss(now)
+	if s.PacingRate() != rate {
+		t.Fatalf("one loss in 200 packets cut the rate")
+	}
+	for i := 0; i < 10; i++ {
+		s.OnLoss(now)
+	}
+	if s.PacingRate() >= rate {
+		t.Fatalf("loss above %.0f%% did not cut the rate", lossCutRate*100)
+	}
+}
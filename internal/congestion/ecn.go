@@ -0,0 +1,34 @@
+package congestion
+
+// ECNFeedback turns the cumulative ECN counts echoed in ACKs into per-ACK
+// deltas for State.OnECN and validates that the path preserves ECN marks.
+// If newly acknowledged ECT packets do not show up in the counts, a middlebox
+// is bleaching or dropping the field and ECN should be turned off.
+type ECNFeedback struct {
+	ect0   uint64
+	ect1   uint64
+	ce     uint64
+	failed bool
+}
+
+func (f *ECNFeedback) Capable() bool {
+	return !f.failed
+}
+
+// OnAck takes the number of ECT-marked packets newly acknowledged by this
+// ACK and the counts it carried, and returns how many of those were
+// reported and how many were CE-marked. Stale ACKs with smaller counts are
+// ignored.
+func (f *ECNFeedback) OnAck(newlyAcked uint64, ect0, ect1, ce uint64) (uint64, uint64) {
+	if f.failed || ect0 < f.ect0 || ect1 < f.ect1 || ce < f.ce {
+		return 0, 0
+	}
+	reported := (ect0 - f.ect0) + (ect1 - f.ect1) + (ce - f.ce)
+	marked := ce - f.ce
+	f.ect0, f.ect1, f.ce = ect0, ect1, ce
+	if reported < newlyAcked {
+		f.failed = true
+		return 0, 0
+	}
+	return reported, marked
+}
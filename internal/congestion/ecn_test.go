@@ -0,0 +1,85 @@
+package congestion
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestOnECNReducesAndRecovers(t *testing.T) {
+	s := New()
+	now := time.Unix(0, 0)
+	s.Update(14000, 10*time.Millisecond, 50*time.Millisecond, now)
+	base := s.PacingRate()
+	cwnd := s.CongestionWindow(1400)
+
+	// One ACK per round trip.
+	for i := 0; i < 32; i++ {
+		now = now.Add(50 * time.Millisecond)
+		s.OnECN(10, 10, now)
+	}
+	if s.PacingRate() >= base || s.CongestionWindow(1400) >= cwnd {
+		t.Fatalf("CE marks should reduce rate: %f -> %f", base, s.PacingRate())
+	}
+	if s.PacingRate() < base*ecnMinScale {
+		t.Fatalf("reduction bounded by %v, got %f of %f", ecnMinScale, s.PacingRate(), base)
+	}
+
+	marked := s.PacingRate()
+	for i := 0; i < 64; i++ {
+		now = now.Add(50 * time.Millisecond)
+		s.OnECN(10, 0, now)
+	}
+	if s.PacingRate() <= marked {
+		t.Fatalf("unmarked ACKs should let the rate recover")
+	}
+	now = now.Add(50 * time.Millisecond)
+	s.OnECN(0, 5, now)
+	if s.ECNAlpha() < 0 || s.ECNAlpha() > 1 {
+		t.Fatalf("alpha out of range: %f", s.ECNAlpha())
+	}
+}
+
+func TestOnECNOncePerRound(t *testing.T) {
+	s := New()
+	now := time.Unix(0, 0)
+	s.Update(14000, 10*time.Millisecond, 50*time.Millisecond, now)
+	base := s.PacingRate()
+
+	// A window's worth of marked ACKs within one round trip...
+	for i := 0; i < 100; i++ {
+		s.OnECN(1, 1, now.Add(time.Duration(i)*400*time.Microsecond))
+	}
+	if s.PacingRate() != base || s.ECNAlpha() != 0 {
+		t.Fatalf("rate changed before the round ended: alpha %f", s.ECNAlpha())
+	}
+	// ...and one more, half of it marked, that closes the round: alpha
+	// moves once, toward the round's marked fraction, and the rate is cut
+	// once.
+	s.OnECN(2, 1, now.Add(50*time.Millisecond))
+	wantAlpha := ecnGain * 101 / 102
+	if math.Abs(s.ECNAlpha()-wantAlpha) > 1e-12 {
+		t.Fatalf("alpha %f want %f", s.ECNAlpha(), wantAlpha)
+	}
+	if want := base * (1 - wantAlpha/2); math.Abs(s.PacingRate()-want) > 1e-6*base {
+		t.Fatalf("rate %f want %f", s.PacingRate(), want)
+	}
+}
+
+func TestECNFeedbackDeltasAndValidation(t *testing.T) {
+	var f ECNFeedback
+	acked, marked := f.OnAck(3, 3, 0, 0)
+	if acked != 3 || marked != 0 {
+		t.Fatalf("first ack: %d %d", acked, marked)
+	}
+	acked, marked = f.OnAck(2, 4, 0, 1)
+	if acked != 2 || marked != 1 {
+		t.Fatalf("second ack: %d %d", acked, marked)
+	}
+	if a, m := f.OnAck(1, 3, 0, 1); a != 0 || m != 0 || !f.Capable() {
+		t.Fatalf("stale ack should be ignored")
+	}
+	if a, _ := f.OnAck(5, 4, 0, 1); a != 0 || f.Capable() {
+		t.Fatalf("bleached path should fail validation")
+	}
+}
@@ -0,0 +1,48 @@
+package congestion
+
+import "time"
+
+// RTTEstimator keeps the smoothed RTT and variance of RFC 6298.
+type RTTEstimator struct {
+	srtt   time.Duration
+	rttvar time.Duration
+	min    time.Duration
+	init   bool
+}
+
+func (r *RTTEstimator) Update(sample time.Duration) {
+	if sample <= 0 {
+		return
+	}
+	if !r.init {
+		r.srtt = sample
+		r.rttvar = sample / 2
+		r.min = sample
+		r.init = true
+		return
+	}
+	if sample < r.min {
+		r.min = sample
+	}
+	d := r.srtt - sample
+	if d < 0 {
+		d = -d
+	}
+	r.rttvar = (3*r.rttvar + d) / 4
+	r.srtt = (7*r.srtt + sample) / 8
+}
+
+func (r *RTTEstimator) Measured() bool      { return r.init }
+func (r *RTTEstimator) SRTT() time.Duration { return r.srtt }
+func (r *RTTEstimator) Min() time.Duration  { return r.min }
+
+func (r *RTTEstimator) RTO() time.Duration {
+	if !r.init {
+		return time.Second
+	}
+	rto := r.srtt + 4*r.rttvar
+	if rto < 200*time.Millisecond {
+		rto = 200 * time.Millisecond
+	}
+	return rto
+}
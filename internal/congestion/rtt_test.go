@@ -0,0 +1,30 @@
+package congestion
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRTTEstimator(t *testing.T) {
+	var r RTTEstimator
+	if r.Measured() || r.RTO() != time.Second {
+		t.Fatalf("unmeasured defaults wrong")
+	}
+	r.Update(100 * time.Millisecond)
+	if r.SRTT() != 100*time.Millisecond || r.Min() != 100*time.Millisecond {
+		t.Fatalf("first sample: srtt=%v", r.SRTT())
+	}
+	for i := 0; i < 50; i++ {
+		r.Update(40 * time.Millisecond)
+	}
+	if r.SRTT() > 45*time.Millisecond || r.Min() != 40*time.Millisecond {
+		t.Fatalf("srtt did not converge: %v", r.SRTT())
+	}
+	if r.RTO() < 200*time.Millisecond {
+		t.Fatalf("rto below floor: %v", r.RTO())
+	}
+	r.Update(0)
+	if r.Min() != 40*time.Millisecond {
+		t.Fatalf("zero sample should be ignored")
+	}
+}
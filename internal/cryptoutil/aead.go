@@ -33,6 +33,20 @@ func (a *AEAD) Nonce() [12]byte {
 	return n
 }
 
+// PutNonce writes the next nonce into n[:12]. With SealNonce it lets a
+// caller place the nonce directly in an outgoing packet.
+func (a *AEAD) PutNonce(n []byte) {
+	copy(n[:4], a.prefix[:])
+	binary.BigEndian.PutUint64(n[4:12], a.ctr.Add(1))
+}
+
+// SealNonce appends the sealed plaintext to dst under a nonce obtained from
+// PutNonce. Like cipher.AEAD, plaintext may be dst's spare capacity for an
+// in-place seal.
+func (a *AEAD) SealNonce(dst, nonce, plaintext, aad []byte) []byte {
+	return a.aead.Seal(dst, nonce[:12], plaintext, aad)
+}
+
 func (a *AEAD) Seal(dst, plaintext, aad []byte) ([]byte, [12]byte) {
 	n := a.Nonce()
 	out := a.aead.Seal(dst, n[:], plaintext, aad)
@@ -50,6 +64,15 @@ func (a *AEAD) Open(dst, ciphertext, aad []byte, nonce [12]byte) ([]byte, error)
 	return out, nil
 }
 
+// OpenNonce is Open with the nonce given as a slice, typically aliasing the
+// received packet.
+func (a *AEAD) OpenNonce(dst, nonce, ciphertext, aad []byte) ([]byte, error) {
+	if len(nonce) < 12 || len(ciphertext) < a.aead.Overhead() {
+		return nil, errors.New("ciphertext too short")
+	}
+	return a.aead.Open(dst, nonce[:12], ciphertext, aad)
+}
+
 func Overhead() int {
 	return chacha20poly1305.Overhead
 }
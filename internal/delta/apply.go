@@ -0,0 +1,170 @@
+package delta
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/zeebo/blake3"
+
+	"riptide/internal/checksum"
+)
+
+// ErrChecksumMismatch means the rebuilt file does not hash to the sender's
+// whole-file BLAKE3; the destination is left untouched.
+var ErrChecksumMismatch = errors.New("rebuilt file checksum mismatch")
+
+// copyBuf bounds how much of a COPY range is held in memory at once.
+const copyBuf = 256 << 10
+
+// ChunkReader supplies the data behind OpChunk references, writing exactly
+// n bytes whose hash is h to w or failing.
+type ChunkReader interface {
+	ReadChunk(h checksum.Sum128, n int, w io.Writer) error
+}
+
+// ApplyOptions extends ApplyDeltaFile.
+type ApplyOptions struct {
+	// Chunks resolves OpChunk instructions; without it they are an error.
+	Chunks ChunkReader
+	// BeforeRename runs on the verified temp file just before it is
+	// fsynced and renamed over the destination, so attributes set there
+	// (extended attributes, ACLs) appear atomically with the content. An
+	// error aborts the apply and leaves the destination untouched.
+	BeforeRename func(tmp *os.File) error
+}
+
+// ApplyDeltaTo writes the file described by the instructions in dr to w,
+// reading COPY ranges from basis (basisSize bytes long), and returns the
+// BLAKE3-256 of everything written. A COPY outside the basis is an error.
+func ApplyDeltaTo(w io.Writer, basis io.ReaderAt, basisSize int64, dr *DeltaReader) ([32]byte, error) {
+	return applyDelta(w, basis, basisSize, nil, dr)
+}
+
+func applyDelta(w io.Writer, basis io.ReaderAt, basisSize int64, chunks ChunkReader, dr *DeltaReader) ([32]byte, error) {
+	h := blake3.New()
+	out := io.MultiWriter(w, h)
+	var buf []byte
+	var sum [32]byte
+	for {
+		ins, err := dr.Next()
+		if err == io.EOF {
+			copy(sum[:], h.Sum(nil))
+			return sum, nil
+		}
+		if err != nil {
+			return sum, err
+		}
+		switch ins.Op {
+		case OpLiteral:
+			if _, err := out.Write(ins.Data); err != nil {
+				return sum, err
+			}
+		case OpCopy:
+			off, n := int64(ins.SrcOff), int64(ins.Len)
+			if off < 0 || n < 0 || off > basisSize || n > basisSize-off {
+				return sum, fmt.Errorf("copy of %d bytes at %d is outside the %d byte basis", n, off, basisSize)
+			}
+			if buf == nil {
+				buf = make([]byte, copyBuf)
+			}
+			if _, err := io.CopyBuffer(out, io.NewSectionReader(basis, off, n), buf); err != nil {
+				return sum, err
+			}
+		case OpChunk:
+			if chunks == nil {
+				return sum, errors.New("chunk reference without a chunk index")
+			}
+			if err := chunks.ReadChunk(ins.Chunk, ins.Len, out); err != nil {
+				return sum, err
+			}
+		}
+	}
+}
+
+// ApplyDeltaFile rebuilds dest from its current contents and the delta in
+// dr. The result goes to a temp file in dest's directory, is fsynced and
+// checked against want (the sender's whole-file BLAKE3-256), and only then
+// renamed over dest, so a failed or interrupted apply never leaves a
+// partial file behind. A missing dest is treated as an empty basis.
+func ApplyDeltaFile(dest string, dr *DeltaReader, want [32]byte) error {
+	return ApplyDeltaFileWith(dest, dr, want, ApplyOptions{})
+}
+
+// ApplyDeltaFileWith is ApplyDeltaFile with options.
+func ApplyDeltaFileWith(dest string, dr *DeltaReader, want [32]byte, opts ApplyOptions) (err error) {
+	var basis io.ReaderAt = emptyBasis{}
+	var basisSize int64
+	mode := os.FileMode(0o644)
+	if f, err := os.Open(dest); err == nil {
+		defer f.Close()
+		st, err := f.Stat()
+		if err != nil {
+			return err
+		}
+		if !st.Mode().IsRegular() {
+			return fmt.Errorf("%s is not a regular file", dest)
+		}
+		basis, basisSize, mode = f, st.Size(), st.Mode().Perm()
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	dir := filepath.Dir(dest)
+	tmp, err := os.CreateTemp(dir, "."+filepath.Base(dest)+".riptide-*")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			tmp.Close()
+			os.Remove(tmp.Name())
+		}
+	}()
+	bw := bufio.NewWriterSize(tmp, copyBuf)
+	got, err := applyDelta(bw, basis, basisSize, opts.Chunks, dr)
+	if err != nil {
+		return err
+	}
+	if err = bw.Flush(); err != nil {
+		return err
+	}
+	if got != want {
+		return ErrChecksumMismatch
+	}
+	if err = tmp.Chmod(mode); err != nil {
+		return err
+	}
+	if opts.BeforeRename != nil {
+		if err = opts.BeforeRename(tmp); err != nil {
+			return err
+		}
+	}
+	if err = tmp.Sync(); err != nil {
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+	if err = os.Rename(tmp.Name(), dest); err != nil {
+		return err
+	}
+	return syncDir(dir)
+}
+
+// syncDir makes a rename in dir durable.
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
+
+type emptyBasis struct{}
+
+func (emptyBasis) ReadAt([]byte, int64) (int, error) { return 0, io.EOF }
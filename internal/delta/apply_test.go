@@ -0,0 +1,142 @@
+package delta
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func encodeDelta(t *testing.T, d []DeltaInstruction) *DeltaReader {
+	t.Helper()
+	var wire bytes.Buffer
+	if err := WriteDelta(&wire, d); err != nil {
+		t.Fatalf("encode delta: %v", err)
+	}
+	return NewDeltaReader(&wire)
+}
+
+func listDir(t *testing.T, dir string) []string {
+	t.Helper()
+	ents, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, e := range ents {
+		names = append(names, e.Name())
+	}
+	return names
+}
+
+func TestApplyDeltaFile(t *testing.T) {
+	dir := t.TempDir()
+	dest := filepath.Join(dir, "image.bin")
+	basis := randomData(20, 1<<20+5)
+	if err := os.WriteFile(dest, basis, 0o640); err != nil {
+		t.Fatal(err)
+	}
+	newData := cat(basis[:300_000], randomData(21, 4000), basis[310_000:], basis[:copyBuf+1])
+
+	d := ComputeDelta(ComputeFileSig(basis, 2048), newData)
+	if err := ApplyDeltaFile(dest, encodeDelta(t, d), Strong256(newData)); err != nil {
+		t.Fatalf("apply: %v", err)
+	}
+	got, err := os.ReadFile(dest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, newData) {
+		t.Fatalf("rebuilt file differs")
+	}
+	if st, _ := os.Stat(dest); st.Mode().Perm() != 0o640 {
+		t.Fatalf("mode %v not preserved", st.Mode())
+	}
+	if names := listDir(t, dir); len(names) != 1 {
+		t.Fatalf("leftover files: %v", names)
+	}
+}
+
+func TestApplyDeltaFileNewFile(t *testing.T) {
+	dir := t.TempDir()
+	dest := filepath.Join(dir, "new.txt")
+	data := []byte("fresh contents")
+	d := ComputeDelta(FileSig{}, data)
+	if err := ApplyDeltaFile(dest, encodeDelta(t, d), Strong256(data)); err != nil {
+		t.Fatalf("apply: %v", err)
+	}
+	if got, _ := os.ReadFile(dest); !bytes.Equal(got, data) {
+		t.Fatalf("got %q", got)
+	}
+}
+
+func TestApplyDeltaFileFailuresLeaveDestination(t *testing.T) {
+	dir := t.TempDir()
+	dest := filepath.Join(dir, "data")
+	basis := randomData(22, 10_000)
+	if err := os.WriteFile(dest, basis, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	check := func(name string, err error) {
+		t.Helper()
+		if err == nil {
+			t.Fatalf("%s: expected error", name)
+		}
+		if got, _ := os.ReadFile(dest); !bytes.Equal(got, basis) {
+			t.Fatalf("%s: destination modified", name)
+		}
+		if names := listDir(t, dir); len(names) != 1 {
+			t.Fatalf("%s: leftover files: %v", name, names)
+		}
+	}
+
+	outOfRange := []DeltaInstruction{{Op: OpCopy, SrcOff: 9_000, Len: 1_001}}
+	check("out of range copy", ApplyDeltaFile(dest, encodeDelta(t, outOfRange), [32]byte{}))
+
+	newData := cat(basis, []byte("tail"))
+	d := ComputeDelta(ComputeFileSig(basis, 512), newData)
+	err := ApplyDeltaFile(dest, encodeDelta(t, d), Strong256(basis))
+	if !errors.Is(err, ErrChecksumMismatch) {
+		t.Fatalf("expected checksum mismatch, got %v", err)
+	}
+	check("checksum mismatch", err)
+
+	var wire bytes.Buffer
+	_ = WriteDelta(&wire, d)
+	truncated := NewDeltaReader(bytes.NewReader(wire.Bytes()[:wire.Len()-1]))
+	check("truncated delta", ApplyDeltaFile(dest, truncated, Strong256(newData)))
+}
+
+func TestApplyDeltaFileBeforeRename(t *testing.T) {
+	dir := t.TempDir()
+	dest := filepath.Join(dir, "out")
+	data := []byte("contents")
+	d := ComputeDelta(FileSig{}, data)
+	var seen string
+	opts := ApplyOptions{BeforeRename: func(tmp *os.File) error {
+		seen = tmp.Name()
+		if _, err := os.Stat(dest); !errors.Is(err, os.ErrNotExist) {
+			t.Errorf("destination exists before rename: %v", err)
+		}
+		got, err := os.ReadFile(tmp.Name())
+		if err != nil || !bytes.Equal(got, data) {
+			t.Errorf("temp file not complete: %q %v", got, err)
+		}
+		return nil
+	}}
+	if err := ApplyDeltaFileWith(dest, encodeDelta(t, d), Strong256(data), opts); err != nil {
+		t.Fatalf("apply: %v", err)
+	}
+	if seen == "" || seen == dest {
+		t.Fatalf("hook saw %q", seen)
+	}
+
+	opts.BeforeRename = func(*os.File) error { return errors.New("refused") }
+	if err := ApplyDeltaFileWith(filepath.Join(dir, "other"), encodeDelta(t, d), Strong256(data), opts); err == nil {
+		t.Fatalf("hook error ignored")
+	}
+	if names := listDir(t, dir); len(names) != 1 {
+		t.Fatalf("leftover files: %v", names)
+	}
+}
@@ -0,0 +1,311 @@
+package delta
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"math/bits"
+	"os"
+	"path/filepath"
+	"slices"
+
+	"riptide/internal/checksum"
+)
+
+// CDCParams bounds content-defined chunk sizes. Avg should be a power of
+// two; cut points are found with FastCDC's normalized chunking, which uses
+// a stricter mask before Avg and a looser one after it so sizes cluster
+// around Avg.
+type CDCParams struct {
+	Min int
+	Avg int
+	Max int
+}
+
+// DefaultCDC suits build artifacts and similar trees of medium files.
+var DefaultCDC = CDCParams{Min: 2 << 10, Avg: 8 << 10, Max: 64 << 10}
+
+func (p CDCParams) validate() error {
+	if p.Min <= 0 || p.Avg < p.Min || p.Max < p.Avg || p.Avg < 4 {
+		return errors.New("invalid chunking parameters")
+	}
+	return nil
+}
+
+// gear maps each byte to a random 64-bit value for the rolling gear hash.
+// The table is fixed so every peer cuts identical data at identical points.
+var gear = func() (t [256]uint64) {
+	s := uint64(0x52495054494445) // "RIPTIDE"
+	for i := range t {
+		s += 0x9e3779b97f4a7c15
+		z := s
+		z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+		z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+		t[i] = z ^ (z >> 31)
+	}
+	return t
+}()
+
+// highMask returns a mask of the top n bits. The gear hash shifts left, so
+// its high bits depend on the most recent 64 bytes while low bits only see
+// the last few.
+func highMask(n int) uint64 {
+	return ^uint64(0) << (64 - n)
+}
+
+// cut returns the length of the first chunk of data.
+func (p CDCParams) cut(data []byte) int {
+	n := len(data)
+	if n <= p.Min {
+		return n
+	}
+	if n > p.Max {
+		n = p.Max
+	}
+	avgBits := bits.Len(uint(p.Avg)) - 1
+	maskS, maskL := highMask(avgBits+1), highMask(avgBits-1)
+	normal := min(p.Avg, n)
+	var fp uint64
+	i := p.Min
+	for ; i < normal; i++ {
+		fp = fp<<1 + gear[data[i]]
+		if fp&maskS == 0 {
+			return i + 1
+		}
+	}
+	for ; i < n; i++ {
+		fp = fp<<1 + gear[data[i]]
+		if fp&maskL == 0 {
+			return i + 1
+		}
+	}
+	return n
+}
+
+// Chunker splits a stream into content-defined chunks, holding at most a
+// few Max-sized windows in memory.
+type Chunker struct {
+	r          io.Reader
+	p          CDCParams
+	buf        []byte
+	start, end int
+	eof        bool
+}
+
+func NewChunker(r io.Reader, p CDCParams) (*Chunker, error) {
+	if err := p.validate(); err != nil {
+		return nil, err
+	}
+	return &Chunker{r: r, p: p, buf: make([]byte, 4*p.Max)}, nil
+}
+
+// Next returns the next chunk, valid until the following call, or io.EOF.
+func (c *Chunker) Next() ([]byte, error) {
+	if c.end-c.start < c.p.Max && !c.eof {
+		c.end = copy(c.buf, c.buf[c.start:c.end])
+		c.start = 0
+		n, err := io.ReadFull(c.r, c.buf[c.end:])
+		c.end += n
+		switch {
+		case err == io.EOF || err == io.ErrUnexpectedEOF:
+			c.eof = true
+		case err != nil:
+			return nil, err
+		}
+	}
+	if c.start == c.end {
+		return nil, io.EOF
+	}
+	n := c.p.cut(c.buf[c.start:c.end])
+	chunk := c.buf[c.start : c.start+n]
+	c.start += n
+	return chunk, nil
+}
+
+// ChunkSet is the set of chunk hashes a receiver advertises.
+type ChunkSet map[checksum.Sum128]struct{}
+
+// Chunk set wire format: magic u16 | reserved u16 | count u64, then count
+// 16-byte hashes in ascending order.
+const (
+	chunkSetMagic     = 0x4353 // "CS"
+	chunkSetHeaderLen = 12
+)
+
+func (s ChunkSet) WriteTo(w io.Writer) (int64, error) {
+	hashes := make([]checksum.Sum128, 0, len(s))
+	for h := range s {
+		hashes = append(hashes, h)
+	}
+	slices.SortFunc(hashes, func(a, b checksum.Sum128) int {
+		return bytes.Compare(a[:], b[:])
+	})
+	cw := &countWriter{w: w}
+	bw := bufio.NewWriter(cw)
+	var hdr [chunkSetHeaderLen]byte
+	binary.BigEndian.PutUint16(hdr[0:2], chunkSetMagic)
+	binary.BigEndian.PutUint64(hdr[4:12], uint64(len(hashes)))
+	bw.Write(hdr[:])
+	for _, h := range hashes {
+		bw.Write(h[:])
+	}
+	err := bw.Flush()
+	return cw.n, err
+}
+
+func ReadChunkSet(r io.Reader) (ChunkSet, error) {
+	br := bufio.NewReader(r)
+	var hdr [chunkSetHeaderLen]byte
+	if _, err := io.ReadFull(br, hdr[:]); err != nil {
+		return nil, err
+	}
+	if binary.BigEndian.Uint16(hdr[0:2]) != chunkSetMagic {
+		return nil, errors.New("not a chunk set stream")
+	}
+	n := binary.BigEndian.Uint64(hdr[4:12])
+	if n > maxSigBlocks {
+		return nil, errors.New("chunk set too large")
+	}
+	s := make(ChunkSet, min(n, 1<<16))
+	for i := uint64(0); i < n; i++ {
+		var h checksum.Sum128
+		if _, err := io.ReadFull(br, h[:]); err != nil {
+			return nil, unexpected(err)
+		}
+		s[h] = struct{}{}
+	}
+	return s, nil
+}
+
+// WriteChunkDelta chunks r and writes a delta that references every chunk
+// found in have and sends the rest as literals. It streams: memory use is
+// bounded by the chunker window regardless of file size.
+func WriteChunkDelta(w io.Writer, have ChunkSet, r io.Reader, p CDCParams) error {
+	c, err := NewChunker(r, p)
+	if err != nil {
+		return err
+	}
+	dw := NewDeltaWriter(w)
+	for {
+		chunk, err := c.Next()
+		if err == io.EOF {
+			return dw.Close()
+		}
+		if err != nil {
+			return err
+		}
+		h := checksum.Compute128(chunk)
+		if _, ok := have[h]; ok {
+			err = dw.Chunk(h, len(chunk))
+		} else {
+			err = dw.Literal(chunk)
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// ChunkIndex locates chunks in the files under a receiver's tree. It
+// implements ChunkReader, so a delta's OpChunk references can be copied
+// from any indexed file.
+type ChunkIndex struct {
+	root  string
+	files []string
+	refs  map[checksum.Sum128]chunkRef
+}
+
+type chunkRef struct {
+	file int
+	off  int64
+	n    int
+}
+
+// IndexTree chunks every regular file under root. Symlinks are not
+// followed.
+func IndexTree(root string, p CDCParams) (*ChunkIndex, error) {
+	idx := &ChunkIndex{root: root, refs: make(map[checksum.Sum128]chunkRef)}
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		return idx.addFile(rel, p)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return idx, nil
+}
+
+func (idx *ChunkIndex) addFile(rel string, p CDCParams) error {
+	f, err := os.Open(filepath.Join(idx.root, rel))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	c, err := NewChunker(f, p)
+	if err != nil {
+		return err
+	}
+	file := len(idx.files)
+	idx.files = append(idx.files, rel)
+	var off int64
+	for {
+		chunk, err := c.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		h := checksum.Compute128(chunk)
+		if _, ok := idx.refs[h]; !ok {
+			idx.refs[h] = chunkRef{file: file, off: off, n: len(chunk)}
+		}
+		off += int64(len(chunk))
+	}
+}
+
+// Set returns the hashes to advertise to the sender.
+func (idx *ChunkIndex) Set() ChunkSet {
+	s := make(ChunkSet, len(idx.refs))
+	for h := range idx.refs {
+		s[h] = struct{}{}
+	}
+	return s
+}
+
+// ReadChunk copies the chunk from the file it was indexed in, re-checking
+// its hash in case the file changed since. The file is open only for the
+// call, so an index over a large tree holds no descriptors.
+func (idx *ChunkIndex) ReadChunk(h checksum.Sum128, n int, w io.Writer) error {
+	ref, ok := idx.refs[h]
+	if !ok || ref.n != n {
+		return fmt.Errorf("unknown chunk %x", h[:])
+	}
+	f, err := os.Open(filepath.Join(idx.root, idx.files[ref.file]))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	buf := make([]byte, n)
+	if _, err := f.ReadAt(buf, ref.off); err != nil {
+		return fmt.Errorf("chunk %x in %s: %w", h[:], idx.files[ref.file], err)
+	}
+	if checksum.Compute128(buf) != h {
+		return fmt.Errorf("chunk %x in %s changed since indexing", h[:], idx.files[ref.file])
+	}
+	_, err = w.Write(buf)
+	return err
+}
@@ -0,0 +1,196 @@
+package delta
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"riptide/internal/checksum"
+)
+
+func chunkAll(t *testing.T, data []byte, p CDCParams) [][]byte {
+	t.Helper()
+	c, err := NewChunker(bytes.NewReader(data), p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out [][]byte
+	for {
+		chunk, err := c.Next()
+		if err == io.EOF {
+			return out
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		out = append(out, append([]byte(nil), chunk...))
+	}
+}
+
+func TestChunkerBoundaries(t *testing.T) {
+	data := randomData(40, 4<<20)
+	chunks := chunkAll(t, data, DefaultCDC)
+	if got := bytes.Join(chunks, nil); !bytes.Equal(got, data) {
+		t.Fatalf("chunks do not reassemble the input")
+	}
+	for i, c := range chunks {
+		if len(c) > DefaultCDC.Max || (len(c) < DefaultCDC.Min && i != len(chunks)-1) {
+			t.Fatalf("chunk %d is %d bytes", i, len(c))
+		}
+	}
+	if avg := len(data) / len(chunks); avg < DefaultCDC.Avg/2 || avg > DefaultCDC.Avg*2 {
+		t.Fatalf("average chunk %d bytes, want about %d", avg, DefaultCDC.Avg)
+	}
+
+	// Boundaries are content-defined: an insertion near the start only
+	// disturbs the chunks around it.
+	shifted := chunkAll(t, cat([]byte("inserted"), data), DefaultCDC)
+	seen := make(map[checksum.Sum128]bool)
+	for _, c := range chunks {
+		seen[checksum.Compute128(c)] = true
+	}
+	fresh := 0
+	for _, c := range shifted {
+		if !seen[checksum.Compute128(c)] {
+			fresh++
+		}
+	}
+	if fresh > 2 {
+		t.Fatalf("%d of %d chunks changed after a small insertion", fresh, len(shifted))
+	}
+	if _, err := NewChunker(nil, CDCParams{Min: 10, Avg: 5, Max: 20}); err == nil {
+		t.Fatalf("expected invalid params error")
+	}
+}
+
+func TestChunkSetRoundTrip(t *testing.T) {
+	s := ChunkSet{}
+	for i := 0; i < 100; i++ {
+		s[checksum.Compute128([]byte{byte(i)})] = struct{}{}
+	}
+	var wire bytes.Buffer
+	n, err := s.WriteTo(&wire)
+	if err != nil || n != int64(chunkSetHeaderLen+16*len(s)) {
+		t.Fatalf("encode: %d bytes, %v", n, err)
+	}
+	got, err := ReadChunkSet(&wire)
+	if err != nil || len(got) != len(s) {
+		t.Fatalf("decode: %d entries, %v", len(got), err)
+	}
+	for h := range s {
+		if _, ok := got[h]; !ok {
+			t.Fatalf("lost %x", h)
+		}
+	}
+}
+
+func literalBytesIn(t *testing.T, wire []byte) int {
+	t.Helper()
+	d, err := ReadDelta(bytes.NewReader(wire))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return literalBytes(d)
+}
+
+func TestChunkDeltaAcrossFiles(t *testing.T) {
+	dest := t.TempDir()
+	a := randomData(41, 1<<20)
+	b := randomData(42, 1<<20+999)
+	if err := os.MkdirAll(filepath.Join(dest, "sub"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dest, "a.bin"), a, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dest, "sub", "b.bin"), b, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	idx, err := IndexTree(dest, DefaultCDC)
+	if err != nil {
+		t.Fatalf("index: %v", err)
+	}
+	var adv bytes.Buffer
+	if _, err := idx.Set().WriteTo(&adv); err != nil {
+		t.Fatal(err)
+	}
+	have, err := ReadChunkSet(&adv)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		name    string
+		data    []byte
+		maxLits int
+	}{
+		{"renamed", b, 0},
+		{"concatenated", cat(a, b), 2 * DefaultCDC.Max},
+		{"edited copy", cat(a[:500_000], []byte("patch"), a[500_000:]), 2 * DefaultCDC.Max},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			var wire bytes.Buffer
+			if err := WriteChunkDelta(&wire, have, bytes.NewReader(tc.data), DefaultCDC); err != nil {
+				t.Fatalf("delta: %v", err)
+			}
+			if lit := literalBytesIn(t, wire.Bytes()); lit > tc.maxLits {
+				t.Fatalf("%d literal bytes for %d byte file", lit, len(tc.data))
+			}
+			out := filepath.Join(dest, "new-"+tc.name)
+			err := ApplyDeltaFileWith(out, NewDeltaReader(&wire), Strong256(tc.data), ApplyOptions{Chunks: idx})
+			if err != nil {
+				t.Fatalf("apply: %v", err)
+			}
+			if got, _ := os.ReadFile(out); !bytes.Equal(got, tc.data) {
+				t.Fatalf("rebuilt file differs")
+			}
+		})
+	}
+
+	var wire bytes.Buffer
+	if err := WriteChunkDelta(&wire, have, bytes.NewReader(a), DefaultCDC); err != nil {
+		t.Fatal(err)
+	}
+	if err := ApplyDeltaFile(filepath.Join(dest, "x"), NewDeltaReader(bytes.NewReader(wire.Bytes())), Strong256(a)); err == nil {
+		t.Fatalf("chunk references applied without an index")
+	}
+	// A file that changed after indexing must not feed stale chunks.
+	if err := os.WriteFile(filepath.Join(dest, "a.bin"), b[:len(a)], 0o644); err != nil {
+		t.Fatal(err)
+	}
+	err = ApplyDeltaFileWith(filepath.Join(dest, "x"), NewDeltaReader(&wire), Strong256(a), ApplyOptions{Chunks: idx})
+	if err == nil {
+		t.Fatalf("stale chunk accepted")
+	}
+}
+
+func TestChunkIndexLeavesNoFilesOpen(t *testing.T) {
+	fds, err := os.ReadDir("/proc/self/fd")
+	if err != nil {
+		t.Skip("no /proc/self/fd")
+	}
+	dest := t.TempDir()
+	for i := 0; i < 8; i++ {
+		data := bytes.Repeat([]byte{byte(i)}, 4096)
+		if err := os.WriteFile(filepath.Join(dest, fmt.Sprint(i)), data, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	idx, err := IndexTree(dest, DefaultCDC)
+	if err != nil {
+		t.Fatalf("index: %v", err)
+	}
+	for h, ref := range idx.refs {
+		if err := idx.ReadChunk(h, ref.n, io.Discard); err != nil {
+			t.Fatalf("read: %v", err)
+		}
+	}
+	after, _ := os.ReadDir("/proc/self/fd")
+	if len(after) > len(fds) {
+		t.Fatalf("%d files left open after reading %d chunks", len(after)-len(fds), len(idx.refs))
+	}
+}
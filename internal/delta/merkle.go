@@ -0,0 +1,398 @@
+package delta
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+
+	"github.com/zeebo/blake3"
+)
+
+// MerkleTree is a binary hash tree over a file's fixed-size blocks. Leaves
+// are BLAKE3(0x00 || block) and interior nodes BLAKE3(0x01 || left ||
+// right), so a leaf can never be passed off as a node. A level with an odd
+// count promotes its last node unchanged. The shape depends only on the
+// leaf count, which lets two peers compare trees level by level.
+type MerkleTree struct {
+	BlockSize int
+	Size      int64
+	// levels[0] holds the leaves; the last level holds only the root.
+	levels [][][32]byte
+}
+
+func leafHash(block []byte) [32]byte {
+	h := blake3.New()
+	h.Write([]byte{0})
+	h.Write(block)
+	var out [32]byte
+	h.Sum(out[:0])
+	return out
+}
+
+func nodeHash(l, r [32]byte) [32]byte {
+	var b [65]byte
+	b[0] = 1
+	copy(b[1:33], l[:])
+	copy(b[33:], r[:])
+	return blake3.Sum256(b[:])
+}
+
+// parents hashes one level into the next.
+func parents(level [][32]byte) [][32]byte {
+	out := make([][32]byte, (len(level)+1)/2)
+	for i := range out {
+		if 2*i+1 < len(level) {
+			out[i] = nodeHash(level[2*i], level[2*i+1])
+		} else {
+			out[i] = level[2*i]
+		}
+	}
+	return out
+}
+
+// leafCount is the number of blocks for size bytes; an empty file has one
+// empty block so every tree has a root.
+func leafCount(size int64, blockSize int) int {
+	return max(1, int((size+int64(blockSize)-1)/int64(blockSize)))
+}
+
+// NewMerkleTree builds the tree above already computed leaf hashes.
+func NewMerkleTree(leaves [][32]byte, blockSize int, size int64) (*MerkleTree, error) {
+	if blockSize <= 0 || size < 0 || len(leaves) != leafCount(size, blockSize) {
+		return nil, errors.New("leaf count does not match size and block size")
+	}
+	t := &MerkleTree{BlockSize: blockSize, Size: size, levels: [][][32]byte{leaves}}
+	for l := leaves; len(l) > 1; {
+		l = parents(l)
+		t.levels = append(t.levels, l)
+	}
+	return t, nil
+}
+
+// BuildMerkle reads size bytes from r one block at a time.
+func BuildMerkle(r io.Reader, size int64, blockSize int) (*MerkleTree, error) {
+	if blockSize <= 0 || size < 0 {
+		return nil, errors.New("invalid size or block size")
+	}
+	n := leafCount(size, blockSize)
+	leaves := make([][32]byte, n)
+	buf := make([]byte, blockSize)
+	left := size
+	for i := range leaves {
+		b := buf[:min(int64(blockSize), left)]
+		if _, err := io.ReadFull(r, b); err != nil {
+			return nil, unexpected(err)
+		}
+		leaves[i] = leafHash(b)
+		left -= int64(len(b))
+	}
+	return NewMerkleTree(leaves, blockSize, size)
+}
+
+func (t *MerkleTree) Root() [32]byte { return t.levels[len(t.levels)-1][0] }
+
+// Leaves is the number of blocks.
+func (t *MerkleTree) Leaves() int { return len(t.levels[0]) }
+
+// Depth is the number of levels including leaves and root.
+func (t *MerkleTree) Depth() int { return len(t.levels) }
+
+// Nodes returns the hashes at the given indexes of a level (0 = leaves).
+// It is what a peer serves while the other side searches for differences.
+func (t *MerkleTree) Nodes(level int, idx []int) ([][32]byte, error) {
+	if level < 0 || level >= len(t.levels) {
+		return nil, fmt.Errorf("no level %d", level)
+	}
+	out := make([][32]byte, len(idx))
+	for i, j := range idx {
+		if j < 0 || j >= len(t.levels[level]) {
+			return nil, fmt.Errorf("no node %d at level %d", j, level)
+		}
+		out[i] = t.levels[level][j]
+	}
+	return out, nil
+}
+
+// NodeSource answers node queries for a remote tree, typically with one
+// network round trip per call.
+type NodeSource interface {
+	Nodes(level int, idx []int) ([][32]byte, error)
+}
+
+// BlockRange is a run of Count blocks starting at First.
+type BlockRange struct {
+	First int
+	Count int
+}
+
+// Bytes converts the range to a byte offset and length within a file of
+// size bytes split into blockSize blocks.
+func (r BlockRange) Bytes(blockSize int, size int64) (off, n int64) {
+	off = int64(r.First) * int64(blockSize)
+	end := min(size, off+int64(r.Count)*int64(blockSize))
+	return off, end - off
+}
+
+// DiffRanges finds the blocks where t and a remote tree of the same shape
+// differ. It walks down from the root, asking remote only for the children
+// of nodes that differ, one batched call per level: O(log n) round trips,
+// and O(changes × log n) hashes transferred.
+func (t *MerkleTree) DiffRanges(remote NodeSource) ([]BlockRange, error) {
+	top := len(t.levels) - 1
+	root, err := remote.Nodes(top, []int{0})
+	if err != nil {
+		return nil, err
+	}
+	if root[0] == t.Root() {
+		return nil, nil
+	}
+	differ := []int{0}
+	for level := top - 1; level >= 0; level-- {
+		var ask []int
+		for _, p := range differ {
+			ask = append(ask, 2*p)
+			if 2*p+1 < len(t.levels[level]) {
+				ask = append(ask, 2*p+1)
+			}
+		}
+		got, err := remote.Nodes(level, ask)
+		if err != nil {
+			return nil, err
+		}
+		if len(got) != len(ask) {
+			return nil, errors.New("remote returned the wrong number of nodes")
+		}
+		differ = differ[:0]
+		for i, j := range ask {
+			if got[i] != t.levels[level][j] {
+				differ = append(differ, j)
+			}
+		}
+	}
+	var out []BlockRange
+	for _, b := range differ {
+		if n := len(out); n > 0 && out[n-1].First+out[n-1].Count == b {
+			out[n-1].Count++
+			continue
+		}
+		out = append(out, BlockRange{First: b, Count: 1})
+	}
+	return out, nil
+}
+
+// Proof returns the sibling hashes needed to check blocks [first,
+// first+count) against the root, in the order VerifyRange consumes them.
+func (t *MerkleTree) Proof(first, count int) ([][32]byte, error) {
+	if first < 0 || count <= 0 || first+count > t.Leaves() {
+		return nil, errors.New("range outside the tree")
+	}
+	var proof [][32]byte
+	lo, hi := first, first+count
+	for _, level := range t.levels[:len(t.levels)-1] {
+		if lo%2 == 1 {
+			proof = append(proof, level[lo-1])
+		}
+		if hi%2 == 1 && hi < len(level) {
+			proof = append(proof, level[hi])
+		}
+		lo, hi = lo/2, (hi+1)/2
+	}
+	return proof, nil
+}
+
+// RangeVerifier checks ranges of a file against a trusted root as they
+// arrive, so data can be verified piecewise long before the whole file is
+// present.
+type RangeVerifier struct {
+	Root      [32]byte
+	BlockSize int
+	Size      int64
+}
+
+// Verify checks that data is the file's content starting at block first,
+// given the proof from Proof. Every block must be whole except the file's
+// last.
+func (v RangeVerifier) Verify(first int, data []byte, proof [][32]byte) error {
+	if v.BlockSize <= 0 {
+		return errors.New("invalid block size")
+	}
+	n := leafCount(v.Size, v.BlockSize)
+	count := max(1, (len(data)+v.BlockSize-1)/v.BlockSize)
+	if first < 0 || first+count > n {
+		return errors.New("range outside the file")
+	}
+	off, want := BlockRange{First: first, Count: count}.Bytes(v.BlockSize, v.Size)
+	if off > v.Size || int64(len(data)) != want {
+		return errors.New("range length does not match the file")
+	}
+	cur := make([][32]byte, count)
+	for i := range cur {
+		cur[i] = leafHash(data[i*v.BlockSize : min(len(data), (i+1)*v.BlockSize)])
+	}
+	lo, hi := first, first+count
+	for width := n; width > 1; width = (width + 1) / 2 {
+		if lo%2 == 1 {
+			if len(proof) == 0 {
+				return errors.New("proof too short")
+			}
+			cur = append([][32]byte{proof[0]}, cur...)
+			proof = proof[1:]
+			lo--
+		}
+		if hi%2 == 1 && hi < width {
+			if len(proof) == 0 {
+				return errors.New("proof too short")
+			}
+			cur = append(cur, proof[0])
+			proof = proof[1:]
+			hi++
+		}
+		cur = parents(cur)
+		lo, hi = lo/2, (hi+1)/2
+	}
+	if len(proof) != 0 {
+		return errors.New("proof too long")
+	}
+	if cur[0] != v.Root {
+		return errors.New("range does not match the root")
+	}
+	return nil
+}
+
+// Sidecar format: magic u16 | reserved u16 | blockSize u32 | size u64 |
+// mtime ns i64 | leaves u64 | root, then the leaf hashes. Interior nodes
+// are recomputed on load and checked against the stored root.
+const (
+	merkleMagic     = 0x4d4b // "MK"
+	merkleHeaderLen = 36 + 32
+)
+
+// SidecarPath is where the tree for path is persisted.
+func SidecarPath(path string) string {
+	return filepath.Join(filepath.Dir(path), "."+filepath.Base(path)+".riptide-merkle")
+}
+
+// encode writes the sidecar form of t; mtime records the file state it
+// describes.
+func (t *MerkleTree) encode(w io.Writer, mtime int64) error {
+	bw := bufio.NewWriter(w)
+	var hdr [merkleHeaderLen]byte
+	binary.BigEndian.PutUint16(hdr[0:2], merkleMagic)
+	binary.BigEndian.PutUint32(hdr[4:8], uint32(t.BlockSize))
+	binary.BigEndian.PutUint64(hdr[8:16], uint64(t.Size))
+	binary.BigEndian.PutUint64(hdr[16:24], uint64(mtime))
+	binary.BigEndian.PutUint64(hdr[24:32], uint64(t.Leaves()))
+	root := t.Root()
+	copy(hdr[36:], root[:])
+	bw.Write(hdr[:])
+	for _, l := range t.levels[0] {
+		bw.Write(l[:])
+	}
+	return bw.Flush()
+}
+
+// decodeMerkle reads a sidecar of length bytes. The leaf count in the
+// header must account for exactly the rest of the file, so a corrupt
+// header cannot make it allocate more than the file holds.
+func decodeMerkle(r io.Reader, length int64) (*MerkleTree, int64, error) {
+	br := bufio.NewReader(r)
+	var hdr [merkleHeaderLen]byte
+	if _, err := io.ReadFull(br, hdr[:]); err != nil {
+		return nil, 0, unexpected(err)
+	}
+	if binary.BigEndian.Uint16(hdr[0:2]) != merkleMagic {
+		return nil, 0, errors.New("not a merkle sidecar")
+	}
+	blockSize := int(binary.BigEndian.Uint32(hdr[4:8]))
+	size := int64(binary.BigEndian.Uint64(hdr[8:16]))
+	mtime := int64(binary.BigEndian.Uint64(hdr[16:24]))
+	n := binary.BigEndian.Uint64(hdr[24:32])
+	if length < merkleHeaderLen || n != uint64(length-merkleHeaderLen)/32 || (length-merkleHeaderLen)%32 != 0 {
+		return nil, 0, errors.New("merkle sidecar length does not match its header")
+	}
+	if blockSize <= 0 || size < 0 || size > math.MaxInt64-int64(blockSize) || n != uint64(leafCount(size, blockSize)) {
+		return nil, 0, errors.New("corrupt merkle sidecar header")
+	}
+	leaves := make([][32]byte, n)
+	for i := range leaves {
+		if _, err := io.ReadFull(br, leaves[i][:]); err != nil {
+			return nil, 0, unexpected(err)
+		}
+	}
+	t, err := NewMerkleTree(leaves, blockSize, size)
+	if err != nil {
+		return nil, 0, err
+	}
+	if root := t.Root(); !bytes.Equal(root[:], hdr[36:]) {
+		return nil, 0, errors.New("merkle sidecar root mismatch")
+	}
+	return t, mtime, nil
+}
+
+// SaveSidecar persists t next to path, stamped with path's current size
+// and mtime, via a temp file and rename.
+func SaveSidecar(path string, t *MerkleTree) (err error) {
+	st, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if st.Size() != t.Size {
+		return errors.New("tree does not match the file size")
+	}
+	side := SidecarPath(path)
+	tmp, err := os.CreateTemp(filepath.Dir(side), filepath.Base(side)+".*")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			tmp.Close()
+			os.Remove(tmp.Name())
+		}
+	}()
+	if err = t.encode(tmp, st.ModTime().UnixNano()); err != nil {
+		return err
+	}
+	if err = tmp.Sync(); err != nil {
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), side)
+}
+
+// ErrStaleSidecar means the file changed after its tree was saved.
+var ErrStaleSidecar = errors.New("merkle sidecar is stale")
+
+// LoadSidecar reads the tree saved for path, failing with ErrStaleSidecar
+// if path's size or mtime no longer match.
+func LoadSidecar(path string) (*MerkleTree, error) {
+	st, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(SidecarPath(path))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	t, mtime, err := decodeMerkle(f, fi.Size())
+	if err != nil {
+		return nil, err
+	}
+	if t.Size != st.Size() || mtime != st.ModTime().UnixNano() {
+		return nil, ErrStaleSidecar
+	}
+	return t, nil
+}
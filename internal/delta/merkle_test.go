@@ -0,0 +1,157 @@
+package delta
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func buildTree(t *testing.T, data []byte, blockSize int) *MerkleTree {
+	t.Helper()
+	tr, err := BuildMerkle(bytes.NewReader(data), int64(len(data)), blockSize)
+	if err != nil {
+		t.Fatalf("build: %v", err)
+	}
+	return tr
+}
+
+// countingSource counts the round trips DiffRanges makes.
+type countingSource struct {
+	t     *MerkleTree
+	calls int
+	nodes int
+}
+
+func (c *countingSource) Nodes(level int, idx []int) ([][32]byte, error) {
+	c.calls++
+	c.nodes += len(idx)
+	return c.t.Nodes(level, idx)
+}
+
+func TestMerkleDiffRanges(t *testing.T) {
+	const block = 4096
+	old := randomData(50, 1000*block+123)
+	changed := append([]byte(nil), old...)
+	changed[5*block+7] ^= 1
+	changed[6*block] ^= 1
+	changed[700*block+1] ^= 1
+	changed[len(changed)-1] ^= 1
+
+	local := buildTree(t, old, block)
+	remote := &countingSource{t: buildTree(t, changed, block)}
+	got, err := local.DiffRanges(remote)
+	if err != nil {
+		t.Fatalf("diff: %v", err)
+	}
+	want := []BlockRange{{5, 2}, {700, 1}, {1000, 1}}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("diff ranges %v want %v", got, want)
+	}
+	if remote.calls != local.Depth() || remote.nodes > 4*2*local.Depth() {
+		t.Fatalf("%d round trips and %d nodes for depth %d", remote.calls, remote.nodes, local.Depth())
+	}
+	if off, n := want[2].Bytes(block, int64(len(old))); off != 1000*block || n != 123 {
+		t.Fatalf("last range bytes %d+%d", off, n)
+	}
+
+	same := &countingSource{t: buildTree(t, old, block)}
+	if got, err := local.DiffRanges(same); err != nil || got != nil || same.calls != 1 {
+		t.Fatalf("identical trees: %v %v after %d calls", got, err, same.calls)
+	}
+}
+
+func TestMerkleRangeProofs(t *testing.T) {
+	const block = 100
+	for _, size := range []int{0, 1, 100, 250, 1234, 6400} {
+		data := randomData(int64(size), size)
+		tr := buildTree(t, data, block)
+		v := RangeVerifier{Root: tr.Root(), BlockSize: block, Size: int64(size)}
+		n := tr.Leaves()
+		for first := 0; first < n; first++ {
+			for count := 1; first+count <= n; count++ {
+				proof, err := tr.Proof(first, count)
+				if err != nil {
+					t.Fatalf("size %d proof %d+%d: %v", size, first, count, err)
+				}
+				off, l := BlockRange{first, count}.Bytes(block, int64(size))
+				chunk := data[off : off+l]
+				if err := v.Verify(first, chunk, proof); err != nil {
+					t.Fatalf("size %d range %d+%d: %v", size, first, count, err)
+				}
+				if len(chunk) > 0 {
+					bad := append([]byte(nil), chunk...)
+					bad[len(bad)/2] ^= 0x80
+					if v.Verify(first, bad, proof) == nil {
+						t.Fatalf("size %d range %d+%d: corrupt data verified", size, first, count)
+					}
+				}
+			}
+		}
+	}
+}
+
+func TestMerkleSidecar(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "disk.img")
+	data := randomData(51, 300_000)
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	tr := buildTree(t, data, 8192)
+	if err := SaveSidecar(path, tr); err != nil {
+		t.Fatalf("save: %v", err)
+	}
+	got, err := LoadSidecar(path)
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if got.Root() != tr.Root() || got.Leaves() != tr.Leaves() || got.BlockSize != 8192 {
+		t.Fatalf("loaded tree differs")
+	}
+
+	later := time.Now().Add(time.Hour)
+	if err := os.Chtimes(path, later, later); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadSidecar(path); !errors.Is(err, ErrStaleSidecar) {
+		t.Fatalf("expected stale sidecar, got %v", err)
+	}
+
+	raw, err := os.ReadFile(SidecarPath(path))
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw[len(raw)-1] ^= 1
+	if _, _, err := decodeMerkle(bytes.NewReader(raw), int64(len(raw))); err == nil {
+		t.Fatalf("corrupt sidecar accepted")
+	}
+	raw[len(raw)-1] ^= 1
+
+	// A header claiming a huge file must be refused before any leaf
+	// buffer is allocated.
+	for _, h := range []struct {
+		size      uint64
+		blockSize uint32
+	}{{1 << 62, 1}, {math.MaxInt64, 1 << 20}, {math.MaxInt64, 1}} {
+		bad := bytes.Clone(raw)
+		binary.BigEndian.PutUint32(bad[4:8], h.blockSize)
+		binary.BigEndian.PutUint64(bad[8:16], h.size)
+		binary.BigEndian.PutUint64(bad[24:32], (h.size+uint64(h.blockSize)-1)/uint64(h.blockSize))
+		if _, _, err := decodeMerkle(bytes.NewReader(bad), int64(len(bad))); err == nil {
+			t.Fatalf("size %d block %d accepted", h.size, h.blockSize)
+		}
+		binary.BigEndian.PutUint64(bad[24:32], uint64(len(bad)-merkleHeaderLen)/32)
+		if _, _, err := decodeMerkle(bytes.NewReader(bad), int64(len(bad))); err == nil {
+			t.Fatalf("size %d block %d with the real leaf count accepted", h.size, h.blockSize)
+		}
+	}
+	if _, _, err := decodeMerkle(bytes.NewReader(raw), int64(len(raw))+32); err == nil {
+		t.Fatalf("sidecar shorter than stated accepted")
+	}
+}
@@ -0,0 +1,56 @@
+package delta
+
+import (
+	"math"
+	"math/bits"
+)
+
+// Block size bounds for BlockSizeFor, as in rsync: small files use 700
+// byte blocks, larger ones about sqrt(size), capped so huge files do not
+// lose all locality.
+const (
+	MinBlockSize = 700
+	MaxBlockSize = 128 << 10
+)
+
+// MinStrongLen is the shortest strong hash StrongLenFor will choose.
+const MinStrongLen = 4
+
+// falseMatchBits sets the target: a whole-file delta wrongly accepts a block
+// with probability below 2^-falseMatchBits.
+const falseMatchBits = 32
+
+// BlockSizeFor picks a signature block size for a file of size bytes:
+// about sqrt(size), rounded down to a multiple of 8, within
+// [MinBlockSize, MaxBlockSize]. Balancing the signature size (size/block
+// entries) against the literal cost of a change (about one block) gives
+// the square root.
+func BlockSizeFor(size int64) int {
+	if size <= MinBlockSize*MinBlockSize {
+		return MinBlockSize
+	}
+	b := int(math.Sqrt(float64(size))) &^ 7
+	return max(MinBlockSize, min(b, MaxBlockSize))
+}
+
+// StrongLenFor picks how many bytes of each block's strong hash to send.
+// The sender tests up to size window positions against blocks entries, so
+// it needs about log2(size) + log2(blocks) bits to expect no false match,
+// plus falseMatchBits of margin. The weak checksum is given no credit:
+// Adler-style sums collide far more often than 32 random bits would.
+func StrongLenFor(size int64, blockSize int) int {
+	if size <= 0 || blockSize <= 0 {
+		return MinStrongLen
+	}
+	blocks := (size + int64(blockSize) - 1) / int64(blockSize)
+	need := bits.Len64(uint64(size)) + bits.Len64(uint64(blocks)) + falseMatchBits
+	return max(MinStrongLen, min((need+7)/8, 32))
+}
+
+// SigParams returns the automatic block size and strong hash length for a
+// file of size bytes. The receiver chooses them and sends them in the
+// signature header, so the sender never needs to agree in advance.
+func SigParams(size int64) (blockSize, strongLen int) {
+	blockSize = BlockSizeFor(size)
+	return blockSize, StrongLenFor(size, blockSize)
+}
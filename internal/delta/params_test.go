@@ -0,0 +1,69 @@
+package delta
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestBlockSizeFor(t *testing.T) {
+	cases := []struct {
+		size int64
+		want int
+	}{
+		{0, MinBlockSize},
+		{10 << 10, MinBlockSize},
+		{MinBlockSize * MinBlockSize, MinBlockSize},
+		{1 << 30, 32768},
+		{50 << 30, MaxBlockSize},
+	}
+	for _, c := range cases {
+		if got := BlockSizeFor(c.size); got != c.want {
+			t.Fatalf("BlockSizeFor(%d) = %d want %d", c.size, got, c.want)
+		}
+	}
+	prev := 0
+	for size := int64(1); size < 1<<40; size = size*3/2 + 1 {
+		b := BlockSizeFor(size)
+		if b < prev || (b != MinBlockSize && b%8 != 0) || b < MinBlockSize || b > MaxBlockSize {
+			t.Fatalf("BlockSizeFor(%d) = %d after %d", size, b, prev)
+		}
+		prev = b
+	}
+}
+
+func TestStrongLenGrowsWithFileSize(t *testing.T) {
+	small, smallLen := SigParams(4 << 10)
+	_, bigLen := SigParams(50 << 30)
+	if small != MinBlockSize || smallLen < MinStrongLen || smallLen >= bigLen || bigLen > 16 {
+		t.Fatalf("strong lengths: 4 KiB %d, 50 GiB %d", smallLen, bigLen)
+	}
+	// A 50 GiB image: ~400k blocks of 128 KiB. The signature stays a few MB
+	// rather than the ~2 GB that fixed 700-byte blocks with full hashes cost.
+	const size = 50 << 30
+	bs, sl := SigParams(size)
+	blocks := (size + int64(bs) - 1) / int64(bs)
+	if sigBytes := blocks * int64(4+sl); sigBytes > 8<<20 {
+		t.Fatalf("50 GiB signature is %d bytes", sigBytes)
+	}
+}
+
+func TestAutoSignatureNegotiatesParams(t *testing.T) {
+	basis := randomData(30, 3<<20)
+	var wire bytes.Buffer
+	if err := WriteSignature(&wire, bytes.NewReader(basis), int64(len(basis)), 0, 0); err != nil {
+		t.Fatalf("encode: %v", err)
+	}
+	sig, err := ReadFileSig(&wire)
+	if err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	bs, sl := SigParams(int64(len(basis)))
+	if sig.BlockSize != bs || sig.StrongLen != sl {
+		t.Fatalf("header carried %d/%d, want %d/%d", sig.BlockSize, sig.StrongLen, bs, sl)
+	}
+	newData := cat(basis[:1<<20], []byte("change"), basis[1<<20+100:])
+	d := ComputeDelta(sig, newData)
+	if !bytes.Equal(ApplyDelta(basis, d), newData) || literalBytes(d) > 6+2*bs {
+		t.Fatalf("delta over auto signature: %d literal bytes", literalBytes(d))
+	}
+}
@@ -1,7 +1,9 @@
 package delta
 
 import (
-	"encoding/hex"
+	"bytes"
+
+	"riptide/internal/checksum"
 )
 
 type Weak uint32
@@ -13,51 +15,87 @@ type BlockSig struct {
 	Len    int
 }
 
+// FileSig lists the basis file's blocks in offset order. The index maps
+// weak checksums to positions in Blocks; the strong hash is only computed
+// on the sender's side when a weak checksum hits. Only the first StrongLen
+// bytes of each Strong are significant (signatures read off the wire carry
+// truncated hashes); 0 means all 32.
 type FileSig struct {
 	BlockSize int
-	byKey     map[string]BlockSig
-}
-
-func keyFor(w Weak, s [32]byte) string {
-	b := make([]byte, 0, 8+64)
-	// weak as 8-hex chars + ':' + strong hex
-	b = append(b, []byte(hexWeak(uint32(w)))...)
-	b = append(b, ':')
-	dst := make([]byte, hex.EncodedLen(len(s)))
-	hex.Encode(dst, s[:])
-	return string(append(b, dst...))
-}
-
-func hexWeak(u uint32) string {
-	const hexdigits = "0123456789abcdef"
-	var b [8]byte
-	for i := 7; i >= 0; i-- {
-		b[i] = hexdigits[u&0xf]
-		u >>= 4
-	}
-	return string(b[:])
+	StrongLen int
+	Blocks    []BlockSig
+	byWeak    map[Weak][]int
 }
 
+// ComputeFileSig splits data into blockSize blocks; blockSize <= 0 picks
+// one with BlockSizeFor. StrongLen is set from StrongLenFor, so matching
+// and the encoded signature both use the truncated hash.
 func ComputeFileSig(data []byte, blockSize int) FileSig {
 	if blockSize <= 0 {
-		blockSize = 1
+		blockSize = BlockSizeFor(int64(len(data)))
 	}
-	m := make(map[string]BlockSig)
+	blocks := make([]BlockSig, 0, (len(data)+blockSize-1)/blockSize)
 	for off := 0; off < len(data); off += blockSize {
 		end := off + blockSize
 		if end > len(data) {
 			end = len(data)
 		}
-		w := Weak(adlerLike(data[off:end]))
-		s := Strong256(data[off:end])
-		m[keyFor(w, s)] = BlockSig{
-			Weak:   w,
-			Strong: s,
+		blocks = append(blocks, BlockSig{
+			Weak:   Weak(adlerLike(data[off:end])),
+			Strong: Strong256(data[off:end]),
 			Offset: off,
 			Len:    end - off,
+		})
+	}
+	sig := FileSig{BlockSize: blockSize, StrongLen: StrongLenFor(int64(len(data)), blockSize), Blocks: blocks}
+	sig.index()
+	return sig
+}
+
+func (s *FileSig) index() {
+	s.byWeak = make(map[Weak][]int, len(s.Blocks))
+	for i, b := range s.Blocks {
+		s.byWeak[b.Weak] = append(s.byWeak[b.Weak], i)
+	}
+}
+
+// match returns the basis block holding exactly data, preferring the one
+// at prefer so consecutive matches coalesce into a single COPY.
+func (s *FileSig) match(w Weak, data []byte, prefer int) (BlockSig, bool) {
+	cands := s.byWeak[w]
+	if len(cands) == 0 {
+		return BlockSig{}, false
+	}
+	n := s.StrongLen
+	if n <= 0 || n > 32 {
+		n = 32
+	}
+	var strong [32]byte
+	hashed := false
+	found := -1
+	for _, i := range cands {
+		b := s.Blocks[i]
+		if b.Len != len(data) {
+			continue
+		}
+		if !hashed {
+			strong = Strong256(data)
+			hashed = true
+		}
+		if !bytes.Equal(b.Strong[:n], strong[:n]) {
+			continue
+		}
+		if b.Offset == prefer {
+			return b, true
 		}
+		if found < 0 {
+			found = i
+		}
+	}
+	if found < 0 {
+		return BlockSig{}, false
 	}
-	return FileSig{BlockSize: blockSize, byKey: m}
+	return s.Blocks[found], true
 }
 
 type Op uint8
@@ -65,6 +103,9 @@ type Op uint8
 const (
 	OpCopy Op = iota + 1
 	OpLiteral
+	// OpChunk copies the Len-byte content-defined chunk whose hash is Chunk
+	// from wherever the receiver has it (see ChunkIndex).
+	OpChunk
 )
 
 type DeltaInstruction struct {
@@ -72,38 +113,86 @@ type DeltaInstruction struct {
 	SrcOff int
 	Len    int
 	Data   []byte
+	Chunk  checksum.Sum128
 }
 
+// ComputeDelta finds basis blocks anywhere in newData, rsync style: a
+// rolling checksum slides over newData one byte at a time, weak hits are
+// confirmed with Strong256, and the bytes between matches become literals.
+// After a match the window jumps a whole block. Adjacent COPYs of
+// contiguous basis ranges and adjacent literals are merged.
 func ComputeDelta(sig FileSig, newData []byte) []DeltaInstruction {
-	if sig.BlockSize <= 0 {
-		sig.BlockSize = 1
-	}
-	var out []DeltaInstruction
-	for off := 0; off < len(newData); off += sig.BlockSize {
-		end := off + sig.BlockSize
-		if end > len(newData) {
-			end = len(newData)
+	bs := sig.BlockSize
+	if bs <= 0 {
+		bs = 1
+	}
+	var b deltaBuilder
+	litStart, pos := 0, 0
+	r := NewRolling(bs)
+	rolling := false
+	for pos+bs <= len(newData) {
+		if !rolling {
+			_ = r.Init(newData[pos : pos+bs])
+			rolling = true
 		}
-		chunk := newData[off:end]
-		w := Weak(adlerLike(chunk))
-		s := Strong256(chunk)
-		if bs, ok := sig.byKey[keyFor(w, s)]; ok {
-			out = append(out, DeltaInstruction{
-				Op:     OpCopy,
-				SrcOff: bs.Offset,
-				Len:    bs.Len,
-			})
+		if m, ok := sig.match(Weak(r.Sum()), newData[pos:pos+bs], b.nextCopy()); ok {
+			b.literal(newData[litStart:pos])
+			b.copy(m.Offset, m.Len)
+			pos += bs
+			litStart = pos
+			rolling = false
 			continue
 		}
-		cp := make([]byte, len(chunk))
-		copy(cp, chunk)
-		out = append(out, DeltaInstruction{
-			Op:   OpLiteral,
-			Len:  len(cp),
-			Data: cp,
-		})
+		if pos+bs < len(newData) {
+			_, _ = r.Roll(newData[pos+bs])
+		}
+		pos++
 	}
-	return out
+	// The basis's last block may be short; it can only match the tail.
+	if tail := newData[pos:]; len(tail) > 0 {
+		if m, ok := sig.match(Weak(adlerLike(tail)), tail, b.nextCopy()); ok {
+			b.literal(newData[litStart:pos])
+			b.copy(m.Offset, m.Len)
+			litStart = len(newData)
+		}
+	}
+	b.literal(newData[litStart:])
+	return b.out
+}
+
+type deltaBuilder struct {
+	out []DeltaInstruction
+}
+
+// nextCopy is the basis offset that would extend the last COPY, or -1.
+func (b *deltaBuilder) nextCopy() int {
+	if n := len(b.out); n > 0 && b.out[n-1].Op == OpCopy {
+		return b.out[n-1].SrcOff + b.out[n-1].Len
+	}
+	return -1
+}
+
+func (b *deltaBuilder) copy(off, n int) {
+	if b.nextCopy() == off {
+		b.out[len(b.out)-1].Len += n
+		return
+	}
+	b.out = append(b.out, DeltaInstruction{Op: OpCopy, SrcOff: off, Len: n})
+}
+
+func (b *deltaBuilder) literal(data []byte) {
+	if len(data) == 0 {
+		return
+	}
+	if n := len(b.out); n > 0 && b.out[n-1].Op == OpLiteral {
+		last := &b.out[n-1]
+		last.Data = append(last.Data, data...)
+		last.Len = len(last.Data)
+		return
+	}
+	cp := make([]byte, len(data))
+	copy(cp, data)
+	b.out = append(b.out, DeltaInstruction{Op: OpLiteral, Len: len(cp), Data: cp})
 }
 
 func ApplyDelta(basis []byte, delta []DeltaInstruction) []byte {
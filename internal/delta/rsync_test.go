@@ -2,6 +2,7 @@ package delta
 
 import (
 	"bytes"
+	"math/rand"
 	"testing"
 )
 
@@ -68,3 +69,77 @@ func TestComputeFileSigAndDelta_TrailingShortBlock(t *testing.T) {
 		t.Fatalf("trailing block identity failed")
 	}
 }
+
+func literalBytes(d []DeltaInstruction) int {
+	n := 0
+	for _, ins := range d {
+		if ins.Op == OpLiteral {
+			n += ins.Len
+		}
+	}
+	return n
+}
+
+func randomData(seed int64, n int) []byte {
+	b := make([]byte, n)
+	rand.New(rand.NewSource(seed)).Read(b)
+	return b
+}
+
+func TestComputeDeltaFindsShiftedBlocks(t *testing.T) {
+	const block = 512
+	basis := randomData(1, 256<<10)
+	ins := randomData(2, 37)
+	mid := len(basis)/2 + 123
+
+	cases := []struct {
+		name    string
+		newData []byte
+		changed int
+	}{
+		{"insert at start", cat([]byte{'x'}, basis), 1},
+		{"insert in middle", cat(basis[:mid], ins, basis[mid:]), len(ins)},
+		{"delete in middle", cat(basis[:mid], basis[mid+1000:]), 0},
+		{"delete at start", basis[77:], 0},
+		{"moved region", cat(basis[mid:], basis[:mid]), 0},
+		{"append", cat(basis, ins), len(ins)},
+	}
+	sig := ComputeFileSig(basis, block)
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			d := ComputeDelta(sig, tc.newData)
+			if out := ApplyDelta(basis, d); !bytes.Equal(out, tc.newData) {
+				t.Fatalf("apply mismatch")
+			}
+			// At most the blocks straddling each edit go out as literals.
+			if lit := literalBytes(d); lit > tc.changed+2*block {
+				t.Fatalf("%d literal bytes for a %d byte change", lit, tc.changed)
+			}
+			for i := 1; i < len(d); i++ {
+				a, b := d[i-1], d[i]
+				if a.Op == OpLiteral && b.Op == OpLiteral {
+					t.Fatalf("adjacent literals at %d", i)
+				}
+				if a.Op == OpCopy && b.Op == OpCopy && a.SrcOff+a.Len == b.SrcOff {
+					t.Fatalf("uncoalesced copies at %d", i)
+				}
+			}
+		})
+	}
+}
+
+func TestComputeDeltaIdentityIsOneCopy(t *testing.T) {
+	basis := randomData(3, 100_000+17)
+	d := ComputeDelta(ComputeFileSig(basis, 700), basis)
+	if len(d) != 1 || d[0].Op != OpCopy || d[0].SrcOff != 0 || d[0].Len != len(basis) {
+		t.Fatalf("identity delta: %+v", d)
+	}
+}
+
+func cat(parts ...[]byte) []byte {
+	var out []byte
+	for _, p := range parts {
+		out = append(out, p...)
+	}
+	return out
+}
@@ -0,0 +1,456 @@
+package delta
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+
+	"riptide/internal/checksum"
+)
+
+// Signature wire format: a 20-byte header followed by Count block entries
+// of a 4-byte weak checksum and the first StrongLen bytes of the strong
+// hash. Every block is BlockSize long except the last, which is LastLen.
+//
+//	magic u16 | strongLen u8 | reserved u8 | blockSize u32 | count u64 | lastLen u32
+const (
+	sigMagic     = 0x5347 // "SG"
+	sigHeaderLen = 20
+	// maxSigBlocks bounds what a decoder will allocate for a hostile count.
+	maxSigBlocks = 1 << 32
+)
+
+// SigHeader describes a signature stream.
+type SigHeader struct {
+	BlockSize int
+	StrongLen int
+	Count     uint64
+	LastLen   int
+}
+
+func (h SigHeader) encode() ([sigHeaderLen]byte, error) {
+	var b [sigHeaderLen]byte
+	if h.BlockSize <= 0 || uint64(h.BlockSize) > 0xffffffff {
+		return b, errors.New("invalid block size")
+	}
+	if h.StrongLen <= 0 || h.StrongLen > 32 {
+		return b, errors.New("invalid strong hash length")
+	}
+	if h.LastLen < 0 || h.LastLen > h.BlockSize || (h.Count > 0) != (h.LastLen > 0) {
+		return b, errors.New("invalid last block length")
+	}
+	binary.BigEndian.PutUint16(b[0:2], sigMagic)
+	b[2] = byte(h.StrongLen)
+	binary.BigEndian.PutUint32(b[4:8], uint32(h.BlockSize))
+	binary.BigEndian.PutUint64(b[8:16], h.Count)
+	binary.BigEndian.PutUint32(b[16:20], uint32(h.LastLen))
+	return b, nil
+}
+
+func decodeSigHeader(b []byte) (SigHeader, error) {
+	if binary.BigEndian.Uint16(b[0:2]) != sigMagic {
+		return SigHeader{}, errors.New("not a signature stream")
+	}
+	h := SigHeader{
+		StrongLen: int(b[2]),
+		BlockSize: int(binary.BigEndian.Uint32(b[4:8])),
+		Count:     binary.BigEndian.Uint64(b[8:16]),
+		LastLen:   int(binary.BigEndian.Uint32(b[16:20])),
+	}
+	if _, err := h.encode(); err != nil {
+		return SigHeader{}, err
+	}
+	if h.Count > maxSigBlocks {
+		return SigHeader{}, errors.New("signature too large")
+	}
+	return h, nil
+}
+
+// SigWriter streams a signature one block at a time, so a signature for a
+// file of any size is produced without holding its blocks in memory.
+type SigWriter struct {
+	w       *bufio.Writer
+	h       SigHeader
+	written uint64
+	err     error
+}
+
+// NewSigWriter writes the header; the caller then calls Block exactly
+// h.Count times and Close.
+func NewSigWriter(w io.Writer, h SigHeader) (*SigWriter, error) {
+	hb, err := h.encode()
+	if err != nil {
+		return nil, err
+	}
+	bw := bufio.NewWriter(w)
+	if _, err := bw.Write(hb[:]); err != nil {
+		return nil, err
+	}
+	return &SigWriter{w: bw, h: h}, nil
+}
+
+func (s *SigWriter) Block(weak Weak, strong [32]byte) error {
+	if s.err != nil {
+		return s.err
+	}
+	if s.written == s.h.Count {
+		return errors.New("more blocks than the header announced")
+	}
+	var b [4 + 32]byte
+	binary.BigEndian.PutUint32(b[:4], uint32(weak))
+	copy(b[4:], strong[:s.h.StrongLen])
+	_, s.err = s.w.Write(b[:4+s.h.StrongLen])
+	s.written++
+	return s.err
+}
+
+func (s *SigWriter) Close() error {
+	if s.err != nil {
+		return s.err
+	}
+	if s.written != s.h.Count {
+		return errors.New("fewer blocks than the header announced")
+	}
+	return s.w.Flush()
+}
+
+// WriteSignature computes and streams the signature of r, which holds size
+// bytes, reading one block at a time. A blockSize or strongLen of 0 is
+// chosen automatically (see SigParams).
+func WriteSignature(w io.Writer, r io.Reader, size int64, blockSize, strongLen int) error {
+	if size < 0 || blockSize < 0 || strongLen < 0 {
+		return errors.New("invalid signature parameters")
+	}
+	if blockSize == 0 {
+		blockSize = BlockSizeFor(size)
+	}
+	if strongLen == 0 {
+		strongLen = StrongLenFor(size, blockSize)
+	}
+	h := SigHeader{BlockSize: blockSize, StrongLen: strongLen}
+	h.Count = uint64((size + int64(blockSize) - 1) / int64(blockSize))
+	if h.Count > 0 {
+		h.LastLen = int(size - int64(h.Count-1)*int64(blockSize))
+	}
+	sw, err := NewSigWriter(w, h)
+	if err != nil {
+		return err
+	}
+	buf := make([]byte, blockSize)
+	for i := uint64(0); i < h.Count; i++ {
+		n := blockSize
+		if i == h.Count-1 {
+			n = h.LastLen
+		}
+		if _, err := io.ReadFull(r, buf[:n]); err != nil {
+			return err
+		}
+		if err := sw.Block(Weak(adlerLike(buf[:n])), Strong256(buf[:n])); err != nil {
+			return err
+		}
+	}
+	return sw.Close()
+}
+
+// WriteTo encodes sig with strong hashes truncated to sig.StrongLen.
+func (sig FileSig) WriteTo(w io.Writer) (int64, error) {
+	h := SigHeader{BlockSize: sig.BlockSize, StrongLen: sig.StrongLen, Count: uint64(len(sig.Blocks))}
+	if h.StrongLen == 0 {
+		h.StrongLen = 32
+	}
+	if n := len(sig.Blocks); n > 0 {
+		h.LastLen = sig.Blocks[n-1].Len
+	}
+	cw := &countWriter{w: w}
+	sw, err := NewSigWriter(cw, h)
+	if err != nil {
+		return 0, err
+	}
+	for _, b := range sig.Blocks {
+		if err := sw.Block(b.Weak, b.Strong); err != nil {
+			return cw.n, err
+		}
+	}
+	err = sw.Close()
+	return cw.n, err
+}
+
+type countWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// ReadFileSig decodes a signature stream and builds its weak index.
+func ReadFileSig(r io.Reader) (FileSig, error) {
+	br := bufio.NewReader(r)
+	var hb [sigHeaderLen]byte
+	if _, err := io.ReadFull(br, hb[:]); err != nil {
+		return FileSig{}, err
+	}
+	h, err := decodeSigHeader(hb[:])
+	if err != nil {
+		return FileSig{}, err
+	}
+	sig := FileSig{BlockSize: h.BlockSize, StrongLen: h.StrongLen}
+	// Grow as entries arrive rather than trusting Count up front.
+	const prealloc = 1 << 16
+	sig.Blocks = make([]BlockSig, 0, min(h.Count, prealloc))
+	var e [4 + 32]byte
+	for i := uint64(0); i < h.Count; i++ {
+		if _, err := io.ReadFull(br, e[:4+h.StrongLen]); err != nil {
+			return FileSig{}, err
+		}
+		b := BlockSig{
+			Weak:   Weak(binary.BigEndian.Uint32(e[:4])),
+			Offset: int(i) * h.BlockSize,
+			Len:    h.BlockSize,
+		}
+		copy(b.Strong[:], e[4:4+h.StrongLen])
+		if i == h.Count-1 {
+			b.Len = h.LastLen
+		}
+		sig.Blocks = append(sig.Blocks, b)
+	}
+	sig.index()
+	return sig, nil
+}
+
+// Delta wire format: a stream of instructions, each a tag byte followed by
+// uvarint fields, ending with deltaEnd.
+//
+//	COPY    1 | srcOff | len
+//	LITERAL 2 | len | len bytes
+//	CHUNK   3 | 16-byte hash | len
+//	END     0
+const (
+	deltaEnd     = 0
+	deltaCopy    = 1
+	deltaLiteral = 2
+	deltaChunk   = 3
+	// MaxLiteral caps a single LITERAL; longer runs are split.
+	MaxLiteral = 1 << 20
+)
+
+// DeltaWriter streams delta instructions, merging a COPY that continues the
+// previous one so per-block producers still emit compact runs.
+type DeltaWriter struct {
+	w       *bufio.Writer
+	pending DeltaInstruction
+	err     error
+}
+
+func NewDeltaWriter(w io.Writer) *DeltaWriter {
+	return &DeltaWriter{w: bufio.NewWriter(w)}
+}
+
+func (d *DeltaWriter) Copy(srcOff, n int) error {
+	if d.err != nil {
+		return d.err
+	}
+	if srcOff < 0 || n <= 0 {
+		return errors.New("invalid copy range")
+	}
+	if d.pending.Op == OpCopy && d.pending.SrcOff+d.pending.Len == srcOff {
+		d.pending.Len += n
+		return nil
+	}
+	d.flushCopy()
+	d.pending = DeltaInstruction{Op: OpCopy, SrcOff: srcOff, Len: n}
+	return d.err
+}
+
+func (d *DeltaWriter) Literal(p []byte) error {
+	d.flushCopy()
+	for len(p) > 0 && d.err == nil {
+		n := min(len(p), MaxLiteral)
+		d.putTag(deltaLiteral, uint64(n))
+		if d.err == nil {
+			_, d.err = d.w.Write(p[:n])
+		}
+		p = p[n:]
+	}
+	return d.err
+}
+
+// Chunk emits a reference to a content-defined chunk the receiver holds.
+func (d *DeltaWriter) Chunk(h checksum.Sum128, n int) error {
+	d.flushCopy()
+	if d.err != nil {
+		return d.err
+	}
+	if n <= 0 {
+		return errors.New("invalid chunk length")
+	}
+	if d.err = d.w.WriteByte(deltaChunk); d.err == nil {
+		if _, d.err = d.w.Write(h[:]); d.err == nil {
+			d.putVarint(uint64(n))
+		}
+	}
+	return d.err
+}
+
+// Write emits one instruction.
+func (d *DeltaWriter) Write(ins DeltaInstruction) error {
+	switch ins.Op {
+	case OpCopy:
+		return d.Copy(ins.SrcOff, ins.Len)
+	case OpLiteral:
+		return d.Literal(ins.Data)
+	case OpChunk:
+		return d.Chunk(ins.Chunk, ins.Len)
+	}
+	return errors.New("unknown delta op")
+}
+
+// Close writes the end marker and flushes; it does not close the
+// underlying writer.
+func (d *DeltaWriter) Close() error {
+	d.flushCopy()
+	if d.err != nil {
+		return d.err
+	}
+	if err := d.w.WriteByte(deltaEnd); err != nil {
+		return err
+	}
+	return d.w.Flush()
+}
+
+func (d *DeltaWriter) flushCopy() {
+	if d.pending.Op != OpCopy || d.err != nil {
+		return
+	}
+	d.putTag(deltaCopy, uint64(d.pending.SrcOff), uint64(d.pending.Len))
+	d.pending = DeltaInstruction{}
+}
+
+func (d *DeltaWriter) putVarint(v uint64) {
+	var arr [binary.MaxVarintLen64]byte
+	_, d.err = d.w.Write(binary.AppendUvarint(arr[:0], v))
+}
+
+func (d *DeltaWriter) putTag(tag byte, fields ...uint64) {
+	var arr [1 + 2*binary.MaxVarintLen64]byte
+	b := append(arr[:0], tag)
+	for _, f := range fields {
+		b = binary.AppendUvarint(b, f)
+	}
+	_, d.err = d.w.Write(b)
+}
+
+// WriteDelta encodes a whole instruction list.
+func WriteDelta(w io.Writer, delta []DeltaInstruction) error {
+	dw := NewDeltaWriter(w)
+	for _, ins := range delta {
+		if err := dw.Write(ins); err != nil {
+			return err
+		}
+	}
+	return dw.Close()
+}
+
+// DeltaReader decodes a delta stream one instruction at a time.
+type DeltaReader struct {
+	r    *bufio.Reader
+	buf  []byte
+	done bool
+}
+
+func NewDeltaReader(r io.Reader) *DeltaReader {
+	return &DeltaReader{r: bufio.NewReader(r)}
+}
+
+// Next returns the next instruction, or io.EOF after the end marker. A
+// literal's Data is only valid until the following call.
+func (d *DeltaReader) Next() (DeltaInstruction, error) {
+	if d.done {
+		return DeltaInstruction{}, io.EOF
+	}
+	tag, err := d.r.ReadByte()
+	if err != nil {
+		return DeltaInstruction{}, unexpected(err)
+	}
+	switch tag {
+	case deltaEnd:
+		d.done = true
+		return DeltaInstruction{}, io.EOF
+	case deltaCopy:
+		off, err := binary.ReadUvarint(d.r)
+		if err != nil {
+			return DeltaInstruction{}, unexpected(err)
+		}
+		n, err := binary.ReadUvarint(d.r)
+		if err != nil {
+			return DeltaInstruction{}, unexpected(err)
+		}
+		if n == 0 || off > maxInt || n > maxInt-off {
+			return DeltaInstruction{}, errors.New("invalid copy range")
+		}
+		return DeltaInstruction{Op: OpCopy, SrcOff: int(off), Len: int(n)}, nil
+	case deltaLiteral:
+		n, err := binary.ReadUvarint(d.r)
+		if err != nil {
+			return DeltaInstruction{}, unexpected(err)
+		}
+		if n == 0 || n > MaxLiteral {
+			return DeltaInstruction{}, errors.New("invalid literal length")
+		}
+		if cap(d.buf) < int(n) {
+			d.buf = make([]byte, n)
+		}
+		d.buf = d.buf[:n]
+		if _, err := io.ReadFull(d.r, d.buf); err != nil {
+			return DeltaInstruction{}, unexpected(err)
+		}
+		return DeltaInstruction{Op: OpLiteral, Len: int(n), Data: d.buf}, nil
+	case deltaChunk:
+		ins := DeltaInstruction{Op: OpChunk}
+		if _, err := io.ReadFull(d.r, ins.Chunk[:]); err != nil {
+			return DeltaInstruction{}, unexpected(err)
+		}
+		n, err := binary.ReadUvarint(d.r)
+		if err != nil {
+			return DeltaInstruction{}, unexpected(err)
+		}
+		if n == 0 || n > maxInt {
+			return DeltaInstruction{}, errors.New("invalid chunk length")
+		}
+		ins.Len = int(n)
+		return ins, nil
+	}
+	return DeltaInstruction{}, errors.New("unknown delta op")
+}
+
+// ReadDelta decodes a whole stream, copying literal data.
+func ReadDelta(r io.Reader) ([]DeltaInstruction, error) {
+	dr := NewDeltaReader(r)
+	var out []DeltaInstruction
+	for {
+		ins, err := dr.Next()
+		if err == io.EOF {
+			return out, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if ins.Op == OpLiteral {
+			ins.Data = append([]byte(nil), ins.Data...)
+		}
+		out = append(out, ins)
+	}
+}
+
+const maxInt = uint64(^uint(0) >> 1)
+
+func unexpected(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
@@ -0,0 +1,159 @@
+package delta
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"reflect"
+	"testing"
+)
+
+func TestSignatureRoundTrip(t *testing.T) {
+	basis := randomData(10, 100_000+333)
+	sig := ComputeFileSig(basis, 1024)
+
+	var wire bytes.Buffer
+	n, err := sig.WriteTo(&wire)
+	if err != nil {
+		t.Fatalf("encode: %v", err)
+	}
+	if want := int64(sigHeaderLen + len(sig.Blocks)*(4+sig.StrongLen)); n != want || int64(wire.Len()) != want {
+		t.Fatalf("encoded %d bytes (reported %d), want %d", wire.Len(), n, want)
+	}
+
+	var streamed bytes.Buffer
+	if err := WriteSignature(&streamed, bytes.NewReader(basis), int64(len(basis)), 1024, 0); err != nil {
+		t.Fatalf("stream encode: %v", err)
+	}
+	if !bytes.Equal(streamed.Bytes(), wire.Bytes()) {
+		t.Fatalf("streamed signature differs from in-memory one")
+	}
+
+	got, err := ReadFileSig(&wire)
+	if err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if got.BlockSize != sig.BlockSize || got.StrongLen != sig.StrongLen || len(got.Blocks) != len(sig.Blocks) {
+		t.Fatalf("decoded signature header differs")
+	}
+	for i, b := range got.Blocks {
+		want := sig.Blocks[i]
+		if b.Weak != want.Weak || b.Offset != want.Offset || b.Len != want.Len ||
+			!bytes.Equal(b.Strong[:sig.StrongLen], want.Strong[:sig.StrongLen]) {
+			t.Fatalf("block %d differs", i)
+		}
+	}
+	newData := cat(basis[:5000], []byte("inserted"), basis[5000:])
+	if !reflect.DeepEqual(ComputeDelta(got, newData), ComputeDelta(sig, newData)) {
+		t.Fatalf("decoded signature yields a different delta")
+	}
+}
+
+func TestTruncatedStrongStillMatches(t *testing.T) {
+	basis := randomData(11, 64<<10)
+	var wire bytes.Buffer
+	if err := WriteSignature(&wire, bytes.NewReader(basis), int64(len(basis)), 512, 6); err != nil {
+		t.Fatalf("encode: %v", err)
+	}
+	if wire.Len() != sigHeaderLen+128*(4+6) {
+		t.Fatalf("signature is %d bytes", wire.Len())
+	}
+	sig, err := ReadFileSig(&wire)
+	if err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	newData := cat([]byte("xyz"), basis)
+	d := ComputeDelta(sig, newData)
+	if !bytes.Equal(ApplyDelta(basis, d), newData) || literalBytes(d) != 3 {
+		t.Fatalf("delta over truncated signature: %d literal bytes", literalBytes(d))
+	}
+}
+
+func TestEmptySignature(t *testing.T) {
+	var wire bytes.Buffer
+	if err := WriteSignature(&wire, bytes.NewReader(nil), 0, 512, 16); err != nil {
+		t.Fatalf("encode: %v", err)
+	}
+	sig, err := ReadFileSig(&wire)
+	if err != nil || len(sig.Blocks) != 0 {
+		t.Fatalf("decode: %v %d", err, len(sig.Blocks))
+	}
+	d := ComputeDelta(sig, []byte("all new"))
+	if len(d) != 1 || d[0].Op != OpLiteral {
+		t.Fatalf("delta against empty basis: %+v", d)
+	}
+}
+
+func TestDeltaStreamRoundTrip(t *testing.T) {
+	big := randomData(12, 2*MaxLiteral+10)
+	in := []DeltaInstruction{
+		{Op: OpCopy, SrcOff: 0, Len: 100},
+		{Op: OpLiteral, Len: 3, Data: []byte("abc")},
+		{Op: OpCopy, SrcOff: 1 << 40, Len: 4096},
+		{Op: OpLiteral, Len: len(big), Data: big},
+	}
+	var wire bytes.Buffer
+	if err := WriteDelta(&wire, in); err != nil {
+		t.Fatalf("encode: %v", err)
+	}
+	out, err := ReadDelta(&wire)
+	if err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	// The large literal comes back split at MaxLiteral.
+	if len(out) != 6 || out[3].Len != MaxLiteral || out[5].Len != 10 {
+		t.Fatalf("decoded %d instructions", len(out))
+	}
+	var lit []byte
+	for _, ins := range out[3:] {
+		lit = append(lit, ins.Data...)
+	}
+	if !reflect.DeepEqual(out[:3], in[:3]) || !bytes.Equal(lit, big) {
+		t.Fatalf("round trip mismatch")
+	}
+}
+
+func TestDeltaWriterCoalescesCopies(t *testing.T) {
+	var wire bytes.Buffer
+	dw := NewDeltaWriter(&wire)
+	for i := 0; i < 100; i++ {
+		if err := dw.Copy(i*512, 512); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := dw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	out, err := ReadDelta(&wire)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(out) != 1 || out[0].Len != 51200 {
+		t.Fatalf("got %+v", out)
+	}
+}
+
+func TestWireRejectsMalformedInput(t *testing.T) {
+	if _, err := ReadFileSig(bytes.NewReader(make([]byte, sigHeaderLen))); err == nil {
+		t.Fatalf("expected bad magic error")
+	}
+	var wire bytes.Buffer
+	_ = WriteSignature(&wire, bytes.NewReader(make([]byte, 5000)), 5000, 1000, 8)
+	if _, err := ReadFileSig(bytes.NewReader(wire.Bytes()[:wire.Len()-1])); !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Fatalf("truncated signature: %v", err)
+	}
+	for _, bad := range [][]byte{
+		{deltaCopy, 1},                      // truncated
+		{deltaCopy, 0, 0, deltaEnd},         // zero-length copy
+		{deltaLiteral, 0x81, 0x80, 0x80, 1}, // literal over MaxLiteral
+		{9},                                 // unknown tag
+		{deltaLiteral, 2, 'a'},              // short literal body
+	} {
+		if _, err := ReadDelta(bytes.NewReader(bad)); err == nil {
+			t.Fatalf("accepted %x", bad)
+		}
+	}
+	if _, err := ReadDelta(bytes.NewReader(nil)); !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Fatalf("missing end marker: %v", err)
+	}
+}
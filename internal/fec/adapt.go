@@ -0,0 +1,374 @@
+package fec
+
+import (
+	"errors"
+	"math"
+
+	"riptide/internal/proto"
+)
+
+// Profile is the shard layout of a coding block.
+type Profile struct {
+	Data   int
+	Parity int
+}
+
+// FixedProfile converts an explicit --fec=k/n ratio, k data shards out of n
+// total, into a profile.
+func FixedProfile(k, n int) (Profile, error) {
+	if k <= 0 || n <= k || n > 256 {
+		return Profile{}, errors.New("invalid fec ratio")
+	}
+	return Profile{Data: k, Parity: n - k}, nil
+}
+
+// GilbertElliott estimates a two-state burst loss channel from an ordered
+// stream of packet outcomes. It uses the simplified Gilbert form where the
+// bad state always loses and the good state never does, so the transition
+// probabilities come straight from consecutive outcomes. Counts decay
+// exponentially so the estimate follows a changing path.
+type GilbertElliott struct {
+	decay float64
+	trans [2][2]float64
+	prev  int
+	seen  bool
+	n     uint64
+}
+
+const (
+	stateGood = 0
+	stateBad  = 1
+)
+
+func NewGilbertElliott(decay float64) *GilbertElliott {
+	if decay <= 0 || decay >= 1 {
+		decay = 0.999
+	}
+	return &GilbertElliott{decay: decay}
+}
+
+func (g *GilbertElliott) Observe(lost bool) {
+	cur := stateGood
+	if lost {
+		cur = stateBad
+	}
+	g.n++
+	if !g.seen {
+		g.seen = true
+		g.prev = cur
+		return
+	}
+	for i := range g.trans {
+		for j := range g.trans[i] {
+			g.trans[i][j] *= g.decay
+		}
+	}
+	g.trans[g.prev][cur]++
+	g.prev = cur
+}
+
+// Reset forgets the previous outcome, e.g. after a stretch of sequence
+// numbers with no feedback, so no transition is inferred across it.
+func (g *GilbertElliott) Reset() {
+	g.seen = false
+}
+
+func (g *GilbertElliott) Samples() uint64 { return g.n }
+
+// P is the probability of moving from the good to the bad state.
+func (g *GilbertElliott) P() float64 {
+	good := g.trans[stateGood][stateGood] + g.trans[stateGood][stateBad]
+	if good == 0 {
+		return 0
+	}
+	return g.trans[stateGood][stateBad] / good
+}
+
+// R is the probability of leaving the bad state.
+func (g *GilbertElliott) R() float64 {
+	bad := g.trans[stateBad][stateGood] + g.trans[stateBad][stateBad]
+	if bad == 0 {
+		return 1
+	}
+	return g.trans[stateBad][stateGood] / bad
+}
+
+// LossRate is the stationary probability of the bad state.
+func (g *GilbertElliott) LossRate() float64 {
+	p, r := g.P(), g.R()
+	if p+r == 0 {
+		return 0
+	}
+	return p / (p + r)
+}
+
+// MeanBurst is the expected number of consecutive losses.
+func (g *GilbertElliott) MeanBurst() float64 {
+	r := g.R()
+	if r == 0 {
+		return math.Inf(1)
+	}
+	return 1 / r
+}
+
+// BlockFailure is the probability that more than parity of the data+parity
+// packets in a block are lost, starting from the stationary distribution.
+func (g *GilbertElliott) BlockFailure(data, parity int) float64 {
+	return blockFailure(g.P(), g.R(), data+parity, parity)
+}
+
+func blockFailure(p, r float64, n, tolerate int) float64 {
+	if p == 0 {
+		return 0
+	}
+	if tolerate >= n {
+		return 0
+	}
+	// dist[s][l]: probability of being in state s after l losses so far;
+	// loss counts above tolerate are folded into one overflow bucket.
+	var cur, next [2][]float64
+	for s := range cur {
+		cur[s] = make([]float64, tolerate+2)
+		next[s] = make([]float64, tolerate+2)
+	}
+	pi := p / (p + r)
+	// The first packet's outcome is its initial state.
+	cur[stateGood][0] = 1 - pi
+	cur[stateBad][1] = pi
+	for i := 1; i < n; i++ {
+		for s := range next {
+			for l := range next[s] {
+				next[s][l] = 0
+			}
+		}
+		for l := 0; l <= tolerate+1; l++ {
+			g, b := cur[stateGood][l], cur[stateBad][l]
+			up := l + 1
+			if up > tolerate+1 {
+				up = tolerate + 1
+			}
+			next[stateGood][l] += g*(1-p) + b*r
+			next[stateBad][up] += g*p + b*(1-r)
+		}
+		cur, next = next, cur
+	}
+	return cur[stateGood][tolerate+1] + cur[stateBad][tolerate+1]
+}
+
+// Efficiency reports how much of the parity sent actually repaired data.
+type Efficiency struct {
+	DataSent   uint64
+	ParitySent uint64
+	Repaired   uint64
+}
+
+// Overhead is parity packets per data packet.
+func (e Efficiency) Overhead() float64 {
+	if e.DataSent == 0 {
+		return 0
+	}
+	return float64(e.ParitySent) / float64(e.DataSent)
+}
+
+// Yield is repairs achieved per parity packet sent.
+func (e Efficiency) Yield() float64 {
+	if e.ParitySent == 0 {
+		return 0
+	}
+	return float64(e.Repaired) / float64(e.ParitySent)
+}
+
+const (
+	// A block that still cannot be decoded this often falls back to ARQ.
+	targetFailure = 1e-3
+	// Outcomes may be reported out of order; ones further behind than this
+	// are skipped rather than waited for.
+	reorderWindow = 256
+	// Re-evaluate the profile after this many new outcomes.
+	decideEvery = 64
+	// A new profile must cut the failure probability or the overhead by
+	// this factor before it replaces the current one.
+	hysteresis = 0.8
+)
+
+// Controller chooses the FEC profile for each coding block from ACK/NAK
+// feedback. A packet counts as lost on the channel when it was NAKed or its
+// ACK carries proto.FlagFECRepaired. With a fixed profile the controller
+// still measures loss and efficiency but never changes the layout.
+type Controller struct {
+	fixed     bool
+	maxData   int
+	maxParity int
+	profile   Profile
+	announced bool
+
+	ge       *GilbertElliott
+	outcomes map[uint64]bool
+	cursor   uint64
+	started  bool
+	sinceDec int
+
+	eff Efficiency
+}
+
+// NewAdaptiveController picks up to maxData data and maxParity parity
+// shards per block.
+func NewAdaptiveController(maxData, maxParity int) (*Controller, error) {
+	if maxData <= 0 || maxParity <= 0 || maxData+maxParity > 256 {
+		return nil, errors.New("invalid shard limits")
+	}
+	c := newController(Profile{Data: maxData, Parity: SelectParity(0, maxParity)})
+	c.maxData, c.maxParity = maxData, maxParity
+	return c, nil
+}
+
+func NewFixedController(p Profile) (*Controller, error) {
+	if p.Data <= 0 || p.Parity <= 0 || p.Data+p.Parity > 256 {
+		return nil, errors.New("invalid fec profile")
+	}
+	c := newController(p)
+	c.fixed = true
+	return c, nil
+}
+
+func newController(p Profile) *Controller {
+	return &Controller{
+		profile:  p,
+		ge:       NewGilbertElliott(0),
+		outcomes: make(map[uint64]bool),
+	}
+}
+
+func (c *Controller) OnAck(seq uint64, repaired bool) {
+	if repaired {
+		c.eff.Repaired++
+	}
+	c.record(seq, repaired)
+}
+
+func (c *Controller) OnNak(seq uint64) {
+	c.record(seq, true)
+}
+
+func (c *Controller) OnSent(data, parity int) {
+	c.eff.DataSent += uint64(data)
+	c.eff.ParitySent += uint64(parity)
+}
+
+func (c *Controller) record(seq uint64, lost bool) {
+	if !c.started {
+		c.started = true
+		c.cursor = seq
+	}
+	if seq < c.cursor {
+		return
+	}
+	if _, ok := c.outcomes[seq]; ok {
+		return
+	}
+	c.outcomes[seq] = lost
+	for {
+		l, ok := c.outcomes[c.cursor]
+		if !ok {
+			if uint64(len(c.outcomes)) < reorderWindow {
+				break
+			}
+			c.ge.Reset()
+			c.cursor++
+			continue
+		}
+		delete(c.outcomes, c.cursor)
+		c.ge.Observe(l)
+		c.sinceDec++
+		c.cursor++
+	}
+}
+
+// NextBlock returns the profile for the next coding block and whether it
+// differs from the one last returned, in which case the sender should
+// announce it in a CONTROL frame.
+func (c *Controller) NextBlock() (Profile, bool) {
+	if !c.fixed && c.sinceDec >= decideEvery {
+		c.sinceDec = 0
+		c.decide()
+	}
+	changed := !c.announced
+	c.announced = true
+	return c.profile, changed
+}
+
+func (c *Controller) decide() {
+	p, r := c.ge.P(), c.ge.R()
+	curFail := blockFailure(p, r, c.profile.Data+c.profile.Parity, c.profile.Parity)
+	curOver := float64(c.profile.Parity) / float64(c.profile.Data)
+
+	best := c.profile
+	bestFail, bestOver := math.Inf(1), math.Inf(1)
+	met := false
+	for _, k := range dataCandidates(c.maxData) {
+		for m := 1; m <= c.maxParity; m++ {
+			f := blockFailure(p, r, k+m, m)
+			o := float64(m) / float64(k)
+			switch {
+			case f <= targetFailure:
+				if !met || o < bestOver {
+					best, bestFail, bestOver, met = Profile{k, m}, f, o, true
+				}
+			case !met && (f < bestFail || f == bestFail && o < bestOver):
+				best, bestFail, bestOver = Profile{k, m}, f, o
+			}
+		}
+	}
+	if best == c.profile {
+		return
+	}
+	// Only move if the candidate is clearly better, so noisy estimates do
+	// not make the profile flap between neighbours.
+	switch {
+	case curFail > targetFailure && bestFail < curFail*hysteresis:
+	case curFail <= targetFailure && met && bestOver < curOver*hysteresis:
+	default:
+		return
+	}
+	c.profile = best
+	c.announced = false
+}
+
+func dataCandidates(max int) []int {
+	out := []int{max}
+	for k := max * 3 / 4; k >= 1 && k >= max/4; k = k * 3 / 4 {
+		if k != out[len(out)-1] {
+			out = append(out, k)
+		}
+		if k == 1 {
+			break
+		}
+	}
+	return out
+}
+
+func (c *Controller) Profile() Profile { return c.profile }
+
+func (c *Controller) Fixed() bool { return c.fixed }
+
+// Loss returns the estimated channel loss rate and mean burst length.
+func (c *Controller) Loss() (rate, burst float64) {
+	return c.ge.LossRate(), c.ge.MeanBurst()
+}
+
+func (c *Controller) Efficiency() Efficiency { return c.eff }
+
+// Announce writes the profile into a CONTROL payload.
+func (p Profile) Announce(cp *proto.ControlPayload) {
+	cp.FECData = uint16(p.Data)
+	cp.FECParity = uint16(p.Parity)
+}
+
+// AnnouncedProfile reads a profile announced by the peer, if any. A layout
+// no codec can build (no parity, or more than 256 shards) is ignored.
+func AnnouncedProfile(cp proto.ControlPayload) (Profile, bool) {
+	if cp.FECData == 0 || cp.FECParity == 0 || int(cp.FECData)+int(cp.FECParity) > 256 {
+		return Profile{}, false
+	}
+	return Profile{Data: int(cp.FECData), Parity: int(cp.FECParity)}, true
+}
@@ -0,0 +1,167 @@
+package fec
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+
+	"riptide/internal/proto"
+)
+
+// geChannel draws losses from a simplified Gilbert channel.
+type geChannel struct {
+	p, r float64
+	bad  bool
+	rng  *rand.Rand
+}
+
+func (c *geChannel) lost() bool {
+	if c.bad {
+		c.bad = c.rng.Float64() >= c.r
+	} else {
+		c.bad = c.rng.Float64() < c.p
+	}
+	return c.bad
+}
+
+func TestGilbertElliottEstimatesBurstChannel(t *testing.T) {
+	ch := &geChannel{p: 0.02, r: 0.4, rng: rand.New(rand.NewSource(1))}
+	g := NewGilbertElliott(0.9999)
+	for i := 0; i < 200000; i++ {
+		g.Observe(ch.lost())
+	}
+	want := 0.02 / 0.42
+	if rate := g.LossRate(); math.Abs(rate-want) > 0.01 {
+		t.Fatalf("loss rate %.4f want ~%.4f", rate, want)
+	}
+	if b := g.MeanBurst(); math.Abs(b-2.5) > 0.3 {
+		t.Fatalf("mean burst %.2f want ~2.5", b)
+	}
+}
+
+func TestBlockFailureMatchesBinomialForIndependentLoss(t *testing.T) {
+	// With r = 1-q the chain has no memory and losses are Bernoulli(q).
+	q, n, m := 0.1, 12, 2
+	var want float64
+	for l := m + 1; l <= n; l++ {
+		want += binom(n, l) * math.Pow(q, float64(l)) * math.Pow(1-q, float64(n-l))
+	}
+	if got := blockFailure(q, 1-q, n, m); math.Abs(got-want) > 1e-9 {
+		t.Fatalf("failure %.6f want %.6f", got, want)
+	}
+	if blockFailure(0, 1, n, m) != 0 {
+		t.Fatalf("lossless channel cannot fail")
+	}
+}
+
+func binom(n, k int) float64 {
+	r := 1.0
+	for i := 1; i <= k; i++ {
+		r = r * float64(n-k+i) / float64(i)
+	}
+	return r
+}
+
+func runController(c *Controller, ch *geChannel, packets int) {
+	for seq := uint64(0); seq < uint64(packets); seq++ {
+		if ch.lost() {
+			c.OnNak(seq)
+		} else {
+			c.OnAck(seq, false)
+		}
+		c.NextBlock()
+	}
+}
+
+func TestControllerAddsParityForBurstyLoss(t *testing.T) {
+	iid, _ := NewAdaptiveController(16, 8)
+	runController(iid, &geChannel{p: 0.02, r: 0.98, rng: rand.New(rand.NewSource(2))}, 20000)
+	bursty, _ := NewAdaptiveController(16, 8)
+	runController(bursty, &geChannel{p: 0.005, r: 0.25, rng: rand.New(rand.NewSource(3))}, 20000)
+
+	pi, pb := iid.Profile(), bursty.Profile()
+	ri, _ := iid.Loss()
+	rb, bb := bursty.Loss()
+	if math.Abs(ri-rb) > 0.01 {
+		t.Fatalf("channels should have similar loss: %.3f vs %.3f", ri, rb)
+	}
+	if bb < 2 {
+		t.Fatalf("burst length not detected: %.2f", bb)
+	}
+	oi := float64(pi.Parity) / float64(pi.Data)
+	ob := float64(pb.Parity) / float64(pb.Data)
+	if ob <= oi {
+		t.Fatalf("bursty loss should get more redundancy: iid %+v bursty %+v", pi, pb)
+	}
+	if f := bursty.ge.BlockFailure(pb.Data, pb.Parity); f > targetFailure*2 {
+		t.Fatalf("bursty profile %+v leaves block failure %.4f", pb, f)
+	}
+}
+
+func TestControllerBacksOffOnCleanPath(t *testing.T) {
+	c, _ := NewAdaptiveController(16, 8)
+	runController(c, &geChannel{p: 0.1, r: 0.5, rng: rand.New(rand.NewSource(4))}, 5000)
+	lossy := c.Profile()
+	if _, changed := c.NextBlock(); changed {
+		t.Fatalf("profile announced twice")
+	}
+	// A fresh estimator sees only the clean stretch.
+	c.ge = NewGilbertElliott(0.99)
+	for seq := uint64(5000); seq < 10000; seq++ {
+		c.OnAck(seq, false)
+		c.NextBlock()
+	}
+	clean := c.Profile()
+	if clean.Parity*lossy.Data >= lossy.Parity*clean.Data {
+		t.Fatalf("overhead should drop on a clean path: %+v -> %+v", lossy, clean)
+	}
+}
+
+func TestFixedControllerKeepsProfile(t *testing.T) {
+	p, err := FixedProfile(4, 6)
+	if err != nil || p != (Profile{Data: 4, Parity: 2}) {
+		t.Fatalf("fixed profile: %+v %v", p, err)
+	}
+	if _, err := FixedProfile(6, 6); err == nil {
+		t.Fatalf("expected ratio error")
+	}
+	c, _ := NewFixedController(p)
+	if got, changed := c.NextBlock(); got != p || !changed {
+		t.Fatalf("first block must announce the profile")
+	}
+	runController(c, &geChannel{p: 0.2, r: 0.2, rng: rand.New(rand.NewSource(5))}, 5000)
+	if c.Profile() != p {
+		t.Fatalf("fixed profile changed to %+v", c.Profile())
+	}
+	if rate, _ := c.Loss(); rate < 0.3 {
+		t.Fatalf("fixed controller should still measure loss, got %.3f", rate)
+	}
+}
+
+func TestControllerEfficiencyAndAnnounce(t *testing.T) {
+	c, _ := NewAdaptiveController(8, 4)
+	c.OnSent(8, 2)
+	c.OnAck(0, true)
+	c.OnAck(1, false)
+	e := c.Efficiency()
+	if e.Overhead() != 0.25 || e.Yield() != 0.5 {
+		t.Fatalf("efficiency %+v overhead %.2f yield %.2f", e, e.Overhead(), e.Yield())
+	}
+	var cp proto.ControlPayload
+	c.Profile().Announce(&cp)
+	got, ok := AnnouncedProfile(cp)
+	if !ok || got != c.Profile() {
+		t.Fatalf("announce round trip: %+v", got)
+	}
+}
+
+func TestAnnouncedProfileRejectsInvalidLayouts(t *testing.T) {
+	for _, cp := range []proto.ControlPayload{
+		{FECData: 8, FECParity: 0},
+		{FECData: 250, FECParity: 7},
+	} {
+		if p, ok := AnnouncedProfile(cp); ok {
+			t.Fatalf("data %d parity %d accepted as %+v", cp.FECData, cp.FECParity, p)
+		}
+	}
+}
@@ -0,0 +1,103 @@
+package fec
+
+import (
+	"errors"
+	"sync"
+)
+
+type codecKey struct {
+	data   int
+	parity int
+}
+
+var codecs sync.Map // codecKey -> *Codec
+
+// CachedCodec returns a shared codec for the shard counts, building the
+// encoding matrix only the first time a layout is seen. Codecs are safe for
+// concurrent use.
+func CachedCodec(dataShards, parityShards int) (*Codec, error) {
+	key := codecKey{dataShards, parityShards}
+	if c, ok := codecs.Load(key); ok {
+		return c.(*Codec), nil
+	}
+	c, err := NewCodec(dataShards, parityShards)
+	if err != nil {
+		return nil, err
+	}
+	actual, _ := codecs.LoadOrStore(key, c)
+	return actual.(*Codec), nil
+}
+
+// EncodeInPlace computes parity into shards[DataShards:] without copying.
+// Every shard must already be allocated with the same length; data shards
+// are read, parity shards overwritten.
+func (c *Codec) EncodeInPlace(shards [][]byte) error {
+	if len(shards) != c.dataShards+c.parityShards {
+		return errors.New("wrong total shard count")
+	}
+	size := len(shards[0])
+	for _, s := range shards[1:] {
+		if len(s) != size {
+			return errors.New("unequal shard sizes")
+		}
+	}
+	return c.enc.Encode(shards)
+}
+
+// ReconstructData rebuilds missing data shards only, skipping parity
+// regeneration and verification. Nil entries with spare capacity in the
+// slice are reused.
+func (c *Codec) ReconstructData(shards [][]byte) error {
+	if len(shards) != c.dataShards+c.parityShards {
+		return errors.New("wrong total shard count")
+	}
+	return c.enc.ReconstructData(shards)
+}
+
+// Shards is a pooled set of equally sized shard buffers backed by a single
+// allocation. Release returns it to the pool; the buffers must not be used
+// afterwards.
+type Shards struct {
+	S     [][]byte
+	buf   []byte
+	block [][]byte
+}
+
+var shardPool = sync.Pool{New: func() any { return new(Shards) }}
+
+// GetShards returns n zeroed shards of size bytes each.
+func GetShards(n, size int) *Shards {
+	s := shardPool.Get().(*Shards)
+	total := n * size
+	if cap(s.buf) < total {
+		s.buf = make([]byte, total)
+	} else {
+		s.buf = s.buf[:total]
+		clear(s.buf)
+	}
+	if cap(s.S) < n {
+		s.S = make([][]byte, n)
+	}
+	s.S = s.S[:n]
+	for i := range s.S {
+		s.S[i] = s.buf[i*size : (i+1)*size : (i+1)*size]
+	}
+	return s
+}
+
+// Block returns S followed by parity nil entries for the caller to fill
+// with its own parity buffers, ready for EncodeInPlace. The slice is reused
+// across Gets and is valid until Release.
+func (s *Shards) Block(parity int) [][]byte {
+	s.block = append(s.block[:0], s.S...)
+	for i := 0; i < parity; i++ {
+		s.block = append(s.block, nil)
+	}
+	return s.block
+}
+
+func (s *Shards) Release() {
+	clear(s.S)
+	clear(s.block)
+	shardPool.Put(s)
+}
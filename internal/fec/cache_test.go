@@ -0,0 +1,177 @@
+package fec
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"testing"
+)
+
+func TestCachedCodecIsShared(t *testing.T) {
+	a, err := CachedCodec(10, 3)
+	if err != nil {
+		t.Fatalf("codec: %v", err)
+	}
+	b, _ := CachedCodec(10, 3)
+	if a != b {
+		t.Fatalf("same layout should reuse the codec")
+	}
+	if c, _ := CachedCodec(10, 4); c == a {
+		t.Fatalf("different layouts must not share a codec")
+	}
+	if _, err := CachedCodec(0, 1); err == nil {
+		t.Fatalf("expected invalid shard counts")
+	}
+}
+
+func TestEncodeInPlaceMatchesBuildShards(t *testing.T) {
+	c, _ := CachedCodec(6, 2)
+	data := randomShards(rand.New(rand.NewSource(1)), 6, 100)
+	want, err := c.BuildShards(data)
+	if err != nil {
+		t.Fatalf("build: %v", err)
+	}
+	s := GetShards(8, 100)
+	defer s.Release()
+	for i := range data {
+		copy(s.S[i], data[i])
+	}
+	if err := c.EncodeInPlace(s.S); err != nil {
+		t.Fatalf("encode: %v", err)
+	}
+	for i := range want {
+		if !bytes.Equal(s.S[i], want[i]) {
+			t.Fatalf("shard %d differs", i)
+		}
+	}
+	s.S[1], s.S[4] = s.S[1][:0], nil
+	if err := c.ReconstructData(s.S); err != nil {
+		t.Fatalf("reconstruct: %v", err)
+	}
+	if !bytes.Equal(s.S[1], data[1]) || !bytes.Equal(s.S[4], data[4]) {
+		t.Fatalf("data not rebuilt")
+	}
+}
+
+func TestGetShardsReusesZeroedBuffers(t *testing.T) {
+	s := GetShards(4, 64)
+	for _, sh := range s.S {
+		for i := range sh {
+			sh[i] = 0xff
+		}
+	}
+	s.Release()
+	s = GetShards(3, 64)
+	defer s.Release()
+	if len(s.S) != 3 {
+		t.Fatalf("len %d", len(s.S))
+	}
+	for _, sh := range s.S {
+		if len(sh) != 64 || cap(sh) != 64 || bytes.IndexByte(sh, 0xff) >= 0 {
+			t.Fatalf("shard not zeroed or wrongly sized")
+		}
+	}
+}
+
+var benchLayouts = []struct{ k, m, size int }{
+	{10, 3, 1200},
+	{32, 8, 1200},
+	{10, 3, 8192},
+}
+
+func reportGBps(b *testing.B, bytesPerOp int) {
+	b.SetBytes(int64(bytesPerOp))
+	b.ReportMetric(float64(b.N)*float64(bytesPerOp)/b.Elapsed().Seconds()/1e9, "GB/s")
+}
+
+// BenchmarkEncode compares building a codec per block, as the pipeline used
+// to, with the cached codec encoding into pooled buffers.
+func BenchmarkEncode(b *testing.B) {
+	for _, l := range benchLayouts {
+		data := randomShards(rand.New(rand.NewSource(1)), l.k, l.size)
+		name := fmt.Sprintf("%d+%d/%dB", l.k, l.m, l.size)
+		b.Run("fresh/"+name, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				c, err := NewCodec(l.k, l.m)
+				if err != nil {
+					b.Fatal(err)
+				}
+				if _, err := c.BuildShards(data); err != nil {
+					b.Fatal(err)
+				}
+			}
+			reportGBps(b, l.k*l.size)
+		})
+		b.Run("cached/"+name, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				c, err := CachedCodec(l.k, l.m)
+				if err != nil {
+					b.Fatal(err)
+				}
+				s := GetShards(l.k+l.m, l.size)
+				for j := range data {
+					copy(s.S[j], data[j])
+				}
+				if err := c.EncodeInPlace(s.S); err != nil {
+					b.Fatal(err)
+				}
+				s.Release()
+			}
+			reportGBps(b, l.k*l.size)
+		})
+	}
+}
+
+// BenchmarkStreamEncoder runs whole blocks through the streaming encoder,
+// handing parity back through Recycle as a sender would once it is sent.
+func BenchmarkStreamEncoder(b *testing.B) {
+	for _, l := range benchLayouts {
+		data := randomShards(rand.New(rand.NewSource(1)), l.k, l.size)
+		b.Run(fmt.Sprintf("%d+%d/%dB", l.k, l.m, l.size), func(b *testing.B) {
+			e, err := NewStreamEncoder(l.k, l.m)
+			if err != nil {
+				b.Fatal(err)
+			}
+			b.ReportAllocs()
+			var seq uint64
+			for i := 0; i < b.N; i++ {
+				for _, d := range data {
+					par, err := e.Add(seq, d)
+					if err != nil {
+						b.Fatal(err)
+					}
+					e.Recycle(par)
+					seq++
+				}
+			}
+			reportGBps(b, l.k*l.size)
+		})
+	}
+}
+
+func BenchmarkReconstructData(b *testing.B) {
+	for _, l := range benchLayouts {
+		c, _ := CachedCodec(l.k, l.m)
+		full, _ := c.BuildShards(randomShards(rand.New(rand.NewSource(1)), l.k, l.size))
+		b.Run(fmt.Sprintf("%d+%d/%dB", l.k, l.m, l.size), func(b *testing.B) {
+			b.ReportAllocs()
+			shards := make([][]byte, len(full))
+			spare := make([][]byte, l.m)
+			for j := range spare {
+				spare[j] = make([]byte, l.size)
+			}
+			for i := 0; i < b.N; i++ {
+				copy(shards, full)
+				for j := 0; j < l.m; j++ {
+					shards[j] = spare[j][:0]
+				}
+				if err := c.ReconstructData(shards); err != nil {
+					b.Fatal(err)
+				}
+			}
+			reportGBps(b, l.k*l.size)
+		})
+	}
+}
@@ -0,0 +1,204 @@
+package fec
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+
+	"riptide/internal/proto"
+)
+
+// Fountain blocks travel as FEC_PARITY packets with Total set to 0, which
+// marks the block as rateless: Index is the symbol ID (data symbols first,
+// then repair) and DataShards the number of source payloads. The receiver
+// acknowledges a decoded block with an ACK for the BlockID carrying
+// proto.FlagBlockDecoded.
+const maxFountainSymbols = 1 << 16
+
+// FountainSender emits the symbols of one block. In acknowledged mode it
+// keeps producing fresh repair symbols until Done is called; in broadcast
+// mode it stops after a fixed number of symbols.
+type FountainSender struct {
+	blockID uint64
+	codec   *LTCodec
+	data    [][]byte
+	next    uint32
+	limit   uint32
+	done    bool
+}
+
+// NewFountainSender prepares payloads as one block. They are length
+// prefixed and padded to a common size so the receiver can strip padding.
+func NewFountainSender(blockID uint64, payloads [][]byte) (*FountainSender, error) {
+	if len(payloads) == 0 || len(payloads) >= maxFountainSymbols/2 {
+		return nil, errors.New("invalid fountain block size")
+	}
+	size := 0
+	for _, p := range payloads {
+		if len(p) > maxShardPayload {
+			return nil, errors.New("payload too large for fec shard")
+		}
+		if len(p) > size {
+			size = len(p)
+		}
+	}
+	data := make([][]byte, len(payloads))
+	for i, p := range payloads {
+		data[i] = packShard(p, lenPrefix+size)
+	}
+	codec, err := NewLTCodec(len(payloads), 0)
+	if err != nil {
+		return nil, err
+	}
+	return &FountainSender{blockID: blockID, codec: codec, data: data, limit: maxFountainSymbols}, nil
+}
+
+// NewBroadcastSender is for links with no return path: it sends the data
+// symbols plus overhead (e.g. 0.3 for 30%) worth of repair symbols and then
+// stops without waiting for feedback.
+func NewBroadcastSender(blockID uint64, payloads [][]byte, overhead float64) (*FountainSender, error) {
+	if overhead < 0 {
+		return nil, errors.New("negative broadcast overhead")
+	}
+	s, err := NewFountainSender(blockID, payloads)
+	if err != nil {
+		return nil, err
+	}
+	n := len(payloads) + int(math.Ceil(float64(len(payloads))*overhead))
+	if n < maxFountainSymbols {
+		s.limit = uint32(n)
+	}
+	return s, nil
+}
+
+// Next returns the next symbol to send, or false once the receiver has
+// signalled completion or the broadcast budget is spent.
+func (s *FountainSender) Next() (proto.FECParityPayload, bool) {
+	if s.done || s.next >= s.limit {
+		return proto.FECParityPayload{}, false
+	}
+	id := s.next
+	s.next++
+	return proto.FECParityPayload{
+		BlockID:    s.blockID,
+		Index:      uint16(id),
+		DataShards: uint16(s.codec.k),
+		Parity:     s.codec.symbol(s.data, id, len(s.data[0])),
+	}, true
+}
+
+// Done stops the block; call it when the receiver's decoded ACK arrives.
+func (s *FountainSender) Done() { s.done = true }
+
+// Sent is the number of symbols handed out so far.
+func (s *FountainSender) Sent() int { return int(s.next) }
+
+// FountainReceiver collects symbols for rateless blocks.
+type FountainReceiver struct {
+	window  uint64
+	blocks  map[uint64]*LTDecoder
+	done    map[uint64]bool
+	highest uint64
+	started bool
+	pruned  uint64
+}
+
+// NewFountainReceiver keeps at most window BlockIDs of history: blocks
+// further behind the highest BlockID seen are forgotten, decoded or not,
+// and their symbols ignored.
+func NewFountainReceiver(window int) *FountainReceiver {
+	if window <= 0 {
+		window = 4096
+	}
+	return &FountainReceiver{
+		window: uint64(window),
+		blocks: make(map[uint64]*LTDecoder),
+		done:   make(map[uint64]bool),
+	}
+}
+
+// OnSymbol feeds one symbol. When it completes the block the original
+// payloads are returned and the caller should ACK the BlockID with
+// proto.FlagBlockDecoded (unless broadcasting). Symbols for a block that
+// is already decoded are ignored.
+func (r *FountainReceiver) OnSymbol(p proto.FECParityPayload) ([][]byte, bool, error) {
+	if p.Total != 0 || p.DataShards == 0 {
+		return nil, false, errors.New("not a fountain symbol")
+	}
+	if r.started && p.BlockID+r.window <= r.highest || r.done[p.BlockID] {
+		return nil, false, nil
+	}
+	r.advance(p.BlockID)
+	d, ok := r.blocks[p.BlockID]
+	if !ok {
+		c, err := NewLTCodec(int(p.DataShards), 0)
+		if err != nil {
+			return nil, false, err
+		}
+		d = c.NewDecoder()
+		r.blocks[p.BlockID] = d
+	}
+	if d.c.k != int(p.DataShards) {
+		return nil, false, errors.New("inconsistent fountain block")
+	}
+	solved, err := d.Add(uint32(p.Index), p.Parity)
+	if err != nil || !solved {
+		return nil, false, err
+	}
+	data, err := d.Data()
+	if err != nil {
+		return nil, false, err
+	}
+	delete(r.blocks, p.BlockID)
+	r.done[p.BlockID] = true
+	out := make([][]byte, len(data))
+	for i, s := range data {
+		if len(s) < lenPrefix {
+			return nil, false, errors.New("bad fountain symbol size")
+		}
+		l := int(binary.BigEndian.Uint16(s[:lenPrefix]))
+		if lenPrefix+l > len(s) {
+			return nil, false, errors.New("bad recovered shard length")
+		}
+		out[i] = s[lenPrefix : lenPrefix+l]
+	}
+	return out, true, nil
+}
+
+func (r *FountainReceiver) advance(id uint64) {
+	if !r.started {
+		r.started = true
+		r.highest = id
+		return
+	}
+	if id <= r.highest {
+		return
+	}
+	r.highest = id
+	if r.highest < r.window {
+		return
+	}
+	floor := r.highest - r.window + 1
+	if floor < r.pruned+r.window/4 {
+		return
+	}
+	r.pruned = floor
+	for id := range r.done {
+		if id < floor {
+			delete(r.done, id)
+		}
+	}
+	for id := range r.blocks {
+		if id < floor {
+			delete(r.blocks, id)
+		}
+	}
+}
+
+// Pending reports how many symbols a block has received without decoding.
+func (r *FountainReceiver) Pending(blockID uint64) int {
+	if d, ok := r.blocks[blockID]; ok {
+		return d.Received()
+	}
+	return 0
+}
@@ -0,0 +1,335 @@
+package fec
+
+import (
+	"errors"
+	"math"
+	"math/bits"
+)
+
+// Scheme is an erasure code over equal-sized shards: BuildShards returns
+// the data shards followed by ParityShards repair shards, and Reconstruct
+// fills in nil entries of such a slice.
+type Scheme interface {
+	DataShards() int
+	ParityShards() int
+	BuildShards(data [][]byte) ([][]byte, error)
+	Reconstruct(shards [][]byte) error
+}
+
+var (
+	_ Scheme = (*Codec)(nil)
+	_ Scheme = (*LTCodec)(nil)
+)
+
+// ErrNotDecodable is returned while a fountain decoder still lacks enough
+// independent symbols.
+var ErrNotDecodable = errors.New("not enough symbols to decode")
+
+// LTCodec is a systematic LT (Luby transform) fountain code. Symbol IDs
+// below DataShards are the data itself; every higher ID is a repair symbol
+// that XORs a pseudo-random set of data symbols, with the set size drawn
+// from a robust soliton distribution. Any ID can be generated, so a sender
+// can keep producing fresh repair symbols until the receiver is done.
+type LTCodec struct {
+	k      int
+	repair int
+	cdf    []float64
+	minDeg int
+}
+
+// NewLTCodec builds a codec for dataShards source symbols. repairShards is
+// only the count BuildShards produces; Symbol works for any ID.
+func NewLTCodec(dataShards, repairShards int) (*LTCodec, error) {
+	if dataShards <= 0 || repairShards < 0 {
+		return nil, errors.New("invalid shard counts")
+	}
+	// Low-degree repair symbols mostly duplicate data symbols the receiver
+	// already has. A floor of about 2 ln k keeps the rows dense enough that
+	// elimination reaches full rank a few symbols past k.
+	minDeg := int(math.Ceil(2 * math.Log(float64(dataShards))))
+	if minDeg < 1 {
+		minDeg = 1
+	}
+	if minDeg > dataShards {
+		minDeg = dataShards
+	}
+	return &LTCodec{k: dataShards, repair: repairShards, cdf: robustSoliton(dataShards), minDeg: minDeg}, nil
+}
+
+func (c *LTCodec) DataShards() int   { return c.k }
+func (c *LTCodec) ParityShards() int { return c.repair }
+
+func (c *LTCodec) BuildShards(data [][]byte) ([][]byte, error) {
+	size, err := c.checkData(data)
+	if err != nil {
+		return nil, err
+	}
+	shards := make([][]byte, c.k+c.repair)
+	for i := range data {
+		cp := make([]byte, size)
+		copy(cp, data[i])
+		shards[i] = cp
+	}
+	for i := c.k; i < len(shards); i++ {
+		shards[i] = c.symbol(data, uint32(i), size)
+	}
+	return shards, nil
+}
+
+// Symbol returns the encoding symbol with the given ID.
+func (c *LTCodec) Symbol(data [][]byte, id uint32) ([]byte, error) {
+	size, err := c.checkData(data)
+	if err != nil {
+		return nil, err
+	}
+	return c.symbol(data, id, size), nil
+}
+
+func (c *LTCodec) symbol(data [][]byte, id uint32, size int) []byte {
+	out := make([]byte, size)
+	for _, n := range c.neighbors(id) {
+		xorInto(out, data[n])
+	}
+	return out
+}
+
+func (c *LTCodec) checkData(data [][]byte) (int, error) {
+	if len(data) != c.k {
+		return 0, errors.New("wrong number of data shards")
+	}
+	size := len(data[0])
+	for i := 1; i < len(data); i++ {
+		if len(data[i]) != size {
+			return 0, errors.New("unequal shard sizes")
+		}
+	}
+	return size, nil
+}
+
+func (c *LTCodec) Reconstruct(shards [][]byte) error {
+	if len(shards) != c.k+c.repair {
+		return errors.New("wrong total shard count")
+	}
+	d := c.NewDecoder()
+	for i, s := range shards {
+		if s == nil {
+			continue
+		}
+		if _, err := d.Add(uint32(i), s); err != nil {
+			return err
+		}
+	}
+	data, err := d.Data()
+	if err != nil {
+		return err
+	}
+	size := len(data[0])
+	for i := range shards {
+		if shards[i] != nil {
+			continue
+		}
+		if i < c.k {
+			shards[i] = data[i]
+		} else {
+			shards[i] = c.symbol(data, uint32(i), size)
+		}
+	}
+	return nil
+}
+
+// neighbors returns the data symbols XORed into symbol id. The sequence is
+// a pure function of (k, id) so both ends derive the same sets.
+func (c *LTCodec) neighbors(id uint32) []int {
+	if int(id) < c.k {
+		return []int{int(id)}
+	}
+	rng := splitmix64(uint64(id)<<32 | uint64(c.k))
+	u := float64(rng.next()>>11) / (1 << 53)
+	deg := 1
+	for deg < len(c.cdf) && u > c.cdf[deg-1] {
+		deg++
+	}
+	if deg < c.minDeg {
+		deg = c.minDeg
+	}
+	out := make([]int, 0, deg)
+	seen := make(map[int]bool, deg)
+	for len(out) < deg {
+		n := int(rng.next() % uint64(c.k))
+		if seen[n] {
+			continue
+		}
+		seen[n] = true
+		out = append(out, n)
+	}
+	return out
+}
+
+// robustSoliton returns the cumulative degree distribution for k symbols
+// (index d-1 holds P(degree <= d)), using c = 0.1 and delta = 0.5.
+func robustSoliton(k int) []float64 {
+	const c, delta = 0.1, 0.5
+	r := c * math.Log(float64(k)/delta) * math.Sqrt(float64(k))
+	spike := k
+	if r > 0 {
+		spike = int(float64(k) / r)
+	}
+	if spike < 1 {
+		spike = 1
+	}
+	if spike > k {
+		spike = k
+	}
+	p := make([]float64, k)
+	sum := 0.0
+	for d := 1; d <= k; d++ {
+		v := 1 / float64(k)
+		if d > 1 {
+			v = 1 / float64(d*(d-1))
+		}
+		switch {
+		case d < spike:
+			v += r / float64(d*k)
+		case d == spike && r > 0:
+			v += r * math.Log(r/delta) / float64(k)
+		}
+		if v < 0 {
+			v = 0
+		}
+		p[d-1] = v
+		sum += v
+	}
+	acc := 0.0
+	for i := range p {
+		acc += p[i] / sum
+		p[i] = acc
+	}
+	p[k-1] = 1
+	return p
+}
+
+type splitmix64 uint64
+
+func (s *splitmix64) next() uint64 {
+	*s += 0x9e3779b97f4a7c15
+	z := uint64(*s)
+	z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+	z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+	return z ^ (z >> 31)
+}
+
+func xorInto(dst, src []byte) {
+	for i := range dst {
+		dst[i] ^= src[i]
+	}
+}
+
+// LTDecoder accepts encoding symbols in any order and solves for the data
+// once it holds k linearly independent ones. Each symbol is reduced against
+// the rows already held as it arrives (Gaussian elimination over GF(2)), so
+// decoding needs only a few symbols beyond k rather than the large overhead
+// of a pure peeling decoder.
+type LTDecoder struct {
+	c      *LTCodec
+	pivots []*ltRow
+	rank   int
+	size   int
+	solved bool
+	seen   map[uint32]bool
+}
+
+type ltRow struct {
+	mask []uint64
+	data []byte
+}
+
+func (c *LTCodec) NewDecoder() *LTDecoder {
+	return &LTDecoder{c: c, pivots: make([]*ltRow, c.k), size: -1, seen: make(map[uint32]bool)}
+}
+
+// Add feeds one symbol and reports whether the data can now be read.
+func (d *LTDecoder) Add(id uint32, sym []byte) (bool, error) {
+	if d.solved || d.seen[id] {
+		return d.solved, nil
+	}
+	if d.size < 0 {
+		d.size = len(sym)
+	} else if len(sym) != d.size {
+		return false, errors.New("unequal symbol sizes")
+	}
+	d.seen[id] = true
+	row := &ltRow{mask: make([]uint64, (d.c.k+63)/64), data: make([]byte, len(sym))}
+	copy(row.data, sym)
+	for _, n := range d.c.neighbors(id) {
+		row.mask[n/64] ^= 1 << (n % 64)
+	}
+	for {
+		col := row.lowest()
+		if col < 0 {
+			// Linearly dependent on what we already have.
+			return false, nil
+		}
+		p := d.pivots[col]
+		if p == nil {
+			d.pivots[col] = row
+			d.rank++
+			break
+		}
+		row.xor(p)
+	}
+	if d.rank == d.c.k {
+		d.backSubstitute()
+		d.solved = true
+	}
+	return d.solved, nil
+}
+
+// Received is the number of distinct symbols fed so far.
+func (d *LTDecoder) Received() int { return len(d.seen) }
+
+func (d *LTDecoder) Done() bool { return d.solved }
+
+// Data returns the decoded data symbols, or ErrNotDecodable.
+func (d *LTDecoder) Data() ([][]byte, error) {
+	if !d.solved {
+		return nil, ErrNotDecodable
+	}
+	out := make([][]byte, d.c.k)
+	for i, p := range d.pivots {
+		out[i] = p.data
+	}
+	return out, nil
+}
+
+func (d *LTDecoder) backSubstitute() {
+	for col := d.c.k - 1; col >= 0; col-- {
+		row := d.pivots[col]
+		for w := range row.mask {
+			m := row.mask[w]
+			for m != 0 {
+				b := bits.TrailingZeros64(m)
+				m &= m - 1
+				j := w*64 + b
+				if j > col {
+					row.xor(d.pivots[j])
+				}
+			}
+		}
+	}
+}
+
+func (r *ltRow) lowest() int {
+	for w, m := range r.mask {
+		if m != 0 {
+			return w*64 + bits.TrailingZeros64(m)
+		}
+	}
+	return -1
+}
+
+func (r *ltRow) xor(o *ltRow) {
+	for i := range r.mask {
+		r.mask[i] ^= o.mask[i]
+	}
+	xorInto(r.data, o.data)
+}
@@ -0,0 +1,202 @@
+package fec
+
+import (
+	"bytes"
+	"math/rand"
+	"testing"
+)
+
+func randomShards(rng *rand.Rand, k, size int) [][]byte {
+	data := make([][]byte, k)
+	for i := range data {
+		data[i] = make([]byte, size)
+		rng.Read(data[i])
+	}
+	return data
+}
+
+func TestLTDecodesFromAnySufficientSubset(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	for _, k := range []int{1, 4, 32, 200} {
+		c, err := NewLTCodec(k, 0)
+		if err != nil {
+			t.Fatalf("codec: %v", err)
+		}
+		data := randomShards(rng, k, 48)
+		d := c.NewDecoder()
+		// 40% of symbols are lost, including data symbols.
+		id := uint32(0)
+		for !d.Done() {
+			if id > uint32(10*k+50) {
+				t.Fatalf("k=%d: no decode after %d symbols", k, id)
+			}
+			sym, _ := c.Symbol(data, id)
+			if rng.Float64() >= 0.4 {
+				if _, err := d.Add(id, sym); err != nil {
+					t.Fatalf("add: %v", err)
+				}
+			}
+			id++
+		}
+		got, err := d.Data()
+		if err != nil {
+			t.Fatalf("data: %v", err)
+		}
+		for i := range data {
+			if !bytes.Equal(got[i], data[i]) {
+				t.Fatalf("k=%d: symbol %d mismatch", k, i)
+			}
+		}
+		if k >= 32 && d.Received() > k+k/5+10 {
+			t.Fatalf("k=%d: needed %d symbols, overhead too high", k, d.Received())
+		}
+	}
+}
+
+func TestSchemesReconstructThroughInterface(t *testing.T) {
+	rs, _ := NewCodec(8, 4)
+	lt, _ := NewLTCodec(8, 12)
+	rng := rand.New(rand.NewSource(2))
+	for _, s := range []Scheme{rs, lt} {
+		data := randomShards(rng, s.DataShards(), 32)
+		shards, err := s.BuildShards(data)
+		if err != nil {
+			t.Fatalf("%T build: %v", s, err)
+		}
+		want := make([][]byte, len(shards))
+		copy(want, shards)
+		shards[0], shards[3], shards[9] = nil, nil, nil
+		if err := s.Reconstruct(shards); err != nil {
+			t.Fatalf("%T reconstruct: %v", s, err)
+		}
+		for i := range want {
+			if !bytes.Equal(shards[i], want[i]) {
+				t.Fatalf("%T shard %d mismatch", s, i)
+			}
+		}
+	}
+	if _, err := NewLTCodec(0, 1); err == nil {
+		t.Fatalf("expected invalid shard counts")
+	}
+}
+
+func TestFountainSenderRunsUntilDone(t *testing.T) {
+	payloads := [][]byte{[]byte("alpha"), []byte("be"), bytes.Repeat([]byte("g"), 300)}
+	for i := 0; i < 40; i++ {
+		payloads = append(payloads, []byte{byte(i), byte(i * 3)})
+	}
+	s, err := NewFountainSender(7, payloads)
+	if err != nil {
+		t.Fatalf("sender: %v", err)
+	}
+	r := NewFountainReceiver(0)
+	rng := rand.New(rand.NewSource(3))
+	// The first data symbol is held back and only delivered after decoding.
+	late, _ := s.Next()
+	var got [][]byte
+	for got == nil {
+		p, ok := s.Next()
+		if !ok {
+			t.Fatalf("sender stopped before completion")
+		}
+		if p.Total != 0 || p.BlockID != 7 {
+			t.Fatalf("bad fountain header %+v", p)
+		}
+		// 50% loss on the forward path.
+		if rng.Intn(2) == 0 {
+			continue
+		}
+		out, done, err := r.OnSymbol(p)
+		if err != nil {
+			t.Fatalf("symbol: %v", err)
+		}
+		if done {
+			got = out
+			s.Done()
+		}
+	}
+	if _, ok := s.Next(); ok {
+		t.Fatalf("sender continued after done")
+	}
+	for i := range payloads {
+		if !bytes.Equal(got[i], payloads[i]) {
+			t.Fatalf("payload %d mismatch: %q", i, got[i])
+		}
+	}
+	if out, done, _ := r.OnSymbol(late); out != nil || done {
+		t.Fatalf("decoded block should ignore late symbols")
+	}
+}
+
+func TestBroadcastSenderHonoursOverhead(t *testing.T) {
+	payloads := make([][]byte, 100)
+	for i := range payloads {
+		payloads[i] = bytes.Repeat([]byte{byte(i)}, 64)
+	}
+	s, err := NewBroadcastSender(1, payloads, 0.5)
+	if err != nil {
+		t.Fatalf("sender: %v", err)
+	}
+	r := NewFountainReceiver(0)
+	rng := rand.New(rand.NewSource(4))
+	decoded := false
+	for {
+		p, ok := s.Next()
+		if !ok {
+			break
+		}
+		// 25% loss and no feedback at all.
+		if rng.Float64() < 0.25 {
+			continue
+		}
+		if _, done, err := r.OnSymbol(p); err != nil {
+			t.Fatalf("symbol: %v", err)
+		} else if done {
+			decoded = true
+		}
+	}
+	if s.Sent() != 150 {
+		t.Fatalf("broadcast sent %d symbols, want 150", s.Sent())
+	}
+	if !decoded {
+		t.Fatalf("broadcast with 50%% overhead should survive 25%% loss")
+	}
+	if _, err := NewBroadcastSender(1, payloads, -1); err == nil {
+		t.Fatalf("expected overhead error")
+	}
+}
+
+func TestFountainReceiverForgetsOldBlocks(t *testing.T) {
+	const window = 16
+	r := NewFountainReceiver(window)
+	// Block 0 never completes: it needs two symbols and gets one.
+	stuck, err := NewFountainSender(0, [][]byte{[]byte("a"), []byte("b")})
+	if err != nil {
+		t.Fatalf("sender: %v", err)
+	}
+	p, _ := stuck.Next()
+	if _, _, err := r.OnSymbol(p); err != nil {
+		t.Fatalf("symbol: %v", err)
+	}
+	for id := uint64(1); id <= 1000; id++ {
+		s, err := NewFountainSender(id, [][]byte{[]byte("x")})
+		if err != nil {
+			t.Fatalf("sender: %v", err)
+		}
+		p, _ := s.Next()
+		if _, done, err := r.OnSymbol(p); err != nil || !done {
+			t.Fatalf("block %d: done %v err %v", id, done, err)
+		}
+	}
+	if len(r.done)+len(r.blocks) > window+window/4 {
+		t.Fatalf("receiver holds %d decoded and %d pending blocks", len(r.done), len(r.blocks))
+	}
+	if r.Pending(0) != 0 {
+		t.Fatalf("abandoned block still pending")
+	}
+	// Symbols for forgotten blocks are ignored rather than starting over.
+	p, _ = stuck.Next()
+	if out, done, err := r.OnSymbol(p); out != nil || done || err != nil || len(r.blocks) != 0 {
+		t.Fatalf("symbol for an old block accepted: %v %v", done, err)
+	}
+}
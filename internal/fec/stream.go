@@ -0,0 +1,456 @@
+package fec
+
+import (
+	"encoding/binary"
+	"errors"
+	"sort"
+	"time"
+
+	"riptide/internal/proto"
+)
+
+// Every shard starts with the original payload length so the receiver can
+// strip the zero padding a reconstructed shard comes back with.
+const lenPrefix = 2
+
+const maxShardPayload = 1<<16 - 1
+
+// StreamEncoder groups outgoing DATA payloads into coding blocks as they are
+// sent. Without interleaving a block is a run of consecutive sequence
+// numbers. With depth D > 1 the encoder works on spans of D*dataShards
+// packets and block j of a span takes every Dth packet starting at j, so a
+// burst of up to D losses costs each block at most one shard. Parity for a
+// block is returned as soon as it fills (or is flushed) and should be sent
+// right behind the DATA packet that completed it.
+type StreamEncoder struct {
+	dataShards   int
+	parityShards int
+	depth        int
+	spanStart    uint64
+	next         uint64
+	count        int
+	lanes        [][][]byte
+	// free holds parity buffers handed back through Recycle.
+	free [][]byte
+}
+
+func NewStreamEncoder(dataShards, parityShards int) (*StreamEncoder, error) {
+	return NewInterleavedStreamEncoder(dataShards, parityShards, 1)
+}
+
+// NewInterleavedStreamEncoder interleaves depth coding blocks. Latency to
+// repair grows with depth, since a block now spans depth*dataShards packets.
+func NewInterleavedStreamEncoder(dataShards, parityShards, depth int) (*StreamEncoder, error) {
+	if dataShards <= 0 || parityShards <= 0 {
+		return nil, errors.New("invalid shard counts")
+	}
+	if dataShards+parityShards > 256 {
+		return nil, errors.New("too many shards")
+	}
+	if depth <= 0 || depth > 0xffff {
+		return nil, errors.New("invalid interleave depth")
+	}
+	return &StreamEncoder{
+		dataShards:   dataShards,
+		parityShards: parityShards,
+		depth:        depth,
+		lanes:        make([][][]byte, depth),
+	}, nil
+}
+
+func (e *StreamEncoder) Depth() int { return e.depth }
+
+// Add records the payload of the DATA packet sent with seq. It returns the
+// parity for any block that seq completes. A seq that does not follow the
+// previous one closes the open blocks early, so retransmissions and other
+// packet types must not be passed in.
+func (e *StreamEncoder) Add(seq uint64, payload []byte) ([]proto.FECParityPayload, error) {
+	if len(payload) > maxShardPayload {
+		return nil, errors.New("payload too large for fec shard")
+	}
+	var out []proto.FECParityPayload
+	if e.count > 0 && seq != e.next {
+		p, err := e.Flush()
+		if err != nil {
+			return nil, err
+		}
+		out = p
+	}
+	if e.count == 0 {
+		e.spanStart = seq
+	}
+	lane := e.count % e.depth
+	// Reuse the copy of the payload that last held this slot.
+	l := e.lanes[lane]
+	var cp []byte
+	if len(l) < cap(l) {
+		cp = l[: len(l)+1 : cap(l)][len(l)][:0]
+	}
+	cp = append(cp, payload...)
+	e.lanes[lane] = append(l, cp)
+	e.count++
+	e.next = seq + 1
+	if len(e.lanes[lane]) == e.dataShards {
+		p, err := e.encodeLane(lane)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, p...)
+	}
+	if e.count == e.dataShards*e.depth {
+		e.count = 0
+	}
+	return out, nil
+}
+
+// Flush encodes partially filled blocks, e.g. at the end of a transfer or
+// before an idle period, so the tail is protected without waiting.
+func (e *StreamEncoder) Flush() ([]proto.FECParityPayload, error) {
+	var out []proto.FECParityPayload
+	for lane := range e.lanes {
+		p, err := e.encodeLane(lane)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, p...)
+	}
+	e.count = 0
+	return out, nil
+}
+
+func (e *StreamEncoder) encodeLane(lane int) ([]proto.FECParityPayload, error) {
+	pending := e.lanes[lane]
+	k := len(pending)
+	if k == 0 {
+		return nil, nil
+	}
+	size := 0
+	for _, p := range pending {
+		if len(p) > size {
+			size = len(p)
+		}
+	}
+	codec, err := CachedCodec(k, e.parityShards)
+	if err != nil {
+		return nil, err
+	}
+	// Data shards are scratch space; parity is handed to the caller and
+	// comes back, if at all, through Recycle.
+	scratch := GetShards(k, lenPrefix+size)
+	defer scratch.Release()
+	shards := scratch.Block(e.parityShards)
+	for i, p := range pending {
+		putShard(scratch.S[i], p)
+	}
+	for i := 0; i < e.parityShards; i++ {
+		shards[k+i] = e.parityBuf(lenPrefix + size)
+	}
+	if err := codec.EncodeInPlace(shards); err != nil {
+		return nil, err
+	}
+	out := make([]proto.FECParityPayload, 0, e.parityShards)
+	for i := k; i < len(shards); i++ {
+		out = append(out, proto.FECParityPayload{
+			BlockID:    e.spanStart + uint64(lane),
+			Index:      uint16(i),
+			Total:      uint16(len(shards)),
+			DataShards: uint16(k),
+			Stride:     uint16(e.depth),
+			Parity:     shards[i],
+		})
+	}
+	e.lanes[lane] = pending[:0]
+	return out, nil
+}
+
+// Recycle hands the parity of ps back to the encoder for later blocks once
+// the caller has sent it and no longer holds it. Calling it is optional;
+// parity that is never recycled is left to the garbage collector.
+func (e *StreamEncoder) Recycle(ps []proto.FECParityPayload) {
+	for _, p := range ps {
+		if len(e.free) >= e.parityShards*e.depth {
+			return
+		}
+		e.free = append(e.free, p.Parity[:0])
+	}
+}
+
+func (e *StreamEncoder) parityBuf(size int) []byte {
+	if n := len(e.free); n > 0 {
+		b := e.free[n-1]
+		e.free[n-1] = nil
+		e.free = e.free[:n-1]
+		if cap(b) >= size {
+			return b[:size]
+		}
+	}
+	return make([]byte, size)
+}
+
+func packShard(p []byte, size int) []byte {
+	s := make([]byte, size)
+	putShard(s, p)
+	return s
+}
+
+// putShard writes p with its length prefix into a zeroed shard buffer.
+func putShard(s, p []byte) {
+	binary.BigEndian.PutUint16(s[:lenPrefix], uint16(len(p)))
+	copy(s[lenPrefix:], p)
+}
+
+// Recovered is a DATA payload rebuilt from parity.
+type Recovered struct {
+	Seq     uint64
+	Payload []byte
+}
+
+type rxBlock struct {
+	dataShards int
+	stride     uint64
+	parity     [][]byte
+	size       int
+}
+
+func (b *rxBlock) member(first uint64, i int) uint64 {
+	return first + uint64(i)*b.stride
+}
+
+func (b *rxBlock) contains(first, seq uint64) bool {
+	if seq < first || (seq-first)%b.stride != 0 {
+		return false
+	}
+	return (seq-first)/b.stride < uint64(b.dataShards)
+}
+
+// StreamDecoder buffers DATA payloads and parity per block and rebuilds
+// missing members as soon as enough shards have arrived. Sequence gaps are
+// held back for a short time so FEC gets a chance to repair them before
+// they are reported for retransmission.
+type StreamDecoder struct {
+	hold    time.Duration
+	window  uint64
+	data    map[uint64][]byte
+	blocks  map[uint64]*rxBlock
+	gaps    map[uint64]time.Time
+	highest uint64
+	started bool
+	pruned  uint64
+}
+
+// NewStreamDecoder keeps at most window sequence numbers of history and
+// reports a gap through Missing once it has been unrepaired for hold.
+func NewStreamDecoder(hold time.Duration, window int) *StreamDecoder {
+	if window <= 0 {
+		window = 4096
+	}
+	return &StreamDecoder{
+		hold:   hold,
+		window: uint64(window),
+		data:   make(map[uint64][]byte),
+		blocks: make(map[uint64]*rxBlock),
+		gaps:   make(map[uint64]time.Time),
+	}
+}
+
+// OnData records a received DATA payload and returns any block members it
+// allowed to be rebuilt.
+func (d *StreamDecoder) OnData(seq uint64, payload []byte, now time.Time) ([]Recovered, error) {
+	if d.started && seq+d.window <= d.highest {
+		return nil, nil
+	}
+	if _, ok := d.data[seq]; ok {
+		return nil, nil
+	}
+	cp := make([]byte, len(payload))
+	copy(cp, payload)
+	d.data[seq] = cp
+	delete(d.gaps, seq)
+	d.advance(seq, now)
+	for first, b := range d.blocks {
+		if b.contains(first, seq) {
+			return d.recover(first, b)
+		}
+	}
+	return nil, nil
+}
+
+// OnParity records a parity shard. Block members not yet received are
+// treated as gaps from this point even if no later DATA has arrived.
+func (d *StreamDecoder) OnParity(p proto.FECParityPayload, now time.Time) ([]Recovered, error) {
+	k, n := int(p.DataShards), int(p.Total)
+	if k <= 0 || n <= k || int(p.Index) < k || int(p.Index) >= n || len(p.Parity) < lenPrefix {
+		return nil, errors.New("malformed fec parity")
+	}
+	stride := uint64(p.Stride)
+	if stride == 0 {
+		stride = 1
+	}
+	last := p.BlockID + uint64(k-1)*stride
+	if d.started && last+d.window <= d.highest {
+		return nil, nil
+	}
+	b, ok := d.blocks[p.BlockID]
+	if !ok {
+		b = &rxBlock{dataShards: k, stride: stride, parity: make([][]byte, n-k), size: len(p.Parity)}
+		if d.complete(p.BlockID, b) {
+			return nil, nil
+		}
+		d.blocks[p.BlockID] = b
+	}
+	if b.dataShards != k || b.stride != stride || len(b.parity) != n-k || b.size != len(p.Parity) {
+		return nil, errors.New("inconsistent fec block")
+	}
+	if b.parity[int(p.Index)-k] != nil {
+		return nil, nil
+	}
+	cp := make([]byte, len(p.Parity))
+	copy(cp, p.Parity)
+	b.parity[int(p.Index)-k] = cp
+	d.advance(last, now)
+	for i := 0; i < k; i++ {
+		s := b.member(p.BlockID, i)
+		if _, ok := d.data[s]; !ok {
+			if _, ok := d.gaps[s]; !ok {
+				d.gaps[s] = now
+			}
+		}
+	}
+	return d.recover(p.BlockID, b)
+}
+
+// Missing returns the gaps that have waited at least hold without being
+// repaired, in sequence order. Each gap is reported again only after
+// another hold period.
+func (d *StreamDecoder) Missing(now time.Time) []uint64 {
+	var out []uint64
+	for seq, at := range d.gaps {
+		if now.Sub(at) < d.hold {
+			continue
+		}
+		out = append(out, seq)
+		d.gaps[seq] = now
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i] < out[j] })
+	return out
+}
+
+func (d *StreamDecoder) advance(seq uint64, now time.Time) {
+	if !d.started {
+		d.started = true
+		d.highest = seq
+		return
+	}
+	if seq <= d.highest {
+		return
+	}
+	from := d.highest + 1
+	if seq-from > d.window {
+		from = seq - d.window
+	}
+	for s := from; s < seq; s++ {
+		if _, ok := d.data[s]; !ok {
+			d.gaps[s] = now
+		}
+	}
+	d.highest = seq
+	d.prune()
+}
+
+func (d *StreamDecoder) prune() {
+	if d.highest < d.window {
+		return
+	}
+	floor := d.highest - d.window + 1
+	if floor < d.pruned+d.window/4 {
+		return
+	}
+	d.pruned = floor
+	for s := range d.data {
+		if s < floor {
+			delete(d.data, s)
+		}
+	}
+	for s := range d.gaps {
+		if s < floor {
+			delete(d.gaps, s)
+		}
+	}
+	for first, b := range d.blocks {
+		if b.member(first, b.dataShards-1) < floor {
+			delete(d.blocks, first)
+		}
+	}
+}
+
+func (d *StreamDecoder) complete(first uint64, b *rxBlock) bool {
+	for i := 0; i < b.dataShards; i++ {
+		if _, ok := d.data[b.member(first, i)]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+func (d *StreamDecoder) recover(first uint64, b *rxBlock) ([]Recovered, error) {
+	var lost []int
+	for i := 0; i < b.dataShards; i++ {
+		if _, ok := d.data[b.member(first, i)]; !ok {
+			lost = append(lost, i)
+		}
+	}
+	if len(lost) == 0 {
+		delete(d.blocks, first)
+		return nil, nil
+	}
+	have := 0
+	for _, p := range b.parity {
+		if p != nil {
+			have++
+		}
+	}
+	if have < len(lost) {
+		return nil, nil
+	}
+	codec, err := CachedCodec(b.dataShards, len(b.parity))
+	if err != nil {
+		return nil, err
+	}
+	scratch := GetShards(b.dataShards, b.size)
+	defer scratch.Release()
+	shards := make([][]byte, b.dataShards+len(b.parity))
+	for i := 0; i < b.dataShards; i++ {
+		p, ok := d.data[b.member(first, i)]
+		if !ok {
+			// Leave a nil entry with capacity for the rebuilt shard.
+			shards[i] = scratch.S[i][:0]
+			continue
+		}
+		if lenPrefix+len(p) > b.size {
+			delete(d.blocks, first)
+			return nil, errors.New("data payload larger than fec shard")
+		}
+		putShard(scratch.S[i], p)
+		shards[i] = scratch.S[i]
+	}
+	copy(shards[b.dataShards:], b.parity)
+	delete(d.blocks, first)
+	if err := codec.ReconstructData(shards); err != nil {
+		return nil, err
+	}
+	out := make([]Recovered, 0, len(lost))
+	for _, i := range lost {
+		s := shards[i]
+		l := int(binary.BigEndian.Uint16(s[:lenPrefix]))
+		if lenPrefix+l > len(s) {
+			return out, errors.New("bad recovered shard length")
+		}
+		seq := b.member(first, i)
+		p := make([]byte, l)
+		copy(p, s[lenPrefix:lenPrefix+l])
+		d.data[seq] = p
+		delete(d.gaps, seq)
+		out = append(out, Recovered{Seq: seq, Payload: p})
+	}
+	return out, nil
+}
@@ -0,0 +1,216 @@
+package fec
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"riptide/internal/checksum"
+	"riptide/internal/proto"
+)
+
+type sent struct {
+	seq     uint64
+	payload []byte
+	parity  []proto.FECParityPayload
+}
+
+// encodeStream runs count DATA payloads of varying length through a stream
+// encoder and returns what the sender would put on the wire, in order.
+func encodeStream(t *testing.T, k, m, count int) []sent {
+	t.Helper()
+	e, err := NewStreamEncoder(k, m)
+	if err != nil {
+		t.Fatalf("encoder: %v", err)
+	}
+	var out []sent
+	for i := 0; i < count; i++ {
+		data := bytes.Repeat([]byte{byte('a' + i)}, 10+i*7)
+		dp := proto.DataPayload{ChunkID: uint64(i), Offset: uint64(i * 100), Checksum: checksum.Compute128(data), Data: data}
+		seq := uint64(100 + i)
+		par, err := e.Add(seq, dp.Encode())
+		if err != nil {
+			t.Fatalf("add: %v", err)
+		}
+		out = append(out, sent{seq: seq, payload: dp.Encode(), parity: par})
+	}
+	par, err := e.Flush()
+	if err != nil {
+		t.Fatalf("flush: %v", err)
+	}
+	out[len(out)-1].parity = append(out[len(out)-1].parity, par...)
+	return out
+}
+
+func TestStreamRecoversLossesAndStripsPadding(t *testing.T) {
+	stream := encodeStream(t, 4, 2, 10)
+	for _, i := range []int{3, 7, 9} {
+		if len(stream[i].parity) != 2 {
+			t.Fatalf("expected parity after seq %d, got %d", stream[i].seq, len(stream[i].parity))
+		}
+	}
+	if stream[9].parity[0].DataShards != 2 || stream[9].parity[0].BlockID != 108 {
+		t.Fatalf("flushed block header: %+v", stream[9].parity[0])
+	}
+
+	lost := map[uint64]bool{100: true, 102: true, 105: true, 109: true}
+	d := NewStreamDecoder(50*time.Millisecond, 0)
+	now := time.Unix(0, 0)
+	got := map[uint64][]byte{}
+	for _, s := range stream {
+		if !lost[s.seq] {
+			rec, err := d.OnData(s.seq, s.payload, now)
+			if err != nil {
+				t.Fatalf("data: %v", err)
+			}
+			for _, r := range rec {
+				got[r.Seq] = r.Payload
+			}
+		}
+		for _, p := range s.parity {
+			rec, err := d.OnParity(p, now)
+			if err != nil {
+				t.Fatalf("parity: %v", err)
+			}
+			for _, r := range rec {
+				got[r.Seq] = r.Payload
+			}
+		}
+	}
+	for _, s := range stream {
+		if !lost[s.seq] {
+			continue
+		}
+		if !bytes.Equal(got[s.seq], s.payload) {
+			t.Fatalf("seq %d not recovered exactly: %d bytes want %d", s.seq, len(got[s.seq]), len(s.payload))
+		}
+		dp, err := proto.DecodeDataPayload(got[s.seq])
+		if err != nil || checksum.Compute128(dp.Data) != dp.Checksum {
+			t.Fatalf("seq %d: recovered payload corrupt", s.seq)
+		}
+	}
+	if m := d.Missing(now.Add(time.Second)); len(m) != 0 {
+		t.Fatalf("repaired gaps reported missing: %v", m)
+	}
+}
+
+func TestStreamParityBeforeData(t *testing.T) {
+	stream := encodeStream(t, 4, 1, 4)
+	d := NewStreamDecoder(time.Second, 0)
+	now := time.Unix(0, 0)
+	if rec, _ := d.OnParity(stream[3].parity[0], now); len(rec) != 0 {
+		t.Fatalf("nothing to recover yet")
+	}
+	var rec []Recovered
+	for _, s := range stream[1:] {
+		r, err := d.OnData(s.seq, s.payload, now)
+		if err != nil {
+			t.Fatalf("data: %v", err)
+		}
+		rec = append(rec, r...)
+	}
+	if len(rec) != 1 || rec[0].Seq != 100 || !bytes.Equal(rec[0].Payload, stream[0].payload) {
+		t.Fatalf("expected seq 100 rebuilt, got %+v", rec)
+	}
+}
+
+func TestStreamReportsUnrecoverableGapsAfterHold(t *testing.T) {
+	stream := encodeStream(t, 4, 1, 8)
+	d := NewStreamDecoder(100*time.Millisecond, 0)
+	now := time.Unix(0, 0)
+	for _, s := range stream {
+		// Two losses in the first block exceed its single parity shard; the
+		// tail of the second block is only known missing from its parity.
+		if s.seq != 100 && s.seq != 101 && s.seq != 107 {
+			if _, err := d.OnData(s.seq, s.payload, now); err != nil {
+				t.Fatalf("data: %v", err)
+			}
+		}
+		for _, p := range s.parity {
+			if _, err := d.OnParity(p, now); err != nil {
+				t.Fatalf("parity: %v", err)
+			}
+		}
+	}
+	if m := d.Missing(now.Add(50 * time.Millisecond)); len(m) != 0 {
+		t.Fatalf("gaps reported before hold: %v", m)
+	}
+	m := d.Missing(now.Add(100 * time.Millisecond))
+	if len(m) != 2 || m[0] != 100 || m[1] != 101 {
+		t.Fatalf("missing = %v, want [100 101]", m)
+	}
+	if m := d.Missing(now.Add(150 * time.Millisecond)); len(m) != 0 {
+		t.Fatalf("gap re-reported within hold: %v", m)
+	}
+	// A retransmission of one member lets FEC rebuild the other.
+	rec, err := d.OnData(100, stream[0].payload, now)
+	if err != nil || len(rec) != 1 || rec[0].Seq != 101 {
+		t.Fatalf("expected 101 rebuilt after retransmit: %+v %v", rec, err)
+	}
+}
+
+func TestStreamEncoderClosesBlockOnSeqJump(t *testing.T) {
+	e, _ := NewStreamEncoder(4, 2)
+	if p, _ := e.Add(1, []byte("x")); len(p) != 0 {
+		t.Fatalf("unexpected parity")
+	}
+	p, err := e.Add(9, []byte("y"))
+	if err != nil || len(p) != 2 || p[0].BlockID != 1 || p[0].DataShards != 1 {
+		t.Fatalf("jump should close block 1: %+v %v", p, err)
+	}
+	if _, err := NewStreamEncoder(200, 100); err == nil {
+		t.Fatalf("expected shard limit error")
+	}
+}
+
+func TestInterleavedStreamSurvivesBurst(t *testing.T) {
+	e, err := NewInterleavedStreamEncoder(4, 1, 4)
+	if err != nil {
+		t.Fatalf("encoder: %v", err)
+	}
+	d := NewStreamDecoder(time.Second, 0)
+	now := time.Unix(0, 0)
+	payloads := map[uint64][]byte{}
+	var parity []proto.FECParityPayload
+	for seq := uint64(0); seq < 16; seq++ {
+		p := bytes.Repeat([]byte{byte(seq)}, int(5+seq))
+		payloads[seq] = p
+		par, err := e.Add(seq, p)
+		if err != nil {
+			t.Fatalf("add: %v", err)
+		}
+		parity = append(parity, par...)
+	}
+	if len(parity) != 4 {
+		t.Fatalf("expected one parity per lane, got %d", len(parity))
+	}
+	for i, p := range parity {
+		if p.Stride != 4 || p.BlockID != uint64(i) {
+			t.Fatalf("lane %d parity header %+v", i, p)
+		}
+	}
+	// A burst of four consecutive packets hits each lane once.
+	got := map[uint64][]byte{}
+	for seq := uint64(0); seq < 16; seq++ {
+		if seq >= 5 && seq <= 8 {
+			continue
+		}
+		if _, err := d.OnData(seq, payloads[seq], now); err != nil {
+			t.Fatalf("data: %v", err)
+		}
+	}
+	for _, p := range parity {
+		rec, err := d.OnParity(p, now)
+		if err != nil {
+			t.Fatalf("parity: %v", err)
+		}
+		for _, r := range rec {
+			got[r.Seq] = r.Payload
+		}
+	}
+	for seq := uint64(5); seq <= 8; seq++ {
+		if !bytes.Equal(got[seq], payloads[seq]) {
+			t.Fatalf("seq %d not recovered", seq)
+		}
+	}
+}
@@ -0,0 +1,167 @@
+package filelist
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// Action is what the receiver does with one path.
+type Action uint8
+
+const (
+	// ActSkip: the destination already matches.
+	ActSkip Action = iota + 1
+	// ActDelta: a regular file differs; request it as a delta against the
+	// existing file.
+	ActDelta
+	// ActFull: nothing usable exists; create it (full send for regular
+	// files, local creation for directories, links and special files).
+	ActFull
+	// ActDelete: present only in the destination.
+	ActDelete
+	// ActLink: a hardlink to an earlier entry of the transfer set; link
+	// Decision.LinkTo instead of sending content.
+	ActLink
+)
+
+func (a Action) String() string {
+	switch a {
+	case ActSkip:
+		return "skip"
+	case ActDelta:
+		return "delta"
+	case ActFull:
+		return "full"
+	case ActDelete:
+		return "delete"
+	case ActLink:
+		return "link"
+	}
+	return "unknown"
+}
+
+// Decision pairs an entry with its action. For ActDelete the entry is the
+// destination's. LinkTo is set for ActLink only.
+type Decision struct {
+	Entry  Entry
+	Action Action
+	LinkTo string
+}
+
+type CompareOptions struct {
+	// Checksum compares whole-file hashes instead of the size and mtime
+	// quick check; the source list must have been scanned with Checksum.
+	Checksum bool
+	// Delete removes destination paths missing from the source list.
+	Delete bool
+	// HardLinks turns every later member of a source hardlink group into
+	// an ActLink to the group's first path.
+	HardLinks bool
+}
+
+// Compare decides per source entry what the receiver at destRoot needs.
+// Decisions follow the source list's order; deletions come last, deepest
+// paths first, so directories are emptied before they are removed.
+func Compare(src []Entry, destRoot string, opts CompareOptions) ([]Decision, error) {
+	dest := map[string]Entry{}
+	if _, err := os.Lstat(destRoot); err == nil {
+		list, err := Scan(destRoot, ScanOptions{})
+		if err != nil {
+			return nil, err
+		}
+		for _, e := range list {
+			dest[e.Path] = e
+		}
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+
+	out := make([]Decision, 0, len(src))
+	seen := make(map[string]bool, len(src))
+	leaders := map[fileID]int{} // hardlink group -> index in out
+	for _, s := range src {
+		seen[s.Path] = true
+		d, ok := dest[s.Path]
+		if opts.HardLinks && s.Linked() {
+			id := fileID{s.Dev, s.Inode}
+			if i, found := leaders[id]; found {
+				out = append(out, linkDecision(s, out[i], d, ok, dest))
+				continue
+			}
+			leaders[id] = len(out)
+		}
+		act, err := decide(s, d, ok, filepath.Join(destRoot, filepath.FromSlash(s.Path)), opts)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, Decision{Entry: s, Action: act})
+	}
+	if opts.Delete {
+		var gone []Entry
+		for p, e := range dest {
+			if !seen[p] {
+				gone = append(gone, e)
+			}
+		}
+		sort.Slice(gone, func(i, j int) bool { return gone[i].Path > gone[j].Path })
+		for _, e := range gone {
+			out = append(out, Decision{Entry: e, Action: ActDelete})
+		}
+	}
+	return out, nil
+}
+
+// linkDecision handles a hardlink follower. It is skipped only if the
+// leader is left in place and the destination already links the two;
+// rewriting the leader gives it a new inode, so followers are relinked.
+func linkDecision(s Entry, leader Decision, d Entry, exists bool, dest map[string]Entry) Decision {
+	if leader.Action == ActSkip && exists && d.Type == TypeFile {
+		if l, ok := dest[leader.Entry.Path]; ok && l.Dev == d.Dev && l.Inode == d.Inode {
+			return Decision{Entry: s, Action: ActSkip}
+		}
+	}
+	return Decision{Entry: s, Action: ActLink, LinkTo: leader.Entry.Path}
+}
+
+func decide(s, d Entry, exists bool, destPath string, opts CompareOptions) (Action, error) {
+	if !exists || s.Type != d.Type {
+		return ActFull, nil
+	}
+	switch s.Type {
+	case TypeFile:
+		if opts.Checksum {
+			if !s.HasSum() {
+				return 0, errors.New("checksum comparison needs a file list scanned with checksums")
+			}
+			if s.Size == d.Size {
+				sum, err := FileSum(destPath)
+				if err != nil {
+					return 0, err
+				}
+				if sum == s.Sum {
+					return ActSkip, nil
+				}
+			}
+		} else if s.Size == d.Size && s.Mtime == d.Mtime {
+			return ActSkip, nil
+		}
+		if d.Size == 0 {
+			return ActFull, nil
+		}
+		return ActDelta, nil
+	case TypeSymlink:
+		if s.Target == d.Target {
+			return ActSkip, nil
+		}
+		return ActFull, nil
+	case TypeCharDev, TypeBlockDev:
+		if s.Rdev == d.Rdev {
+			return ActSkip, nil
+		}
+		return ActFull, nil
+	}
+	// Directories, FIFOs and sockets carry no content.
+	return ActSkip, nil
+}
@@ -0,0 +1,274 @@
+// Package filelist walks a source tree into a list of entries, encodes
+// that list for the wire, and lets the receiver decide what to do with
+// each file.
+package filelist
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+
+	"github.com/zeebo/blake3"
+)
+
+type Type uint8
+
+const (
+	TypeFile Type = iota + 1
+	TypeDir
+	TypeSymlink
+	TypeCharDev
+	TypeBlockDev
+	TypeFIFO
+	TypeSocket
+)
+
+func (t Type) String() string {
+	switch t {
+	case TypeFile:
+		return "file"
+	case TypeDir:
+		return "dir"
+	case TypeSymlink:
+		return "symlink"
+	case TypeCharDev:
+		return "chardev"
+	case TypeBlockDev:
+		return "blockdev"
+	case TypeFIFO:
+		return "fifo"
+	case TypeSocket:
+		return "socket"
+	}
+	return fmt.Sprintf("type(%d)", uint8(t))
+}
+
+// Entry describes one path under the transfer root. Path is slash
+// separated and relative to the root, which itself is ".". Mode holds the
+// Unix permission, setuid, setgid and sticky bits (0o7777). Dev and Inode
+// are only meaningful for regular files with Nlink > 1, where they identify
+// hardlinks within the transfer set. Sum is the whole-file BLAKE3-256 of a
+// regular file, present only when the list was scanned with Checksum.
+// XAttrs, ACL and DefaultACL are filled by scans with XAttrs or ACLs and
+// travel in the metadata stream (WriteMeta), not in the list itself.
+type Entry struct {
+	Path   string
+	Type   Type
+	Size   int64
+	Mtime  int64 // Unix nanoseconds
+	Mode   uint32
+	UID    uint32
+	GID    uint32
+	Target string
+	Rdev   uint64
+	Dev    uint64
+	Inode  uint64
+	Nlink  uint64
+	Sum    [32]byte
+
+	XAttrs     []XAttr
+	ACL        ACL
+	DefaultACL ACL
+}
+
+// HasSum reports whether the entry carries a whole-file checksum.
+func (e Entry) HasSum() bool {
+	return e.Sum != [32]byte{}
+}
+
+// Linked reports whether the entry is a regular file with other hardlinks.
+func (e Entry) Linked() bool {
+	return e.Type == TypeFile && e.Nlink > 1
+}
+
+type ScanOptions struct {
+	// Checksum fills Entry.Sum for every regular file.
+	Checksum bool
+	// CopyLinks records what each symlink points to instead of the link
+	// itself, descending into linked directories (rsync --copy-links).
+	CopyLinks bool
+	// SafeLinks leaves out symlinks that are absolute or lead out of the
+	// root (rsync --safe-links).
+	SafeLinks bool
+	// XAttrs records extended attributes (rsync -X): user.* always, and
+	// trusted.* and security.* when running as root.
+	XAttrs bool
+	// ACLs records POSIX access and default ACLs (rsync -A).
+	ACLs bool
+}
+
+// Scan walks root in lexical order and returns an entry for root itself
+// (".") and everything below it. Symlinks are recorded, not followed,
+// unless opts.CopyLinks is set.
+func Scan(root string, opts ScanOptions) ([]Entry, error) {
+	s := scanner{opts: opts, dirs: map[fileID]bool{}, root: os.Geteuid() == 0}
+	if err := s.visit(root, "."); err != nil {
+		return nil, err
+	}
+	return s.out, nil
+}
+
+type fileID struct{ dev, ino uint64 }
+
+type scanner struct {
+	opts ScanOptions
+	out  []Entry
+	// dirs holds the directories on the current descent path, so that a
+	// followed symlink leading back up is reported instead of recursing
+	// forever.
+	dirs map[fileID]bool
+	root bool
+}
+
+func (s *scanner) visit(p, rel string) error {
+	e, err := statEntry(p, rel, s.opts.CopyLinks)
+	if err != nil {
+		return err
+	}
+	if e.Type == TypeSymlink && s.opts.SafeLinks && !SafeLink(rel, e.Target) {
+		return nil
+	}
+	if s.opts.Checksum && e.Type == TypeFile {
+		if e.Sum, err = FileSum(p); err != nil {
+			return err
+		}
+	}
+	if s.opts.XAttrs {
+		if e.XAttrs, err = readXattrs(p, s.opts.CopyLinks, s.root); err != nil {
+			return err
+		}
+	}
+	if s.opts.ACLs && e.Type != TypeSymlink {
+		if e.ACL, e.DefaultACL, err = readACLs(p, s.opts.CopyLinks, e.Type == TypeDir); err != nil {
+			return err
+		}
+	}
+	s.out = append(s.out, e)
+	if e.Type != TypeDir {
+		return nil
+	}
+	if id := (fileID{e.Dev, e.Inode}); id != (fileID{}) {
+		if s.dirs[id] {
+			return fmt.Errorf("%s: symlink loop", p)
+		}
+		s.dirs[id] = true
+		defer delete(s.dirs, id)
+	}
+	ents, err := os.ReadDir(p)
+	if err != nil {
+		return err
+	}
+	for _, d := range ents {
+		child := d.Name()
+		if rel != "." {
+			child = rel + "/" + child
+		}
+		if err := s.visit(filepath.Join(p, d.Name()), child); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SafeLink reports whether a symlink at rel (slash separated, relative to
+// the root) pointing at target resolves inside the root. The check is
+// lexical, like rsync's: a relative target that climbs out through ".."
+// or any absolute target is unsafe.
+func SafeLink(rel, target string) bool {
+	if target == "" || path.IsAbs(target) {
+		return false
+	}
+	return filepath.IsLocal(filepath.FromSlash(path.Join(path.Dir(rel), target)))
+}
+
+func statEntry(p, rel string, follow bool) (Entry, error) {
+	stat := os.Lstat
+	if follow {
+		stat = os.Stat
+	}
+	fi, err := stat(p)
+	if err != nil {
+		if follow && errors.Is(err, fs.ErrNotExist) {
+			if _, lerr := os.Lstat(p); lerr == nil {
+				return Entry{}, fmt.Errorf("%s: symlink has no referent", p)
+			}
+		}
+		return Entry{}, err
+	}
+	e := Entry{
+		Path:  rel,
+		Mtime: fi.ModTime().UnixNano(),
+		Mode:  unixMode(fi.Mode()),
+	}
+	m := fi.Mode()
+	switch {
+	case m.IsRegular():
+		e.Type = TypeFile
+		e.Size = fi.Size()
+	case m.IsDir():
+		e.Type = TypeDir
+	case m&fs.ModeSymlink != 0:
+		e.Type = TypeSymlink
+		if e.Target, err = os.Readlink(p); err != nil {
+			return Entry{}, err
+		}
+	case m&fs.ModeCharDevice != 0:
+		e.Type = TypeCharDev
+	case m&fs.ModeDevice != 0:
+		e.Type = TypeBlockDev
+	case m&fs.ModeNamedPipe != 0:
+		e.Type = TypeFIFO
+	case m&fs.ModeSocket != 0:
+		e.Type = TypeSocket
+	default:
+		return Entry{}, fmt.Errorf("%s: unsupported file type %v", p, m.Type())
+	}
+	fillSys(&e, fi)
+	return e, nil
+}
+
+func unixMode(m fs.FileMode) uint32 {
+	u := uint32(m.Perm())
+	if m&fs.ModeSetuid != 0 {
+		u |= 0o4000
+	}
+	if m&fs.ModeSetgid != 0 {
+		u |= 0o2000
+	}
+	if m&fs.ModeSticky != 0 {
+		u |= 0o1000
+	}
+	return u
+}
+
+// FileSum is the whole-file BLAKE3-256 used by checksum comparisons.
+func FileSum(p string) ([32]byte, error) {
+	var sum [32]byte
+	f, err := os.Open(p)
+	if err != nil {
+		return sum, err
+	}
+	defer f.Close()
+	h := blake3.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return sum, err
+	}
+	h.Sum(sum[:0])
+	return sum, nil
+}
+
+// validPath rejects paths a hostile peer could use to escape the
+// destination root.
+func validPath(p string) error {
+	if p == "." {
+		return nil
+	}
+	if p == "" || path.Clean(p) != p || !filepath.IsLocal(filepath.FromSlash(p)) {
+		return errors.New("unsafe path in file list: " + p)
+	}
+	return nil
+}
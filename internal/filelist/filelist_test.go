@@ -0,0 +1,295 @@
+//go:build unix
+
+package filelist
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"syscall"
+	"testing"
+	"time"
+
+	"riptide/internal/cli"
+)
+
+func writeFile(t *testing.T, p string, data string, mtime time.Time) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(p, []byte(data), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(p, mtime, mtime); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func byPath(list []Entry) map[string]Entry {
+	m := make(map[string]Entry, len(list))
+	for _, e := range list {
+		m[e.Path] = e
+	}
+	return m
+}
+
+func TestScan(t *testing.T) {
+	root := t.TempDir()
+	mtime := time.Unix(1_700_000_000, 123456789)
+	writeFile(t, filepath.Join(root, "a.txt"), "hello", mtime)
+	writeFile(t, filepath.Join(root, "dir", "b.txt"), "world!", mtime)
+	if err := os.Chmod(filepath.Join(root, "dir", "b.txt"), os.ModeSetuid|0o750); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("../a.txt", filepath.Join(root, "dir", "link")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Link(filepath.Join(root, "a.txt"), filepath.Join(root, "dir", "hard")); err != nil {
+		t.Fatal(err)
+	}
+	if err := syscall.Mkfifo(filepath.Join(root, "fifo"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	list, err := Scan(root, ScanOptions{Checksum: true})
+	if err != nil {
+		t.Fatalf("scan: %v", err)
+	}
+	var paths []string
+	for _, e := range list {
+		paths = append(paths, e.Path)
+	}
+	want := []string{".", "a.txt", "dir", "dir/b.txt", "dir/hard", "dir/link", "fifo"}
+	if !reflect.DeepEqual(paths, want) {
+		t.Fatalf("paths %v want %v", paths, want)
+	}
+	m := byPath(list)
+	a := m["a.txt"]
+	if a.Type != TypeFile || a.Size != 5 || a.Mtime != mtime.UnixNano() || a.Mode != 0o644 || !a.HasSum() {
+		t.Fatalf("a.txt: %+v", a)
+	}
+	if a.UID != uint32(os.Getuid()) || a.GID != uint32(os.Getgid()) {
+		t.Fatalf("a.txt owner %d:%d", a.UID, a.GID)
+	}
+	if b := m["dir/b.txt"]; b.Mode != 0o4750 {
+		t.Fatalf("b.txt mode %o", b.Mode)
+	}
+	if l := m["dir/link"]; l.Type != TypeSymlink || l.Target != "../a.txt" || l.HasSum() {
+		t.Fatalf("link: %+v", l)
+	}
+	if h := m["dir/hard"]; !h.Linked() || !a.Linked() || h.Inode != a.Inode || h.Dev != a.Dev {
+		t.Fatalf("hardlink not detected: %+v vs %+v", h, a)
+	}
+	if m["dir"].Type != TypeDir || m["fifo"].Type != TypeFIFO {
+		t.Fatalf("types: dir %v fifo %v", m["dir"].Type, m["fifo"].Type)
+	}
+}
+
+func TestWireRoundTrip(t *testing.T) {
+	list := []Entry{
+		{Path: ".", Type: TypeDir, Mode: 0o755, Mtime: 1},
+		{Path: "src/pkg/alpha.go", Type: TypeFile, Size: 1234, Mtime: 1_700_000_000_123456789, Mode: 0o644, UID: 1000, GID: 1000},
+		{Path: "src/pkg/beta.go", Type: TypeFile, Size: 99, Mtime: -5, Mode: 0o644, UID: 1000, GID: 1000, Sum: [32]byte{1, 2, 3}},
+		{Path: "src/pkg/link", Type: TypeSymlink, Target: "beta.go", Mode: 0o777, UID: 1000, GID: 1000},
+		{Path: "src/pkg/x", Type: TypeFile, Size: 1, Mode: 0o600, Dev: 7, Inode: 42, Nlink: 2},
+		{Path: "src/tty", Type: TypeCharDev, Rdev: 0x0501, Mode: 0o620, GID: 5},
+		{Path: "var/fifo", Type: TypeFIFO, Mode: 0o600},
+	}
+	var wire bytes.Buffer
+	if err := WriteList(&wire, list); err != nil {
+		t.Fatalf("encode: %v", err)
+	}
+	got, err := ReadList(bytes.NewReader(wire.Bytes()))
+	if err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if !reflect.DeepEqual(got, list) {
+		t.Fatalf("round trip:\n got %+v\nwant %+v", got, list)
+	}
+
+	// Prefix compression: a deep directory of siblings costs little more
+	// than the differing name tails.
+	var deep []Entry
+	for i := 0; i < 100; i++ {
+		deep = append(deep, Entry{Path: "very/long/common/directory/prefix/file" + string(rune('a'+i%26)) + string(rune('a'+i/26)), Type: TypeFile, Mode: 0o644})
+	}
+	wire.Reset()
+	if err := WriteList(&wire, deep); err != nil {
+		t.Fatal(err)
+	}
+	if per := wire.Len() / len(deep); per > 12 {
+		t.Fatalf("%d bytes per entry", per)
+	}
+
+	for _, bad := range []string{"../etc/passwd", "/abs", "a/../../b", "a//b", ""} {
+		if err := WriteList(io.Discard, []Entry{{Path: bad, Type: TypeFile}}); err == nil {
+			t.Fatalf("wrote unsafe path %q", bad)
+		}
+	}
+	// A hand-crafted record whose suffix climbs out of the root.
+	evil := []byte{0x46, 0x4c, 0, byte(TypeFile), 0, 5, '.', '.', '/', 'x', 'y', 0, 0, 0, 0, 0, flagEnd}
+	if _, err := ReadList(bytes.NewReader(evil)); err == nil {
+		t.Fatalf("read unsafe path")
+	}
+	// A size past MaxInt64 and a flag bit this version does not define.
+	huge := []byte{0x46, 0x4c, 0, byte(TypeFile), 0, 1, 'a', 0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x01, 0, 0, 0, 0, flagEnd}
+	if _, err := ReadList(bytes.NewReader(huge)); err == nil {
+		t.Fatalf("read size above MaxInt64")
+	}
+	unknown := []byte{0x46, 0x4c, 1 << 5, byte(TypeFile), 0, 1, 'a', 0, 0, 0, 0, 0, flagEnd}
+	if _, err := ReadList(bytes.NewReader(unknown)); err == nil {
+		t.Fatalf("read record with unknown flags")
+	}
+	unknown[2] = 0
+	if _, err := ReadList(bytes.NewReader(unknown)); err != nil {
+		t.Fatalf("control record: %v", err)
+	}
+	if _, err := ReadList(bytes.NewReader(wire.Bytes()[:wire.Len()-1])); !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Fatalf("truncated list: %v", err)
+	}
+}
+
+func TestCompare(t *testing.T) {
+	src, dest := t.TempDir(), t.TempDir()
+	t0 := time.Unix(1_700_000_000, 5)
+	t1 := t0.Add(time.Second)
+	writeFile(t, filepath.Join(src, "same"), "unchanged", t0)
+	writeFile(t, filepath.Join(dest, "same"), "unchanged", t0)
+	writeFile(t, filepath.Join(src, "touched"), "contents", t1)
+	writeFile(t, filepath.Join(dest, "touched"), "contents", t0)
+	writeFile(t, filepath.Join(src, "sneaky"), "AAAA", t0)
+	writeFile(t, filepath.Join(dest, "sneaky"), "BBBB", t0)
+	writeFile(t, filepath.Join(src, "grown"), "longer now", t0)
+	writeFile(t, filepath.Join(dest, "grown"), "short", t0)
+	writeFile(t, filepath.Join(src, "new", "file"), "x", t0)
+	writeFile(t, filepath.Join(dest, "old", "stale"), "y", t0)
+	if err := os.Symlink("same", filepath.Join(src, "link")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("other", filepath.Join(dest, "link")); err != nil {
+		t.Fatal(err)
+	}
+
+	list, err := Scan(src, ScanOptions{Checksum: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	actions := func(opts CompareOptions) map[string]Action {
+		t.Helper()
+		ds, err := Compare(list, dest, opts)
+		if err != nil {
+			t.Fatalf("compare: %v", err)
+		}
+		m := map[string]Action{}
+		for _, d := range ds {
+			m[d.Entry.Path] = d.Action
+		}
+		return m
+	}
+
+	quick := actions(CompareOptions{})
+	want := map[string]Action{
+		".": ActSkip, "same": ActSkip, "touched": ActDelta, "sneaky": ActSkip,
+		"grown": ActDelta, "new": ActFull, "new/file": ActFull, "link": ActFull,
+	}
+	if !reflect.DeepEqual(quick, want) {
+		t.Fatalf("quick check:\n got %v\nwant %v", quick, want)
+	}
+
+	cfg, err := cli.ParseArgs([]string{"-checksum", "-delete", src, dest})
+	if err != nil {
+		t.Fatal(err)
+	}
+	strong := actions(CompareOptionsFor(cfg))
+	want["touched"] = ActSkip // same bytes, different mtime
+	want["sneaky"] = ActDelta // same size and mtime, different bytes
+	want["old"] = ActDelete
+	want["old/stale"] = ActDelete
+	if !reflect.DeepEqual(strong, want) {
+		t.Fatalf("checksum compare:\n got %v\nwant %v", strong, want)
+	}
+	ds, _ := Compare(list, dest, CompareOptions{Delete: true})
+	if n := len(ds); ds[n-2].Entry.Path != "old/stale" || ds[n-1].Entry.Path != "old" {
+		t.Fatalf("deletions not deepest first: %v %v", ds[n-2].Entry.Path, ds[n-1].Entry.Path)
+	}
+
+	plain, _ := Scan(src, ScanOptions{})
+	if _, err := Compare(plain, dest, CompareOptions{Checksum: true}); err == nil {
+		t.Fatalf("checksum compare without sums accepted")
+	}
+	all, err := Compare(list, filepath.Join(dest, "missing"), CompareOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, d := range all {
+		if d.Action != ActFull {
+			t.Fatalf("%s into empty destination: %v", d.Entry.Path, d.Action)
+		}
+	}
+}
+
+func TestScanLinkPolicies(t *testing.T) {
+	root := t.TempDir()
+	mtime := time.Unix(1_700_000_000, 0)
+	writeFile(t, filepath.Join(root, "real", "f"), "data", mtime)
+	for name, target := range map[string]string{
+		"ln":  "real",
+		"fl":  "real/f",
+		"abs": "/etc/passwd",
+		"out": "../outside",
+		"sub": "real/../real/f",
+	} {
+		if err := os.Symlink(target, filepath.Join(root, name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	safe, err := Scan(root, ScanOptions{SafeLinks: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := byPath(safe)
+	for _, p := range []string{"ln", "fl", "sub"} {
+		if m[p].Type != TypeSymlink {
+			t.Fatalf("safe link %s dropped", p)
+		}
+	}
+	if _, ok := m["abs"]; ok {
+		t.Fatalf("absolute link kept")
+	}
+	if _, ok := m["out"]; ok {
+		t.Fatalf("escaping link kept")
+	}
+	if !SafeLink("a/b/l", "../c") || SafeLink("a/l", "../../c") || SafeLink("l", "/") {
+		t.Fatalf("SafeLink")
+	}
+
+	os.Remove(filepath.Join(root, "abs"))
+	os.Remove(filepath.Join(root, "out"))
+	copied, err := Scan(root, ScanOptions{CopyLinks: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	m = byPath(copied)
+	if m["ln"].Type != TypeDir || m["ln/f"].Type != TypeFile || m["fl"].Type != TypeFile || m["fl"].Size != 4 {
+		t.Fatalf("copy-links: %+v", copied)
+	}
+
+	if err := os.Symlink("..", filepath.Join(root, "real", "up")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Scan(root, ScanOptions{CopyLinks: true}); err == nil {
+		t.Fatalf("symlink loop not detected")
+	}
+	os.Remove(filepath.Join(root, "real", "up"))
+	if err := os.Symlink("missing", filepath.Join(root, "dangling")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Scan(root, ScanOptions{CopyLinks: true}); err == nil {
+		t.Fatalf("dangling link followed")
+	}
+}
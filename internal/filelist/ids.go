@@ -0,0 +1,179 @@
+package filelist
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+	"os/user"
+	"sort"
+	"strconv"
+)
+
+// IDMap carries the sender's user and group names for the ids in a file
+// list, so the receiver can map ownership by name like rsync does. Id 0 is
+// never mapped: root stays root on both ends.
+type IDMap struct {
+	Users  map[uint32]string
+	Groups map[uint32]string
+}
+
+// LookupIDs resolves the names of every uid and gid used in list,
+// including those named by ACL entries. Ids without a local name are left
+// out and travel numerically.
+func LookupIDs(list []Entry) IDMap {
+	m := IDMap{Users: map[uint32]string{}, Groups: map[uint32]string{}}
+	tried := map[[2]uint32]bool{}
+	addUser := func(id uint32) {
+		if id != 0 && !tried[[2]uint32{0, id}] {
+			tried[[2]uint32{0, id}] = true
+			if u, err := user.LookupId(strconv.FormatUint(uint64(id), 10)); err == nil {
+				m.Users[id] = u.Username
+			}
+		}
+	}
+	addGroup := func(id uint32) {
+		if id != 0 && !tried[[2]uint32{1, id}] {
+			tried[[2]uint32{1, id}] = true
+			if g, err := user.LookupGroupId(strconv.FormatUint(uint64(id), 10)); err == nil {
+				m.Groups[id] = g.Name
+			}
+		}
+	}
+	for _, e := range list {
+		addUser(e.UID)
+		addGroup(e.GID)
+		for _, acl := range []ACL{e.ACL, e.DefaultACL} {
+			for _, ent := range acl {
+				switch ent.Tag {
+				case ACLUser:
+					addUser(ent.ID)
+				case ACLGroup:
+					addGroup(ent.ID)
+				}
+			}
+		}
+	}
+	return m
+}
+
+// Wire format: magic u16, then the users and the groups, each as
+// count uvarint followed by (id uvarint | name len uvarint | name) in id
+// order.
+const (
+	idMapMagic = 0x4944 // "ID"
+	maxIDName  = 255
+)
+
+func (m IDMap) WriteTo(w io.Writer) (int64, error) {
+	b := binary.BigEndian.AppendUint16(nil, idMapMagic)
+	for _, names := range []map[uint32]string{m.Users, m.Groups} {
+		ids := make([]uint32, 0, len(names))
+		for id := range names {
+			ids = append(ids, id)
+		}
+		sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+		b = binary.AppendUvarint(b, uint64(len(ids)))
+		for _, id := range ids {
+			name := names[id]
+			if name == "" || len(name) > maxIDName {
+				return 0, errors.New("bad user or group name: " + strconv.Quote(name))
+			}
+			b = binary.AppendUvarint(b, uint64(id))
+			b = binary.AppendUvarint(b, uint64(len(name)))
+			b = append(b, name...)
+		}
+	}
+	n, err := w.Write(b)
+	return int64(n), err
+}
+
+// ReadIDMap decodes an IDMap written by WriteTo.
+func ReadIDMap(r io.Reader) (IDMap, error) {
+	br := bufio.NewReader(r)
+	var magic [2]byte
+	if _, err := io.ReadFull(br, magic[:]); err != nil {
+		return IDMap{}, unexpected(err)
+	}
+	if binary.BigEndian.Uint16(magic[:]) != idMapMagic {
+		return IDMap{}, errors.New("not an id map stream")
+	}
+	m := IDMap{Users: map[uint32]string{}, Groups: map[uint32]string{}}
+	for _, names := range []map[uint32]string{m.Users, m.Groups} {
+		count, err := binary.ReadUvarint(br)
+		if err != nil {
+			return IDMap{}, unexpected(err)
+		}
+		for ; count > 0; count-- {
+			id, err := binary.ReadUvarint(br)
+			if err != nil {
+				return IDMap{}, unexpected(err)
+			}
+			n, err := binary.ReadUvarint(br)
+			if err != nil {
+				return IDMap{}, unexpected(err)
+			}
+			if id > 0xffffffff || n == 0 || n > maxIDName {
+				return IDMap{}, errors.New("bad entry in id map")
+			}
+			name := make([]byte, n)
+			if _, err := io.ReadFull(br, name); err != nil {
+				return IDMap{}, unexpected(err)
+			}
+			names[uint32(id)] = string(name)
+		}
+	}
+	return m, nil
+}
+
+// idMapper translates the sender's ids to the receiver's: by name when the
+// sender supplied one that exists locally, numerically otherwise.
+type idMapper struct {
+	names   IDMap
+	numeric bool
+	users   map[uint32]uint32
+	groups  map[uint32]uint32
+}
+
+func newIDMapper(names IDMap, numeric bool) *idMapper {
+	return &idMapper{names: names, numeric: numeric, users: map[uint32]uint32{}, groups: map[uint32]uint32{}}
+}
+
+func (m *idMapper) uid(id uint32) uint32 {
+	return m.lookup(id, m.names.Users, m.users, func(name string) (string, error) {
+		u, err := user.Lookup(name)
+		if err != nil {
+			return "", err
+		}
+		return u.Uid, nil
+	})
+}
+
+func (m *idMapper) gid(id uint32) uint32 {
+	return m.lookup(id, m.names.Groups, m.groups, func(name string) (string, error) {
+		g, err := user.LookupGroup(name)
+		if err != nil {
+			return "", err
+		}
+		return g.Gid, nil
+	})
+}
+
+func (m *idMapper) lookup(id uint32, names map[uint32]string, cache map[uint32]uint32, find func(string) (string, error)) uint32 {
+	if m.numeric || id == 0 {
+		return id
+	}
+	if local, ok := cache[id]; ok {
+		return local
+	}
+	local := id
+	if name, ok := names[id]; ok {
+		if s, err := find(name); err == nil {
+			if v, err := strconv.ParseUint(s, 10, 32); err == nil {
+				local = uint32(v)
+			}
+		}
+	}
+	cache[id] = local
+	return local
+}
@@ -0,0 +1,304 @@
+package filelist
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+)
+
+// MetaOptions selects what the receiver reproduces besides file content,
+// mirroring rsync's -p, -o, -g, -t, -l, --devices and --specials.
+type MetaOptions struct {
+	Perms bool
+	// Owner only takes effect when running as root, as in rsync.
+	Owner bool
+	Group bool
+	// Times sets mtimes to the nanosecond; access times are left alone.
+	Times bool
+	Links bool
+	// Devices creates character and block devices; it needs root.
+	Devices bool
+	// Specials creates FIFOs and sockets.
+	Specials bool
+	// SafeLinks refuses symlinks that would lead out of the root.
+	SafeLinks bool
+	// NumericIDs ignores IDs and keeps the sender's numbers.
+	NumericIDs bool
+	// XAttrs and ACLs make the destination's extended attributes and
+	// POSIX ACLs match the source's; see BeforeRename.
+	XAttrs bool
+	ACLs   bool
+	// IDs holds the sender's user and group names (see LookupIDs).
+	IDs IDMap
+}
+
+// Applier creates the directories, links and special files of a transfer
+// under Root and brings every entry's metadata in line with the source.
+//
+// Decisions are applied in file-list order, parents before children. For
+// regular files the caller writes content first (Prepare clears a path
+// occupied by another type) and Apply then sets the attributes. Writing
+// into a directory changes its mtime and a read-only directory cannot be
+// written at all, so directory permissions and times are held back until
+// Finish.
+type Applier struct {
+	Root string
+	Opts MetaOptions
+	// Skipped collects entries that were not created: symlinks without
+	// Links or failing SafeLinks, devices without Devices or root, and
+	// FIFOs and sockets without Specials.
+	Skipped []Entry
+
+	ids  *idMapper
+	root bool
+	dirs []Entry
+}
+
+func NewApplier(root string, opts MetaOptions) *Applier {
+	return &Applier{
+		Root: root,
+		Opts: opts,
+		ids:  newIDMapper(opts.IDs, opts.NumericIDs),
+		root: os.Geteuid() == 0,
+	}
+}
+
+func (a *Applier) path(rel string) string {
+	return filepath.Join(a.Root, filepath.FromSlash(rel))
+}
+
+// checkPath refuses rel if a directory between the root and rel is a
+// symlink, as rsync does. A hostile file list can create "x -> /etc" and
+// then name "x/cron.d/evil"; without this check every later operation
+// would land outside the root. With final set, rel itself must not be a
+// symlink either, since chmod, chown and content writes follow it.
+func (a *Applier) checkPath(rel string, final bool) error {
+	if rel == "." {
+		return nil
+	}
+	parts := strings.Split(rel, "/")
+	if !final {
+		parts = parts[:len(parts)-1]
+	}
+	p := a.Root
+	for _, part := range parts {
+		p = filepath.Join(p, part)
+		fi, err := os.Lstat(p)
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if fi.Mode()&fs.ModeSymlink != 0 {
+			return fmt.Errorf("%s: refusing to follow symlink %s", rel, p)
+		}
+	}
+	return nil
+}
+
+// Prepare checks that the path of d stays inside the root and, for
+// ActFull, removes whatever occupies it unless it is a file or directory
+// of the right type, so that new content or a new node can take its
+// place. Callers must run it before writing a regular file's content;
+// Apply runs it again itself.
+func (a *Applier) Prepare(d Decision) error {
+	// A symlink at the path itself is only fine when it is about to be
+	// replaced or when the entry is a symlink too.
+	replaced := d.Action == ActFull || d.Action == ActDelete || d.Action == ActLink
+	final := !replaced && d.Entry.Type != TypeSymlink
+	if err := a.checkPath(d.Entry.Path, final); err != nil {
+		return err
+	}
+	if d.Action == ActLink {
+		return a.checkPath(d.LinkTo, true)
+	}
+	if d.Action != ActFull || d.Entry.Path == "." {
+		return nil
+	}
+	p := a.path(d.Entry.Path)
+	fi, err := os.Lstat(p)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	// Files and directories of the right type stay: a file is replaced
+	// by the content write's rename, and Apply calls Prepare again after
+	// that write.
+	if d.Entry.Type == TypeDir && fi.IsDir() || d.Entry.Type == TypeFile && fi.Mode().IsRegular() {
+		return nil
+	}
+	return os.RemoveAll(p)
+}
+
+// Apply carries out one decision other than writing file content.
+func (a *Applier) Apply(d Decision) error {
+	e := d.Entry
+	p := a.path(e.Path)
+	if err := a.Prepare(d); err != nil {
+		return err
+	}
+	switch d.Action {
+	case ActDelete:
+		// Deletions arrive deepest first, so directories are empty.
+		return os.Remove(p)
+	case ActLink:
+		if err := os.Remove(p); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+		return os.Link(a.path(d.LinkTo), p)
+	}
+	if !a.wanted(e) {
+		a.Skipped = append(a.Skipped, e)
+		return nil
+	}
+	if d.Action == ActFull && e.Type != TypeFile {
+		if err := a.create(p, e); err != nil {
+			return err
+		}
+	}
+	written := e.Type == TypeFile && (d.Action == ActFull || d.Action == ActDelta)
+	return a.attrs(p, e, written)
+}
+
+func (a *Applier) wanted(e Entry) bool {
+	switch e.Type {
+	case TypeSymlink:
+		return a.Opts.Links && (!a.Opts.SafeLinks || SafeLink(e.Path, e.Target))
+	case TypeCharDev, TypeBlockDev:
+		return a.Opts.Devices && a.root
+	case TypeFIFO, TypeSocket:
+		return a.Opts.Specials
+	}
+	return true
+}
+
+func (a *Applier) create(p string, e Entry) error {
+	switch e.Type {
+	case TypeDir:
+		// Created owner-writable so the children can be written; Finish
+		// sets the real permissions.
+		err := os.Mkdir(p, 0o700|fileMode(e.Mode).Perm())
+		if errors.Is(err, fs.ErrExist) && e.Path == "." {
+			return nil
+		}
+		return err
+	case TypeSymlink:
+		return os.Symlink(e.Target, p)
+	default:
+		return mknod(p, e.Type, e.Mode&0o777, e.Rdev)
+	}
+}
+
+// owner returns the local uid and gid e should have, -1 for "leave".
+func (a *Applier) owner(e Entry) (uid, gid int) {
+	uid, gid = -1, -1
+	if a.Opts.Owner && a.root {
+		uid = int(a.ids.uid(e.UID))
+	}
+	if a.Opts.Group {
+		gid = int(a.ids.gid(e.GID))
+	}
+	return uid, gid
+}
+
+// chown changes ownership through fn unless fi already matches. Any chown
+// that changes ids drops security.capability, so it must come before the
+// xattrs and must not be repeated once they are set.
+func (a *Applier) chown(fi fs.FileInfo, uid, gid int, fn func(uid, gid int) error) error {
+	var cur Entry
+	fillSys(&cur, fi)
+	if (uid == -1 || uint32(uid) == cur.UID) && (gid == -1 || uint32(gid) == cur.GID) {
+		return nil
+	}
+	// An unprivileged receiver can only hand files to its own groups;
+	// like rsync, it keeps going with the group it has.
+	if err := fn(uid, gid); err != nil && (a.root || !errors.Is(err, syscall.EPERM)) {
+		return err
+	}
+	return nil
+}
+
+// attrs sets everything but content. Ownership and extended attributes
+// of freshly written files were already set by the BeforeRename hook.
+func (a *Applier) attrs(p string, e Entry, written bool) error {
+	if uid, gid := a.owner(e); uid != -1 || gid != -1 {
+		fi, err := os.Lstat(p)
+		if err != nil {
+			return err
+		}
+		if err := a.chown(fi, uid, gid, func(uid, gid int) error { return os.Lchown(p, uid, gid) }); err != nil {
+			return err
+		}
+	}
+	// After chown, which drops security.capability.
+	if !written && a.wantXattrs(e) {
+		if err := a.pathXattrs(p, e); err != nil {
+			return err
+		}
+	}
+	if e.Type == TypeDir {
+		if a.Opts.Perms {
+			// Keep the directory writable until Finish.
+			if err := os.Chmod(p, fileMode(e.Mode|0o700)); err != nil {
+				return err
+			}
+		}
+		if a.Opts.Perms || a.Opts.Times {
+			a.dirs = append(a.dirs, e)
+		}
+		return nil
+	}
+	// Permissions after ownership: chown clears setuid and setgid.
+	if a.Opts.Perms && e.Type != TypeSymlink {
+		if err := os.Chmod(p, fileMode(e.Mode)); err != nil {
+			return err
+		}
+	}
+	if a.Opts.Times {
+		return lchtimes(p, e.Mtime)
+	}
+	return nil
+}
+
+// Finish applies the held-back directory permissions and times, deepest
+// directories first.
+func (a *Applier) Finish() error {
+	for i := len(a.dirs) - 1; i >= 0; i-- {
+		e := a.dirs[i]
+		p := a.path(e.Path)
+		if a.Opts.Perms {
+			if err := os.Chmod(p, fileMode(e.Mode)); err != nil {
+				return err
+			}
+		}
+		if a.Opts.Times {
+			if err := lchtimes(p, e.Mtime); err != nil {
+				return err
+			}
+		}
+	}
+	a.dirs = nil
+	return nil
+}
+
+// fileMode is the inverse of unixMode.
+func fileMode(u uint32) fs.FileMode {
+	m := fs.FileMode(u & 0o777)
+	if u&0o4000 != 0 {
+		m |= fs.ModeSetuid
+	}
+	if u&0o2000 != 0 {
+		m |= fs.ModeSetgid
+	}
+	if u&0o1000 != 0 {
+		m |= fs.ModeSticky
+	}
+	return m
+}
@@ -0,0 +1,37 @@
+//go:build linux
+
+package filelist
+
+import (
+	"io/fs"
+
+	"golang.org/x/sys/unix"
+)
+
+func mknod(p string, t Type, perm uint32, rdev uint64) error {
+	var kind uint32
+	switch t {
+	case TypeCharDev:
+		kind = unix.S_IFCHR
+	case TypeBlockDev:
+		kind = unix.S_IFBLK
+	case TypeFIFO:
+		kind = unix.S_IFIFO
+	case TypeSocket:
+		kind = unix.S_IFSOCK
+	}
+	if err := unix.Mknod(p, kind|perm, int(rdev)); err != nil {
+		return &fs.PathError{Op: "mknod", Path: p, Err: err}
+	}
+	return nil
+}
+
+// lchtimes sets the mtime of p without following a final symlink and
+// leaves the access time alone.
+func lchtimes(p string, mtime int64) error {
+	ts := []unix.Timespec{{Nsec: unix.UTIME_OMIT}, unix.NsecToTimespec(mtime)}
+	if err := unix.UtimesNanoAt(unix.AT_FDCWD, p, ts, unix.AT_SYMLINK_NOFOLLOW); err != nil {
+		return &fs.PathError{Op: "utimensat", Path: p, Err: err}
+	}
+	return nil
+}
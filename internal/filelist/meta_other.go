@@ -0,0 +1,27 @@
+//go:build !linux
+
+package filelist
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"time"
+)
+
+func mknod(p string, t Type, perm uint32, rdev uint64) error {
+	return &fs.PathError{Op: "mknod", Path: p, Err: errors.ErrUnsupported}
+}
+
+// lchtimes sets the mtime of p. Symlink times are not portable and are
+// left alone.
+func lchtimes(p string, mtime int64) error {
+	fi, err := os.Lstat(p)
+	if err != nil {
+		return err
+	}
+	if fi.Mode()&fs.ModeSymlink != 0 {
+		return nil
+	}
+	return os.Chtimes(p, time.Time{}, time.Unix(0, mtime))
+}
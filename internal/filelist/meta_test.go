@@ -0,0 +1,306 @@
+//go:build linux
+
+package filelist
+
+import (
+	"bytes"
+	"os"
+	"os/user"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"riptide/internal/cli"
+	"riptide/internal/delta"
+)
+
+// syncTree runs one local transfer: scan, compare, write contents as a
+// literal-only delta through delta.ApplyDeltaFileWith, then apply metadata.
+func syncTree(t *testing.T, src, dest string, cfg cli.Config) ([]Decision, *Applier) {
+	t.Helper()
+	list, err := Scan(src, ScanOptionsFor(cfg))
+	if err != nil {
+		t.Fatalf("scan: %v", err)
+	}
+	ds, err := Compare(list, dest, CompareOptionsFor(cfg))
+	if err != nil {
+		t.Fatalf("compare: %v", err)
+	}
+	opts := MetaOptionsFor(cfg)
+	opts.IDs = LookupIDs(list)
+	a := NewApplier(dest, opts)
+	for _, d := range ds {
+		if err := a.Prepare(d); err != nil {
+			t.Fatalf("prepare %s: %v", d.Entry.Path, err)
+		}
+		if d.Entry.Type == TypeFile && (d.Action == ActFull || d.Action == ActDelta) {
+			data, err := os.ReadFile(filepath.Join(src, d.Entry.Path))
+			if err != nil {
+				t.Fatal(err)
+			}
+			var wire bytes.Buffer
+			dw := delta.NewDeltaWriter(&wire)
+			if err := dw.Literal(data); err != nil {
+				t.Fatal(err)
+			}
+			if err := dw.Close(); err != nil {
+				t.Fatal(err)
+			}
+			p := filepath.Join(dest, d.Entry.Path)
+			opts := delta.ApplyOptions{BeforeRename: a.BeforeRename(d.Entry)}
+			if err := delta.ApplyDeltaFileWith(p, delta.NewDeltaReader(&wire), delta.Strong256(data), opts); err != nil {
+				t.Fatalf("write %s: %v", d.Entry.Path, err)
+			}
+		}
+		if err := a.Apply(d); err != nil {
+			t.Fatalf("apply %s %v: %v", d.Entry.Path, d.Action, err)
+		}
+	}
+	if err := a.Finish(); err != nil {
+		t.Fatalf("finish: %v", err)
+	}
+	return ds, a
+}
+
+func TestApplyArchive(t *testing.T) {
+	root := os.Geteuid() == 0
+	src, dest := t.TempDir(), filepath.Join(t.TempDir(), "dest")
+	t0 := time.Unix(1_600_000_000, 111_222_333)
+	t1 := time.Unix(1_650_000_000, 987_654_321)
+	writeFile(t, filepath.Join(src, "x"), "setuid binary", t0)
+	writeFile(t, filepath.Join(src, "d", "ro", "f"), "inside read-only dir", t1)
+	writeFile(t, filepath.Join(src, "h1"), "linked", t0)
+	must := func(err error) {
+		t.Helper()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	must(os.Link(filepath.Join(src, "h1"), filepath.Join(src, "h2")))
+	must(os.Symlink("x", filepath.Join(src, "link")))
+	must(os.Symlink("../../etc/passwd", filepath.Join(src, "bad")))
+	must(syscall.Mkfifo(filepath.Join(src, "fifo"), 0o640))
+	if root {
+		must(syscall.Mknod(filepath.Join(src, "null"), syscall.S_IFCHR|0o666, 1<<8|3))
+		must(os.Lchown(filepath.Join(src, "x"), 4321, 8765))
+	}
+	must(os.Chmod(filepath.Join(src, "x"), os.ModeSetuid|0o755))
+	must(os.Chmod(filepath.Join(src, "d", "ro", "f"), 0o640))
+	must(os.Chmod(filepath.Join(src, "d", "ro"), 0o555))
+	must(os.Chmod(filepath.Join(src, "d"), 0o750))
+	must(lchtimes(filepath.Join(src, "link"), t1.UnixNano()))
+	must(lchtimes(filepath.Join(src, "fifo"), t1.UnixNano()))
+	must(os.Chtimes(filepath.Join(src, "d", "ro"), t0, t0))
+	must(os.Chtimes(filepath.Join(src, "d"), t1, t1))
+	t.Cleanup(func() {
+		os.Chmod(filepath.Join(src, "d", "ro"), 0o755)
+		os.Chmod(filepath.Join(dest, "d", "ro"), 0o755)
+	})
+
+	cfg, err := cli.ParseArgs([]string{"-a", "-H", "-safe-links", src, dest})
+	must(err)
+	_, a := syncTree(t, src, dest, cfg)
+	if len(a.Skipped) != 0 {
+		t.Fatalf("skipped %+v", a.Skipped)
+	}
+	// The sender already dropped "bad"; the receiver checks on its own.
+	evil := Entry{Path: "evil", Type: TypeSymlink, Target: "../../etc/passwd"}
+	must(a.Apply(Decision{Entry: evil, Action: ActFull}))
+	if len(a.Skipped) != 1 || a.Skipped[0].Path != "evil" {
+		t.Fatalf("unsafe link not skipped: %+v", a.Skipped)
+	}
+
+	want, err := Scan(src, ScanOptions{})
+	must(err)
+	got, err := Scan(dest, ScanOptions{})
+	must(err)
+	gm := byPath(got)
+	for _, s := range want {
+		if s.Path == "bad" {
+			if _, ok := gm["bad"]; ok {
+				t.Fatalf("unsafe symlink created")
+			}
+			continue
+		}
+		d, ok := gm[s.Path]
+		if !ok {
+			t.Fatalf("%s missing from destination", s.Path)
+		}
+		if d.Type != s.Type || d.Mode != s.Mode || d.Target != s.Target || d.Rdev != s.Rdev || d.Size != s.Size {
+			t.Fatalf("%s: got %+v want %+v", s.Path, d, s)
+		}
+		if d.Mtime != s.Mtime {
+			t.Fatalf("%s: mtime %d want %d", s.Path, d.Mtime, s.Mtime)
+		}
+		if root && (d.UID != s.UID || d.GID != s.GID) {
+			t.Fatalf("%s: owner %d:%d want %d:%d", s.Path, d.UID, d.GID, s.UID, s.GID)
+		}
+	}
+	if gm["h1"].Inode != gm["h2"].Inode {
+		t.Fatalf("hardlink not preserved")
+	}
+	if !root {
+		if _, ok := gm["null"]; ok {
+			t.Fatalf("device created without root")
+		}
+	}
+
+	// A second run has nothing to send and puts back metadata-only drift.
+	must(os.Chmod(filepath.Join(dest, "x"), 0o600))
+	ds, _ := syncTree(t, src, dest, cfg)
+	for _, d := range ds {
+		if d.Action != ActSkip {
+			t.Fatalf("second run: %s %v", d.Entry.Path, d.Action)
+		}
+	}
+	if fi, err := os.Stat(filepath.Join(dest, "x")); err != nil || fi.Mode() != os.ModeSetuid|0o755 {
+		t.Fatalf("mode not restored: %v %v", fi.Mode(), err)
+	}
+
+	// Rewriting the leader gives it a new inode; the follower is relinked.
+	writeFile(t, filepath.Join(src, "h1"), "changed", t1)
+	ds, _ = syncTree(t, src, dest, cfg)
+	acts := map[string]Action{}
+	for _, d := range ds {
+		acts[d.Entry.Path] = d.Action
+	}
+	if acts["h1"] != ActDelta || acts["h2"] != ActLink {
+		t.Fatalf("relink: h1 %v h2 %v", acts["h1"], acts["h2"])
+	}
+	h1, _ := os.Stat(filepath.Join(dest, "h1"))
+	h2, _ := os.Stat(filepath.Join(dest, "h2"))
+	if !os.SameFile(h1, h2) {
+		t.Fatalf("follower not relinked")
+	}
+}
+
+func TestApplyWithoutArchive(t *testing.T) {
+	src, dest := t.TempDir(), t.TempDir()
+	t0 := time.Unix(1_600_000_000, 0)
+	writeFile(t, filepath.Join(src, "f"), "plain", t0)
+	if err := os.Chmod(filepath.Join(src, "f"), 0o700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("f", filepath.Join(src, "link")); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := cli.ParseArgs([]string{src, dest})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, a := syncTree(t, src, dest, cfg)
+	if len(a.Skipped) != 1 || a.Skipped[0].Path != "link" {
+		t.Fatalf("skipped %+v", a.Skipped)
+	}
+	fi, err := os.Stat(filepath.Join(dest, "f"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Mode().Perm() == 0o700 || fi.ModTime().Equal(t0) {
+		t.Fatalf("metadata copied without -a: %v %v", fi.Mode(), fi.ModTime())
+	}
+}
+
+func TestIDMap(t *testing.T) {
+	me, err := user.Current()
+	if err != nil {
+		t.Skip(err)
+	}
+	uid, _ := strconv.ParseUint(me.Uid, 10, 32)
+	m := IDMap{
+		Users:  map[uint32]string{999_999: me.Username, 999_998: "no-such-user-riptide"},
+		Groups: map[uint32]string{7: "no-such-group-riptide"},
+	}
+	var buf bytes.Buffer
+	if _, err := m.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	got, err := ReadIDMap(bytes.NewReader(buf.Bytes()))
+	if err != nil || !reflect.DeepEqual(got, m) {
+		t.Fatalf("round trip: %+v %v", got, err)
+	}
+	if _, err := ReadIDMap(bytes.NewReader(buf.Bytes()[:buf.Len()-1])); err == nil {
+		t.Fatalf("truncated id map accepted")
+	}
+
+	byName := newIDMapper(got, false)
+	if byName.uid(999_999) != uint32(uid) {
+		t.Fatalf("name mapping: %d want %d", byName.uid(999_999), uid)
+	}
+	if byName.uid(999_998) != 999_998 || byName.gid(7) != 7 || byName.uid(0) != 0 {
+		t.Fatalf("unknown names should stay numeric")
+	}
+	if newIDMapper(got, true).uid(999_999) != 999_999 {
+		t.Fatalf("numeric ids mapped by name")
+	}
+
+	ids := LookupIDs([]Entry{{UID: uint32(uid)}, {UID: 0}})
+	if _, ok := ids.Users[0]; ok {
+		t.Fatalf("root mapped by name")
+	}
+	if uid != 0 && ids.Users[uint32(uid)] != me.Username {
+		t.Fatalf("LookupIDs: %+v", ids)
+	}
+}
+
+func TestApplyRefusesSymlinkTraversal(t *testing.T) {
+	dest, outside := t.TempDir(), t.TempDir()
+	victim := filepath.Join(outside, "victim")
+	writeFile(t, victim, "keep", time.Unix(1_600_000_000, 0))
+	if err := os.Chmod(victim, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	// A hostile sender plants links, then names paths through them.
+	list := []Entry{
+		{Path: ".", Type: TypeDir, Mode: 0o755},
+		{Path: "x", Type: TypeSymlink, Target: outside},
+		{Path: "x/evil", Type: TypeDir, Mode: 0o777},
+		{Path: "x/fifo", Type: TypeFIFO, Mode: 0o666},
+		{Path: "x/victim", Type: TypeFile, Mode: 0o777},
+		{Path: "y", Type: TypeSymlink, Target: victim},
+	}
+	opts := MetaOptions{Perms: true, Group: true, Times: true, Links: true, Specials: true}
+	a := NewApplier(dest, opts)
+	for _, e := range list {
+		if strings.HasPrefix(e.Path, "x/") {
+			continue
+		}
+		if err := a.Apply(Decision{Entry: e, Action: ActFull}); err != nil {
+			t.Fatalf("%s: %v", e.Path, err)
+		}
+	}
+	for _, d := range []Decision{
+		{Entry: list[2], Action: ActFull},
+		{Entry: list[3], Action: ActFull},
+		{Entry: list[4], Action: ActDelta},
+		{Entry: list[4], Action: ActSkip},
+		{Entry: Entry{Path: "x/victim", Type: TypeFile}, Action: ActDelete},
+		{Entry: Entry{Path: "h", Type: TypeFile}, Action: ActLink, LinkTo: "x/victim"},
+		// "y" is then re-sent as a regular file whose metadata the receiver
+		// believes to be current; chmod must not follow the link.
+		{Entry: Entry{Path: "y", Type: TypeFile, Mode: 0o777}, Action: ActSkip},
+	} {
+		if err := a.Prepare(d); err == nil {
+			t.Errorf("prepare %s %v: accepted", d.Entry.Path, d.Action)
+		}
+		if err := a.Apply(d); err == nil {
+			t.Errorf("apply %s %v: accepted", d.Entry.Path, d.Action)
+		}
+	}
+
+	ents, err := os.ReadDir(outside)
+	if err != nil || len(ents) != 1 {
+		t.Fatalf("outside directory touched: %v %v", ents, err)
+	}
+	if fi, err := os.Stat(victim); err != nil || fi.Mode().Perm() != 0o600 {
+		t.Fatalf("victim changed: %v %v", fi.Mode(), err)
+	}
+	if _, err := os.Lstat(filepath.Join(dest, "h")); err == nil {
+		t.Fatalf("hardlink through symlink created")
+	}
+}
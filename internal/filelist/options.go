@@ -0,0 +1,38 @@
+package filelist
+
+import "riptide/internal/cli"
+
+// ScanOptionsFor maps the command line onto the sender's scan options.
+func ScanOptionsFor(cfg cli.Config) ScanOptions {
+	return ScanOptions{
+		Checksum:  cfg.Checksum,
+		CopyLinks: cfg.CopyLinks,
+		SafeLinks: cfg.SafeLinks,
+		XAttrs:    cfg.XAttrs,
+		ACLs:      cfg.ACLs,
+	}
+}
+
+// CompareOptionsFor maps the command line onto compare options.
+func CompareOptionsFor(cfg cli.Config) CompareOptions {
+	return CompareOptions{Checksum: cfg.Checksum, Delete: cfg.Delete, HardLinks: cfg.HardLinks}
+}
+
+// MetaOptionsFor maps the command line onto the receiver's metadata
+// options. -archive turns on everything rsync's -a does (-rlptgoD).
+func MetaOptionsFor(cfg cli.Config) MetaOptions {
+	a := cfg.Archive
+	return MetaOptions{
+		Perms:      a,
+		Owner:      a,
+		Group:      a,
+		Times:      a,
+		Links:      a,
+		Devices:    a,
+		Specials:   a,
+		SafeLinks:  cfg.SafeLinks,
+		NumericIDs: cfg.NumericIDs,
+		XAttrs:     cfg.XAttrs,
+		ACLs:       cfg.ACLs,
+	}
+}
@@ -0,0 +1,7 @@
+//go:build !unix
+
+package filelist
+
+import "io/fs"
+
+func fillSys(e *Entry, fi fs.FileInfo) {}
@@ -0,0 +1,23 @@
+//go:build unix
+
+package filelist
+
+import (
+	"io/fs"
+	"syscall"
+)
+
+func fillSys(e *Entry, fi fs.FileInfo) {
+	st, ok := fi.Sys().(*syscall.Stat_t)
+	if !ok {
+		return
+	}
+	e.UID = st.Uid
+	e.GID = st.Gid
+	e.Dev = uint64(st.Dev)
+	e.Inode = uint64(st.Ino)
+	e.Nlink = uint64(st.Nlink)
+	if e.Type == TypeCharDev || e.Type == TypeBlockDev {
+		e.Rdev = uint64(st.Rdev)
+	}
+}
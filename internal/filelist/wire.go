@@ -0,0 +1,314 @@
+package filelist
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+)
+
+// Wire format: magic u16, then one record per entry and a final flags byte
+// of flagEnd. Each record is
+//
+//	flags u8 | type u8 | prefix uvarint | suffix len uvarint | suffix
+//	size uvarint | mtime varint | [mode uvarint] | [uid uvarint] | [gid uvarint]
+//	[target len uvarint | target] | [rdev uvarint] | [dev, inode, nlink uvarint] | [sum 32]
+//
+// prefix is the number of leading path bytes shared with the previous
+// entry, so the directory part of sibling paths is sent once. Mode, uid and
+// gid are omitted when equal to the previous entry's; the bracketed tail
+// fields only appear for the types (or flags) that need them.
+const (
+	listMagic = 0x464c // "FL"
+
+	flagSameMode = 1 << 0
+	flagSameUID  = 1 << 1
+	flagSameGID  = 1 << 2
+	flagLinked   = 1 << 3
+	flagSum      = 1 << 4
+	flagEnd      = 1 << 7
+
+	// flagsKnown is every record flag this version understands.
+	flagsKnown = flagSameMode | flagSameUID | flagSameGID | flagLinked | flagSum
+
+	maxPathLen = 4096
+)
+
+// Writer streams entries in the wire format.
+type Writer struct {
+	w       *bufio.Writer
+	prev    Entry
+	started bool
+	err     error
+}
+
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{w: bufio.NewWriter(w)}
+}
+
+func (w *Writer) Write(e Entry) error {
+	if w.err != nil {
+		return w.err
+	}
+	if err := validPath(e.Path); err != nil {
+		return err
+	}
+	var b []byte
+	if !w.started {
+		b = binary.BigEndian.AppendUint16(b, listMagic)
+		w.started = true
+	}
+	var flags byte
+	if e.Mode == w.prev.Mode {
+		flags |= flagSameMode
+	}
+	if e.UID == w.prev.UID {
+		flags |= flagSameUID
+	}
+	if e.GID == w.prev.GID {
+		flags |= flagSameGID
+	}
+	if e.Linked() {
+		flags |= flagLinked
+	}
+	if e.HasSum() {
+		flags |= flagSum
+	}
+	prefix := commonPrefix(w.prev.Path, e.Path)
+	b = append(b, flags, byte(e.Type))
+	b = binary.AppendUvarint(b, uint64(prefix))
+	b = binary.AppendUvarint(b, uint64(len(e.Path)-prefix))
+	b = append(b, e.Path[prefix:]...)
+	b = binary.AppendUvarint(b, uint64(e.Size))
+	b = binary.AppendVarint(b, e.Mtime)
+	if flags&flagSameMode == 0 {
+		b = binary.AppendUvarint(b, uint64(e.Mode))
+	}
+	if flags&flagSameUID == 0 {
+		b = binary.AppendUvarint(b, uint64(e.UID))
+	}
+	if flags&flagSameGID == 0 {
+		b = binary.AppendUvarint(b, uint64(e.GID))
+	}
+	switch e.Type {
+	case TypeSymlink:
+		b = binary.AppendUvarint(b, uint64(len(e.Target)))
+		b = append(b, e.Target...)
+	case TypeCharDev, TypeBlockDev:
+		b = binary.AppendUvarint(b, e.Rdev)
+	}
+	if flags&flagLinked != 0 {
+		b = binary.AppendUvarint(b, e.Dev)
+		b = binary.AppendUvarint(b, e.Inode)
+		b = binary.AppendUvarint(b, e.Nlink)
+	}
+	if flags&flagSum != 0 {
+		b = append(b, e.Sum[:]...)
+	}
+	_, w.err = w.w.Write(b)
+	w.prev = e
+	return w.err
+}
+
+// Close writes the end marker and flushes.
+func (w *Writer) Close() error {
+	if w.err != nil {
+		return w.err
+	}
+	if !w.started {
+		w.w.Write(binary.BigEndian.AppendUint16(nil, listMagic))
+	}
+	w.w.WriteByte(flagEnd)
+	return w.w.Flush()
+}
+
+func commonPrefix(a, b string) int {
+	n := min(len(a), len(b))
+	i := 0
+	for i < n && a[i] == b[i] {
+		i++
+	}
+	return i
+}
+
+// Reader decodes a file list one entry at a time.
+type Reader struct {
+	r       *bufio.Reader
+	prev    Entry
+	started bool
+	done    bool
+}
+
+func NewReader(r io.Reader) *Reader {
+	return &Reader{r: bufio.NewReader(r)}
+}
+
+// Next returns the next entry, or io.EOF after the end marker. Paths that
+// could escape the destination are rejected.
+func (r *Reader) Next() (Entry, error) {
+	if r.done {
+		return Entry{}, io.EOF
+	}
+	if !r.started {
+		var m [2]byte
+		if _, err := io.ReadFull(r.r, m[:]); err != nil {
+			return Entry{}, unexpected(err)
+		}
+		if binary.BigEndian.Uint16(m[:]) != listMagic {
+			return Entry{}, errors.New("not a file list stream")
+		}
+		r.started = true
+	}
+	e, err := r.next()
+	if r.done {
+		return Entry{}, io.EOF
+	}
+	if err != nil {
+		return Entry{}, unexpected(err)
+	}
+	return e, nil
+}
+
+func (r *Reader) next() (Entry, error) {
+	flags, err := r.r.ReadByte()
+	if err != nil {
+		return Entry{}, err
+	}
+	if flags == flagEnd {
+		r.done = true
+		return Entry{}, io.EOF
+	}
+	if flags&^flagsKnown != 0 {
+		return Entry{}, errors.New("unknown flags in file list")
+	}
+	typ, err := r.r.ReadByte()
+	if err != nil {
+		return Entry{}, err
+	}
+	e := Entry{Type: Type(typ)}
+	if e.Type < TypeFile || e.Type > TypeSocket {
+		return Entry{}, errors.New("unknown entry type in file list")
+	}
+	prefix, err := r.uvarint()
+	if err != nil {
+		return Entry{}, err
+	}
+	suffix, err := r.uvarint()
+	if err != nil {
+		return Entry{}, err
+	}
+	if prefix > uint64(len(r.prev.Path)) || prefix+suffix > maxPathLen {
+		return Entry{}, errors.New("bad path length in file list")
+	}
+	if e.Path, err = r.str(r.prev.Path[:prefix], suffix); err != nil {
+		return Entry{}, err
+	}
+	if err := validPath(e.Path); err != nil {
+		return Entry{}, err
+	}
+	size, err := r.uvarint()
+	if err != nil {
+		return Entry{}, err
+	}
+	if size > math.MaxInt64 {
+		return Entry{}, errors.New("bad size in file list")
+	}
+	e.Size = int64(size)
+	if e.Mtime, err = binary.ReadVarint(r.r); err != nil {
+		return Entry{}, err
+	}
+	e.Mode, e.UID, e.GID = r.prev.Mode, r.prev.UID, r.prev.GID
+	for _, f := range []struct {
+		flag byte
+		dst  *uint32
+	}{{flagSameMode, &e.Mode}, {flagSameUID, &e.UID}, {flagSameGID, &e.GID}} {
+		if flags&f.flag != 0 {
+			continue
+		}
+		v, err := r.uvarint()
+		if err != nil {
+			return Entry{}, err
+		}
+		*f.dst = uint32(v)
+	}
+	switch e.Type {
+	case TypeSymlink:
+		n, err := r.uvarint()
+		if err != nil {
+			return Entry{}, err
+		}
+		if n > maxPathLen {
+			return Entry{}, errors.New("bad symlink target length in file list")
+		}
+		if e.Target, err = r.str("", n); err != nil {
+			return Entry{}, err
+		}
+	case TypeCharDev, TypeBlockDev:
+		if e.Rdev, err = r.uvarint(); err != nil {
+			return Entry{}, err
+		}
+	}
+	if flags&flagLinked != 0 {
+		for _, dst := range []*uint64{&e.Dev, &e.Inode, &e.Nlink} {
+			if *dst, err = r.uvarint(); err != nil {
+				return Entry{}, err
+			}
+		}
+	}
+	if flags&flagSum != 0 {
+		if _, err := io.ReadFull(r.r, e.Sum[:]); err != nil {
+			return Entry{}, err
+		}
+	}
+	r.prev = e
+	return e, nil
+}
+
+func (r *Reader) uvarint() (uint64, error) {
+	return binary.ReadUvarint(r.r)
+}
+
+func (r *Reader) str(prefix string, n uint64) (string, error) {
+	b := make([]byte, len(prefix)+int(n))
+	copy(b, prefix)
+	if _, err := io.ReadFull(r.r, b[len(prefix):]); err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+// unexpected converts io.EOF before the end marker.
+func unexpected(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// WriteList encodes a whole list.
+func WriteList(w io.Writer, list []Entry) error {
+	lw := NewWriter(w)
+	for _, e := range list {
+		if err := lw.Write(e); err != nil {
+			return err
+		}
+	}
+	return lw.Close()
+}
+
+// ReadList decodes a whole list.
+func ReadList(r io.Reader) ([]Entry, error) {
+	lr := NewReader(r)
+	var out []Entry
+	for {
+		e, err := lr.Next()
+		if err == io.EOF {
+			return out, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, e)
+	}
+}
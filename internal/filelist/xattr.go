@@ -0,0 +1,344 @@
+package filelist
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// XAttr is one extended attribute.
+type XAttr struct {
+	Name  string
+	Value []byte
+}
+
+// ACL is a POSIX access or default ACL. Entries use the Linux tag values;
+// ID is only meaningful for ACLUser and ACLGroup.
+type ACL []ACLEntry
+
+type ACLEntry struct {
+	Tag  uint16
+	Perm uint16
+	ID   uint32
+}
+
+const (
+	ACLUserObj  = 0x01
+	ACLUser     = 0x02
+	ACLGroupObj = 0x04
+	ACLGroup    = 0x08
+	ACLMask     = 0x10
+	ACLOther    = 0x20
+)
+
+var (
+	ErrXattrUnsupported = errors.New("filesystem does not support extended attributes")
+	ErrACLUnsupported   = errors.New("filesystem does not support POSIX ACLs")
+)
+
+// transferXattr reports whether an attribute is carried by -X: the user
+// namespace always, trusted and security only between root processes.
+// system.* holds ACLs, which belong to -A.
+func transferXattr(name string, root bool) bool {
+	if strings.HasPrefix(name, "user.") {
+		return true
+	}
+	return root && (strings.HasPrefix(name, "trusted.") || strings.HasPrefix(name, "security."))
+}
+
+func (a *Applier) wantXattrs(e Entry) bool {
+	return a.Opts.XAttrs || a.Opts.ACLs && e.Type != TypeSymlink
+}
+
+// BeforeRename returns the delta.ApplyOptions hook that puts e's owner,
+// extended attributes and ACLs on the temp file of a regular file
+// transfer, so they appear together with the new content. The chown comes
+// first because it would clear security.capability; Apply then finds the
+// owner already right and leaves it, and the xattrs, alone. Callers must
+// install this hook for regular files written by ActFull and ActDelta.
+func (a *Applier) BeforeRename(e Entry) func(tmp *os.File) error {
+	return func(tmp *os.File) error {
+		if uid, gid := a.owner(e); uid != -1 || gid != -1 {
+			fi, err := tmp.Stat()
+			if err != nil {
+				return err
+			}
+			if err := a.chown(fi, uid, gid, tmp.Chown); err != nil {
+				return err
+			}
+		}
+		if !a.wantXattrs(e) {
+			return nil
+		}
+		return a.fileXattrs(tmp, e)
+	}
+}
+
+// xattrTarget is a file whose attributes are being set, by path or by
+// descriptor. set and remove report ENOTSUP as the given sentinel.
+type xattrTarget interface {
+	list() ([]string, error)
+	set(name string, value []byte, unsupported error) error
+	remove(name string, unsupported error) error
+}
+
+// applyXattrs makes t's attributes match e's in the namespaces this
+// receiver manages, removing extras, then sets or clears the ACLs.
+func (a *Applier) applyXattrs(t xattrTarget, e Entry) error {
+	if a.Opts.XAttrs {
+		have, err := t.list()
+		if err != nil && !(errors.Is(err, ErrXattrUnsupported) && len(e.XAttrs) == 0) {
+			return err
+		}
+		keep := map[string]bool{}
+		for _, x := range e.XAttrs {
+			if !transferXattr(x.Name, a.root) {
+				continue
+			}
+			keep[x.Name] = true
+			if err := t.set(x.Name, x.Value, ErrXattrUnsupported); err != nil {
+				return err
+			}
+		}
+		for _, name := range have {
+			if transferXattr(name, a.root) && !keep[name] {
+				if err := t.remove(name, ErrXattrUnsupported); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	if a.Opts.ACLs && e.Type != TypeSymlink {
+		if err := a.setACL(t, aclAccess, e.ACL); err != nil {
+			return err
+		}
+		if e.Type == TypeDir {
+			if err := a.setACL(t, aclDefault, e.DefaultACL); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (a *Applier) setACL(t xattrTarget, name string, acl ACL) error {
+	if len(acl) == 0 {
+		// A file without an extended ACL needs nothing, even on a
+		// filesystem that could not store one.
+		err := t.remove(name, ErrACLUnsupported)
+		if errors.Is(err, ErrACLUnsupported) {
+			return nil
+		}
+		return err
+	}
+	mapped := make(ACL, len(acl))
+	for i, ent := range acl {
+		switch ent.Tag {
+		case ACLUser:
+			ent.ID = a.ids.uid(ent.ID)
+		case ACLGroup:
+			ent.ID = a.ids.gid(ent.ID)
+		}
+		mapped[i] = ent
+	}
+	return t.set(name, encodeACL(mapped), ErrACLUnsupported)
+}
+
+// Linux stores ACLs as system.posix_acl_* attributes: a little-endian
+// version word followed by (tag u16, perm u16, id u32) entries.
+const (
+	aclAccess      = "system.posix_acl_access"
+	aclDefault     = "system.posix_acl_default"
+	aclVersion     = 2
+	aclUndefinedID = 0xffffffff
+)
+
+func encodeACL(acl ACL) []byte {
+	b := binary.LittleEndian.AppendUint32(nil, aclVersion)
+	for _, e := range acl {
+		id := e.ID
+		if e.Tag != ACLUser && e.Tag != ACLGroup {
+			id = aclUndefinedID
+		}
+		b = binary.LittleEndian.AppendUint16(b, e.Tag)
+		b = binary.LittleEndian.AppendUint16(b, e.Perm)
+		b = binary.LittleEndian.AppendUint32(b, id)
+	}
+	return b
+}
+
+func decodeACL(b []byte) (ACL, error) {
+	if len(b) < 4 || (len(b)-4)%8 != 0 || binary.LittleEndian.Uint32(b) != aclVersion {
+		return nil, errors.New("malformed POSIX ACL attribute")
+	}
+	var acl ACL
+	for b = b[4:]; len(b) > 0; b = b[8:] {
+		e := ACLEntry{
+			Tag:  binary.LittleEndian.Uint16(b),
+			Perm: binary.LittleEndian.Uint16(b[2:]),
+		}
+		if e.Tag == ACLUser || e.Tag == ACLGroup {
+			e.ID = binary.LittleEndian.Uint32(b[4:])
+		}
+		acl = append(acl, e)
+	}
+	return acl, nil
+}
+
+// Metadata stream: the extended attributes and ACLs of a file list travel
+// separately from the list itself, so transfers without -X/-A pay nothing.
+// Format: magic u16, then for each entry that has any, in list order,
+//
+//	index+1 uvarint | xattr count uvarint | (name len | name | value len | value)...
+//	acl count uvarint | (tag | perm | id uvarint)... | default acl (same)
+//
+// and a final 0.
+const (
+	metaMagic     = 0x4d44 // "MD"
+	maxXattrName  = 255
+	maxXattrValue = 64 << 10
+	maxXattrs     = 1024
+	maxACLEntries = 1024
+)
+
+// WriteMeta writes the metadata stream for list.
+func WriteMeta(w io.Writer, list []Entry) error {
+	bw := bufio.NewWriter(w)
+	b := binary.BigEndian.AppendUint16(nil, metaMagic)
+	for i, e := range list {
+		if len(e.XAttrs) == 0 && len(e.ACL) == 0 && len(e.DefaultACL) == 0 {
+			continue
+		}
+		if len(e.XAttrs) > maxXattrs || len(e.ACL) > maxACLEntries || len(e.DefaultACL) > maxACLEntries {
+			return fmt.Errorf("%s: too many extended attributes or ACL entries", e.Path)
+		}
+		b = binary.AppendUvarint(b, uint64(i)+1)
+		b = binary.AppendUvarint(b, uint64(len(e.XAttrs)))
+		for _, x := range e.XAttrs {
+			if x.Name == "" || len(x.Name) > maxXattrName || len(x.Value) > maxXattrValue {
+				return fmt.Errorf("%s: extended attribute %q too large", e.Path, x.Name)
+			}
+			b = binary.AppendUvarint(b, uint64(len(x.Name)))
+			b = append(b, x.Name...)
+			b = binary.AppendUvarint(b, uint64(len(x.Value)))
+			b = append(b, x.Value...)
+		}
+		for _, acl := range []ACL{e.ACL, e.DefaultACL} {
+			b = binary.AppendUvarint(b, uint64(len(acl)))
+			for _, a := range acl {
+				b = binary.AppendUvarint(b, uint64(a.Tag))
+				b = binary.AppendUvarint(b, uint64(a.Perm))
+				b = binary.AppendUvarint(b, uint64(a.ID))
+			}
+		}
+		if _, err := bw.Write(b); err != nil {
+			return err
+		}
+		b = b[:0]
+	}
+	b = append(b, 0)
+	if _, err := bw.Write(b); err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+// ReadMeta reads a metadata stream and attaches it to the entries of list,
+// which must be the list the stream was written for.
+func ReadMeta(r io.Reader, list []Entry) error {
+	br := bufio.NewReader(r)
+	var magic [2]byte
+	if _, err := io.ReadFull(br, magic[:]); err != nil {
+		return unexpected(err)
+	}
+	if binary.BigEndian.Uint16(magic[:]) != metaMagic {
+		return errors.New("not a metadata stream")
+	}
+	next := uint64(0)
+	for {
+		idx, err := binary.ReadUvarint(br)
+		if err != nil {
+			return unexpected(err)
+		}
+		if idx == 0 {
+			return nil
+		}
+		if idx <= next || idx > uint64(len(list)) {
+			return errors.New("bad entry index in metadata stream")
+		}
+		next = idx
+		if err := readEntryMeta(br, &list[idx-1]); err != nil {
+			return unexpected(err)
+		}
+	}
+}
+
+func readEntryMeta(br *bufio.Reader, e *Entry) error {
+	n, err := binary.ReadUvarint(br)
+	if err != nil {
+		return err
+	}
+	if n > maxXattrs {
+		return errors.New("too many extended attributes in metadata stream")
+	}
+	e.XAttrs = nil
+	for ; n > 0; n-- {
+		name, err := readBytes(br, maxXattrName)
+		if err != nil {
+			return err
+		}
+		value, err := readBytes(br, maxXattrValue)
+		if err != nil {
+			return err
+		}
+		if len(name) == 0 {
+			return errors.New("empty extended attribute name in metadata stream")
+		}
+		e.XAttrs = append(e.XAttrs, XAttr{Name: string(name), Value: value})
+	}
+	for _, dst := range []*ACL{&e.ACL, &e.DefaultACL} {
+		n, err := binary.ReadUvarint(br)
+		if err != nil {
+			return err
+		}
+		if n > maxACLEntries {
+			return errors.New("too many ACL entries in metadata stream")
+		}
+		*dst = nil
+		for ; n > 0; n-- {
+			var v [3]uint64
+			for i := range v {
+				if v[i], err = binary.ReadUvarint(br); err != nil {
+					return err
+				}
+			}
+			if v[0] > 0xffff || v[1] > 0xffff || v[2] > 0xffffffff {
+				return errors.New("bad ACL entry in metadata stream")
+			}
+			*dst = append(*dst, ACLEntry{Tag: uint16(v[0]), Perm: uint16(v[1]), ID: uint32(v[2])})
+		}
+	}
+	return nil
+}
+
+func readBytes(br *bufio.Reader, max uint64) ([]byte, error) {
+	n, err := binary.ReadUvarint(br)
+	if err != nil {
+		return nil, err
+	}
+	if n > max {
+		return nil, errors.New("oversized field in metadata stream")
+	}
+	if n == 0 {
+		return nil, nil
+	}
+	b := make([]byte, n)
+	if _, err := io.ReadFull(br, b); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
@@ -0,0 +1,182 @@
+//go:build linux
+
+package filelist
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+// readXattrs returns the attributes of p that -X transfers, sorted by
+// name. A source filesystem without xattr support simply has none.
+func readXattrs(p string, follow, root bool) ([]XAttr, error) {
+	t := pathTarget{p, follow}
+	names, err := t.list()
+	if errors.Is(err, ErrXattrUnsupported) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(names)
+	var out []XAttr
+	for _, name := range names {
+		if !transferXattr(name, root) {
+			continue
+		}
+		v, err := t.get(name)
+		if errors.Is(err, unix.ENODATA) {
+			continue // removed since the listing
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: reading %s: %w", p, name, err)
+		}
+		out = append(out, XAttr{Name: name, Value: v})
+	}
+	return out, nil
+}
+
+// readACLs returns p's access ACL and, for directories, its default ACL.
+// Files whose ACL is fully described by their mode bits have none.
+func readACLs(p string, follow, dir bool) (access, def ACL, err error) {
+	t := pathTarget{p, follow}
+	names := []string{aclAccess}
+	if dir {
+		names = append(names, aclDefault)
+	}
+	for i, name := range names {
+		v, err := t.get(name)
+		if errors.Is(err, unix.ENODATA) || errors.Is(err, unix.ENOTSUP) {
+			continue
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("%s: reading %s: %w", p, name, err)
+		}
+		acl, err := decodeACL(v)
+		if err != nil {
+			return nil, nil, fmt.Errorf("%s: %w", p, err)
+		}
+		if i == 0 {
+			access = acl
+		} else {
+			def = acl
+		}
+	}
+	return access, def, nil
+}
+
+// pathXattrs works on the entry itself, not on a symlink's target.
+func (a *Applier) pathXattrs(p string, e Entry) error {
+	return a.applyXattrs(pathTarget{p: p}, e)
+}
+
+func (a *Applier) fileXattrs(f *os.File, e Entry) error {
+	return a.applyXattrs(fileTarget{f}, e)
+}
+
+type pathTarget struct {
+	p      string
+	follow bool
+}
+
+func (t pathTarget) list() ([]string, error) {
+	listxattr := unix.Llistxattr
+	if t.follow {
+		listxattr = unix.Listxattr
+	}
+	b, err := grow(func(buf []byte) (int, error) { return listxattr(t.p, buf) })
+	if err != nil {
+		return nil, xattrErr("listxattr", t.p, "", err, ErrXattrUnsupported)
+	}
+	return splitNames(b), nil
+}
+
+func (t pathTarget) get(name string) ([]byte, error) {
+	getxattr := unix.Lgetxattr
+	if t.follow {
+		getxattr = unix.Getxattr
+	}
+	return grow(func(buf []byte) (int, error) { return getxattr(t.p, name, buf) })
+}
+
+func (t pathTarget) set(name string, v []byte, unsupported error) error {
+	return xattrErr("setxattr", t.p, name, unix.Lsetxattr(t.p, name, v, 0), unsupported)
+}
+
+func (t pathTarget) remove(name string, unsupported error) error {
+	err := unix.Lremovexattr(t.p, name)
+	if errors.Is(err, unix.ENODATA) {
+		return nil
+	}
+	return xattrErr("removexattr", t.p, name, err, unsupported)
+}
+
+type fileTarget struct{ f *os.File }
+
+func (t fileTarget) fd() int { return int(t.f.Fd()) }
+
+func (t fileTarget) list() ([]string, error) {
+	b, err := grow(func(buf []byte) (int, error) { return unix.Flistxattr(t.fd(), buf) })
+	if err != nil {
+		return nil, xattrErr("listxattr", t.f.Name(), "", err, ErrXattrUnsupported)
+	}
+	return splitNames(b), nil
+}
+
+func (t fileTarget) set(name string, v []byte, unsupported error) error {
+	return xattrErr("setxattr", t.f.Name(), name, unix.Fsetxattr(t.fd(), name, v, 0), unsupported)
+}
+
+func (t fileTarget) remove(name string, unsupported error) error {
+	err := unix.Fremovexattr(t.fd(), name)
+	if errors.Is(err, unix.ENODATA) {
+		return nil
+	}
+	return xattrErr("removexattr", t.f.Name(), name, err, unsupported)
+}
+
+// grow calls a size-probing xattr syscall until the buffer is big enough.
+func grow(call func([]byte) (int, error)) ([]byte, error) {
+	for {
+		n, err := call(nil)
+		if err != nil {
+			return nil, err
+		}
+		if n == 0 {
+			return nil, nil
+		}
+		buf := make([]byte, n)
+		n, err = call(buf)
+		if errors.Is(err, unix.ERANGE) {
+			continue // grew between the calls
+		}
+		if err != nil {
+			return nil, err
+		}
+		return buf[:n], nil
+	}
+}
+
+func splitNames(b []byte) []string {
+	return strings.FieldsFunc(string(b), func(r rune) bool { return r == 0 })
+}
+
+// xattrErr turns ENOTSUP into unsupported so callers can tell a
+// filesystem without xattrs or ACLs from other failures.
+func xattrErr(op, p, name string, err, unsupported error) error {
+	if err == nil {
+		return nil
+	}
+	if name != "" {
+		op += " " + name
+	}
+	if errors.Is(err, unix.ENOTSUP) {
+		return fmt.Errorf("%s: %s: %w", p, op, unsupported)
+	}
+	return fmt.Errorf("%s: %s: %w", p, op, err)
+}
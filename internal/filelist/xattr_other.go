@@ -0,0 +1,38 @@
+//go:build !linux
+
+package filelist
+
+import (
+	"fmt"
+	"os"
+)
+
+func readXattrs(p string, follow, root bool) ([]XAttr, error) {
+	return nil, fmt.Errorf("%s: %w", p, ErrXattrUnsupported)
+}
+
+func readACLs(p string, follow, dir bool) (access, def ACL, err error) {
+	return nil, nil, fmt.Errorf("%s: %w", p, ErrACLUnsupported)
+}
+
+func (a *Applier) pathXattrs(p string, e Entry) error {
+	return a.applyXattrs(unsupportedTarget{p}, e)
+}
+
+func (a *Applier) fileXattrs(f *os.File, e Entry) error {
+	return a.applyXattrs(unsupportedTarget{f.Name()}, e)
+}
+
+type unsupportedTarget struct{ p string }
+
+func (t unsupportedTarget) list() ([]string, error) {
+	return nil, fmt.Errorf("%s: %w", t.p, ErrXattrUnsupported)
+}
+
+func (t unsupportedTarget) set(name string, v []byte, unsupported error) error {
+	return fmt.Errorf("%s: setting %s: %w", t.p, name, unsupported)
+}
+
+func (t unsupportedTarget) remove(name string, unsupported error) error {
+	return fmt.Errorf("%s: removing %s: %w", t.p, name, unsupported)
+}
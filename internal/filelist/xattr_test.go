@@ -0,0 +1,200 @@
+//go:build linux
+
+package filelist
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"golang.org/x/sys/unix"
+
+	"riptide/internal/cli"
+)
+
+// fileACL grants uid 4242 read/write on top of 0640.
+var fileACL = ACL{
+	{Tag: ACLUserObj, Perm: 6},
+	{Tag: ACLUser, Perm: 6, ID: 4242},
+	{Tag: ACLGroupObj, Perm: 4},
+	{Tag: ACLMask, Perm: 6},
+	{Tag: ACLOther, Perm: 0},
+}
+
+func setXattr(t *testing.T, p, name string, v []byte) {
+	t.Helper()
+	if err := unix.Lsetxattr(p, name, v, 0); err != nil {
+		if errors.Is(err, unix.ENOTSUP) {
+			t.Skipf("%s: %v", name, err)
+		}
+		t.Fatalf("setxattr %s: %v", name, err)
+	}
+}
+
+func TestXattrsAndACLs(t *testing.T) {
+	src, dest := t.TempDir(), filepath.Join(t.TempDir(), "dest")
+	t0 := time.Unix(1_600_000_000, 5)
+	f := filepath.Join(src, "data", "part-0001.parquet")
+	writeFile(t, f, "columnar bytes", t0)
+	setXattr(t, f, "user.lineage", []byte("job=ingest;run=42"))
+	setXattr(t, f, "user.empty", nil)
+	setXattr(t, f, aclAccess, encodeACL(fileACL))
+	setXattr(t, filepath.Join(src, "data"), "user.owner", []byte("analytics"))
+	setXattr(t, filepath.Join(src, "data"), aclDefault, encodeACL(fileACL))
+	writeFile(t, filepath.Join(src, "plain"), "no attributes", t0)
+
+	cfg, err := cli.ParseArgs([]string{"-a", "-X", "-A", src, dest})
+	if err != nil {
+		t.Fatal(err)
+	}
+	list, err := Scan(src, ScanOptionsFor(cfg))
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := byPath(list)
+	wantX := []XAttr{{Name: "user.empty", Value: nil}, {Name: "user.lineage", Value: []byte("job=ingest;run=42")}}
+	if got := m["data/part-0001.parquet"]; !reflect.DeepEqual(got.XAttrs, wantX) || !reflect.DeepEqual(got.ACL, fileACL) {
+		t.Fatalf("scanned file: %+v %+v", got.XAttrs, got.ACL)
+	}
+	if got := m["data"]; !reflect.DeepEqual(got.DefaultACL, fileACL) || got.ACL != nil {
+		t.Fatalf("scanned dir: %+v / %+v", got.ACL, got.DefaultACL)
+	}
+	if got := m["plain"]; got.XAttrs != nil || got.ACL != nil {
+		t.Fatalf("plain file: %+v", got)
+	}
+
+	// The metadata stream carries exactly what the scan found.
+	var wire bytes.Buffer
+	if err := WriteMeta(&wire, list); err != nil {
+		t.Fatal(err)
+	}
+	bare := make([]Entry, len(list))
+	for i, e := range list {
+		e.XAttrs, e.ACL, e.DefaultACL = nil, nil, nil
+		bare[i] = e
+	}
+	if err := ReadMeta(bytes.NewReader(wire.Bytes()), bare); err != nil {
+		t.Fatal(err)
+	}
+	for i := range list {
+		if !reflect.DeepEqual(metaOf(bare[i]), metaOf(list[i])) {
+			t.Fatalf("%s: metadata stream round trip", list[i].Path)
+		}
+	}
+	if err := ReadMeta(bytes.NewReader(wire.Bytes()[:wire.Len()-1]), bare); !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Fatalf("truncated stream: %v", err)
+	}
+	if err := ReadMeta(bytes.NewReader(wire.Bytes()), bare[:1]); err == nil {
+		t.Fatalf("index past the list accepted")
+	}
+
+	// Attributes land on the temp file before it replaces the destination.
+	syncTree(t, src, dest, cfg)
+	got, err := Scan(dest, ScanOptionsFor(cfg))
+	if err != nil {
+		t.Fatal(err)
+	}
+	gm := byPath(got)
+	for _, e := range list {
+		if !reflect.DeepEqual(metaOf(gm[e.Path]), metaOf(e)) {
+			t.Fatalf("%s: got %+v want %+v", e.Path, metaOf(gm[e.Path]), metaOf(e))
+		}
+	}
+
+	// Drift on an unchanged file is undone: extra attributes are removed
+	// and a dropped ACL is restored.
+	df := filepath.Join(dest, "data", "part-0001.parquet")
+	setXattr(t, df, "user.stale", []byte("x"))
+	if err := unix.Removexattr(df, aclAccess); err != nil {
+		t.Fatal(err)
+	}
+	setXattr(t, filepath.Join(dest, "plain"), aclAccess, encodeACL(fileACL))
+	ds, _ := syncTree(t, src, dest, cfg)
+	for _, d := range ds {
+		if d.Action != ActSkip {
+			t.Fatalf("second run: %s %v", d.Entry.Path, d.Action)
+		}
+	}
+	got, _ = Scan(dest, ScanOptionsFor(cfg))
+	gm = byPath(got)
+	for _, e := range list {
+		if !reflect.DeepEqual(metaOf(gm[e.Path]), metaOf(e)) {
+			t.Fatalf("after drift %s: got %+v want %+v", e.Path, metaOf(gm[e.Path]), metaOf(e))
+		}
+	}
+}
+
+type entryMeta struct {
+	XAttrs          []XAttr
+	ACL, DefaultACL ACL
+}
+
+func metaOf(e Entry) entryMeta {
+	return entryMeta{e.XAttrs, e.ACL, e.DefaultACL}
+}
+
+func TestXattrUnsupportedErrors(t *testing.T) {
+	err := xattrErr("setxattr", "/mnt/vfat/f", "user.lineage", unix.EOPNOTSUPP, ErrXattrUnsupported)
+	if !errors.Is(err, ErrXattrUnsupported) {
+		t.Fatalf("EOPNOTSUPP not reported as unsupported: %v", err)
+	}
+	err = xattrErr("setxattr", "/mnt/vfat/f", aclAccess, unix.ENOTSUP, ErrACLUnsupported)
+	if !errors.Is(err, ErrACLUnsupported) || errors.Is(err, ErrXattrUnsupported) {
+		t.Fatalf("ACL error: %v", err)
+	}
+	if err := xattrErr("setxattr", "f", "user.x", unix.EPERM, ErrXattrUnsupported); errors.Is(err, ErrXattrUnsupported) || !errors.Is(err, unix.EPERM) {
+		t.Fatalf("EPERM: %v", err)
+	}
+
+	// /proc does not take user attributes.
+	a := NewApplier("/proc/self", MetaOptions{XAttrs: true})
+	e := Entry{Path: "comm", Type: TypeFile, XAttrs: []XAttr{{Name: "user.lineage", Value: []byte("x")}}}
+	if err := a.Apply(Decision{Entry: e, Action: ActSkip}); !errors.Is(err, ErrXattrUnsupported) {
+		t.Fatalf("procfs: %v", err)
+	}
+	e.XAttrs = nil
+	if err := a.Apply(Decision{Entry: e, Action: ActSkip}); err != nil {
+		t.Fatalf("nothing to set should not fail: %v", err)
+	}
+}
+
+func TestFileCapabilitySurvivesOwnership(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("needs root to set security.capability and chown")
+	}
+	src, dest := t.TempDir(), filepath.Join(t.TempDir(), "dest")
+	f := filepath.Join(src, "ping")
+	writeFile(t, f, "binary", time.Unix(1_600_000_000, 0))
+	if err := os.Chown(f, 4321, 8765); err != nil {
+		t.Fatal(err)
+	}
+	// VFS_CAP_REVISION_2, effective, permitted cap_net_raw (13).
+	capability := make([]byte, 20)
+	binary.LittleEndian.PutUint32(capability, 0x02000001)
+	binary.LittleEndian.PutUint32(capability[4:], 1<<13)
+	setXattr(t, f, "security.capability", capability)
+
+	cfg, err := cli.ParseArgs([]string{"-a", "-X", src, dest})
+	if err != nil {
+		t.Fatal(err)
+	}
+	syncTree(t, src, dest, cfg)
+	got, err := Scan(dest, ScanOptionsFor(cfg))
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := byPath(got)["ping"]
+	if e.UID != 4321 || e.GID != 8765 {
+		t.Fatalf("owner %d:%d", e.UID, e.GID)
+	}
+	want := []XAttr{{Name: "security.capability", Value: capability}}
+	if !reflect.DeepEqual(e.XAttrs, want) {
+		t.Fatalf("capability lost: %+v", e.XAttrs)
+	}
+}
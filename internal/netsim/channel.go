@@ -0,0 +1,61 @@
+// Package netsim provides deterministic, seeded link models for exercising
+// the transport's loss recovery without a real network.
+package netsim
+
+import "math/rand"
+
+// Channel decides the fate of each packet sent across a simulated link.
+type Channel interface {
+	Lost() bool
+}
+
+// Bernoulli drops each packet independently with probability Loss.
+type Bernoulli struct {
+	Loss float64
+	rng  *rand.Rand
+}
+
+func NewBernoulli(loss float64, seed int64) *Bernoulli {
+	return &Bernoulli{Loss: loss, rng: rand.New(rand.NewSource(seed))}
+}
+
+func (b *Bernoulli) Lost() bool {
+	return b.rng.Float64() < b.Loss
+}
+
+// GilbertElliott is a two-state burst loss channel. Each packet first moves
+// the chain (good to bad with probability P, bad to good with probability
+// R) and is then dropped with the loss probability of the new state.
+type GilbertElliott struct {
+	P, R     float64
+	GoodLoss float64
+	BadLoss  float64
+	bad      bool
+	rng      *rand.Rand
+}
+
+func NewGilbertElliott(p, r, goodLoss, badLoss float64, seed int64) *GilbertElliott {
+	return &GilbertElliott{P: p, R: r, GoodLoss: goodLoss, BadLoss: badLoss, rng: rand.New(rand.NewSource(seed))}
+}
+
+func (g *GilbertElliott) Lost() bool {
+	if g.bad {
+		g.bad = g.rng.Float64() >= g.R
+	} else {
+		g.bad = g.rng.Float64() < g.P
+	}
+	loss := g.GoodLoss
+	if g.bad {
+		loss = g.BadLoss
+	}
+	return g.rng.Float64() < loss
+}
+
+// LossRate is the long-run fraction of packets the channel drops.
+func (g *GilbertElliott) LossRate() float64 {
+	if g.P+g.R == 0 {
+		return g.GoodLoss
+	}
+	bad := g.P / (g.P + g.R)
+	return bad*g.BadLoss + (1-bad)*g.GoodLoss
+}
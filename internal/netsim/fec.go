@@ -0,0 +1,108 @@
+package netsim
+
+import (
+	"errors"
+	"time"
+
+	"riptide/internal/fec"
+)
+
+// FECRun describes one pass of a DATA stream through a channel with
+// streaming FEC and no retransmission.
+type FECRun struct {
+	Data       int
+	Parity     int
+	Depth      int
+	Packets    int
+	PayloadLen int
+}
+
+// FECResult counts DATA packets only; parity is sent through the same
+// channel but is not part of the totals.
+type FECResult struct {
+	Sent     int
+	Lost     int
+	Residual int
+}
+
+// LossRate is the raw channel loss seen by DATA packets.
+func (r FECResult) LossRate() float64 {
+	if r.Sent == 0 {
+		return 0
+	}
+	return float64(r.Lost) / float64(r.Sent)
+}
+
+// ResidualRate is the fraction of DATA packets FEC failed to deliver, i.e.
+// what would be left for ARQ.
+func (r FECResult) ResidualRate() float64 {
+	if r.Sent == 0 {
+		return 0
+	}
+	return float64(r.Residual) / float64(r.Sent)
+}
+
+// RunFEC sends run.Packets DATA payloads in sequence order, each followed
+// by whatever parity it completes, and reports how many never reached the
+// receiver either directly or through reconstruction.
+func RunFEC(ch Channel, run FECRun) (FECResult, error) {
+	if run.PayloadLen <= 0 || run.Packets < 0 {
+		return FECResult{}, errors.New("invalid fec run")
+	}
+	depth := run.Depth
+	if depth <= 0 {
+		depth = 1
+	}
+	enc, err := fec.NewInterleavedStreamEncoder(run.Data, run.Parity, depth)
+	if err != nil {
+		return FECResult{}, err
+	}
+	dec := fec.NewStreamDecoder(time.Second, 4*run.Data*depth)
+	now := time.Unix(0, 0)
+	delivered := make([]bool, run.Packets)
+	payload := make([]byte, run.PayloadLen)
+	var res FECResult
+	recovered := func(rec []fec.Recovered, err error) error {
+		for _, r := range rec {
+			delivered[r.Seq] = true
+		}
+		return err
+	}
+	for seq := 0; seq < run.Packets; seq++ {
+		payload[0] = byte(seq)
+		par, err := enc.Add(uint64(seq), payload)
+		if err != nil {
+			return res, err
+		}
+		if seq == run.Packets-1 {
+			tail, err := enc.Flush()
+			if err != nil {
+				return res, err
+			}
+			par = append(par, tail...)
+		}
+		res.Sent++
+		if ch.Lost() {
+			res.Lost++
+		} else {
+			delivered[seq] = true
+			if err := recovered(dec.OnData(uint64(seq), payload, now)); err != nil {
+				return res, err
+			}
+		}
+		for _, p := range par {
+			if ch.Lost() {
+				continue
+			}
+			if err := recovered(dec.OnParity(p, now)); err != nil {
+				return res, err
+			}
+		}
+	}
+	for _, ok := range delivered {
+		if !ok {
+			res.Residual++
+		}
+	}
+	return res, nil
+}
@@ -0,0 +1,74 @@
+package netsim
+
+import (
+	"math"
+	"testing"
+)
+
+func TestGilbertElliottLossRate(t *testing.T) {
+	g := NewGilbertElliott(0.01, 0.25, 0.001, 0.9, 1)
+	lost, n := 0, 200000
+	for i := 0; i < n; i++ {
+		if g.Lost() {
+			lost++
+		}
+	}
+	got := float64(lost) / float64(n)
+	if math.Abs(got-g.LossRate()) > 0.005 {
+		t.Fatalf("simulated loss %.4f, stationary %.4f", got, g.LossRate())
+	}
+}
+
+func TestInterleavingReducesResidualBurstLoss(t *testing.T) {
+	run := FECRun{Data: 8, Parity: 2, Packets: 40000, PayloadLen: 32}
+	depths := []int{1, 4, 8, 16}
+	residual := make([]float64, len(depths))
+	for i, d := range depths {
+		run.Depth = d
+		res, err := RunFEC(NewGilbertElliott(0.01, 0.25, 0, 1, 7), run)
+		if err != nil {
+			t.Fatalf("depth %d: %v", d, err)
+		}
+		residual[i] = res.ResidualRate()
+		t.Logf("8+2 depth %-2d raw loss %.4f residual %.5f", d, res.LossRate(), res.ResidualRate())
+	}
+	if residual[0] < 0.01 {
+		t.Fatalf("bursts of ~4 should defeat 2 parity shards without interleaving: %.5f", residual[0])
+	}
+	if residual[2] > residual[0]/4 {
+		t.Fatalf("depth 8 residual %.5f not well below depth 1 %.5f", residual[2], residual[0])
+	}
+
+	// Independent loss gains little from interleaving: nothing like the
+	// burst case's fourfold drop.
+	run.Depth = 1
+	flat, err := RunFEC(NewBernoulli(0.038, 7), run)
+	if err != nil {
+		t.Fatalf("bernoulli depth 1: %v", err)
+	}
+	run.Depth = 8
+	flatI, err := RunFEC(NewBernoulli(0.038, 7), run)
+	if err != nil {
+		t.Fatalf("bernoulli depth 8: %v", err)
+	}
+	t.Logf("8+2 bernoulli depth 1 residual %.5f, depth 8 residual %.5f", flat.ResidualRate(), flatI.ResidualRate())
+	if flat.ResidualRate() == 0 {
+		t.Fatalf("3.8%% independent loss should leave some residual loss with 2 parity shards")
+	}
+	if flatI.ResidualRate() < flat.ResidualRate()/2 {
+		t.Fatalf("interleaving should not help much under independent loss: depth 8 %.5f vs depth 1 %.5f",
+			flatI.ResidualRate(), flat.ResidualRate())
+	}
+}
+
+func TestRunFECRejectsBadConfig(t *testing.T) {
+	for _, run := range []FECRun{
+		{Data: 8, Parity: 2, Packets: 10, PayloadLen: 0},
+		{Data: 8, Parity: 2, Packets: -1, PayloadLen: 32},
+		{Data: 0, Parity: 2, Packets: 10, PayloadLen: 32},
+	} {
+		if _, err := RunFEC(NewBernoulli(0, 1), run); err == nil {
+			t.Fatalf("accepted %+v", run)
+		}
+	}
+}
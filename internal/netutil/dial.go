@@ -0,0 +1,185 @@
+package netutil
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/netip"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+const AttemptDelay = 250 * time.Millisecond
+
+func ResolveUDP(ctx context.Context, r *net.Resolver, host string, port int) ([]netip.AddrPort, error) {
+	if port <= 0 || port > 65535 {
+		return nil, errors.New("invalid port")
+	}
+	if a, err := netip.ParseAddr(host); err == nil {
+		return []netip.AddrPort{netip.AddrPortFrom(a.Unmap(), uint16(port))}, nil
+	}
+	if r == nil {
+		r = net.DefaultResolver
+	}
+	ips, err := r.LookupNetIP(ctx, "ip", host)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]netip.AddrPort, 0, len(ips))
+	for _, ip := range ips {
+		out = append(out, netip.AddrPortFrom(ip.Unmap(), uint16(port)))
+	}
+	if len(out) == 0 {
+		return nil, errors.New("no addresses for " + host)
+	}
+	return out, nil
+}
+
+// SortHappyEyeballs orders candidates per RFC 8305: IPv6 first, then
+// alternating families, preserving resolver order within each family.
+func SortHappyEyeballs(addrs []netip.AddrPort) []netip.AddrPort {
+	var v6, v4 []netip.AddrPort
+	for _, a := range addrs {
+		if FamilyOf(a.Addr()) == IPv6 {
+			v6 = append(v6, a)
+		} else {
+			v4 = append(v4, a)
+		}
+	}
+	out := make([]netip.AddrPort, 0, len(addrs))
+	for i := 0; i < len(v6) || i < len(v4); i++ {
+		if i < len(v6) {
+			out = append(out, v6[i])
+		}
+		if i < len(v4) {
+			out = append(out, v4[i])
+		}
+	}
+	return out
+}
+
+type attemptResult[T any] struct {
+	v   T
+	err error
+}
+
+// Race runs attempt against each address in Happy Eyeballs order, starting
+// the next one after delay or as soon as the previous attempt fails. The
+// first success wins and the remaining attempts are cancelled; their
+// results are passed to discard so callers can release resources.
+func Race[T any](ctx context.Context, addrs []netip.AddrPort, delay time.Duration, attempt func(context.Context, netip.AddrPort) (T, error), discard func(T)) (T, error) {
+	var zero T
+	if len(addrs) == 0 {
+		return zero, errors.New("no addresses")
+	}
+	if delay <= 0 {
+		delay = AttemptDelay
+	}
+	order := SortHappyEyeballs(addrs)
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	results := make(chan attemptResult[T], len(order))
+	launch := func(a netip.AddrPort) {
+		go func() {
+			v, err := attempt(ctx, a)
+			results <- attemptResult[T]{v: v, err: err}
+		}()
+	}
+
+	next, running := 0, 0
+	var won bool
+	var winner T
+	var errs []error
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	for next < len(order) || running > 0 {
+		select {
+		case <-timer.C:
+			if next < len(order) && !won {
+				launch(order[next])
+				next++
+				running++
+				timer.Reset(delay)
+			}
+		case r := <-results:
+			running--
+			switch {
+			case r.err != nil:
+				errs = append(errs, r.err)
+				if next < len(order) && !won {
+					timer.Reset(0)
+				}
+			case won:
+				if discard != nil {
+					discard(r.v)
+				}
+			default:
+				won = true
+				winner = r.v
+				next = len(order)
+				cancel()
+			}
+		case <-ctx.Done():
+			if !won {
+				next = len(order)
+				errs = append(errs, ctx.Err())
+			}
+			// Drain so in-flight attempts can deliver and be discarded.
+			for running > 0 {
+				r := <-results
+				running--
+				if r.err == nil && discard != nil {
+					discard(r.v)
+				}
+			}
+		}
+	}
+	if won {
+		return winner, nil
+	}
+	return zero, errors.Join(errs...)
+}
+
+// ListenDualStack opens one UDP socket per address family on port. The
+// IPv6 socket is marked V6ONLY so each socket reports a single family for
+// MTU budgeting. It succeeds if at least one family is available.
+func ListenDualStack(ctx context.Context, port int) ([]*net.UDPConn, error) {
+	var conns []*net.UDPConn
+	var errs []error
+	v6 := net.ListenConfig{Control: func(network, address string, c syscall.RawConn) error {
+		var serr error
+		if err := c.Control(func(fd uintptr) {
+			serr = setV6Only(fd)
+		}); err != nil {
+			return err
+		}
+		return serr
+	}}
+	p := strconv.Itoa(port)
+	if pc, err := v6.ListenPacket(ctx, "udp6", net.JoinHostPort("::", p)); err == nil {
+		conns = append(conns, pc.(*net.UDPConn))
+		if port == 0 {
+			p = strconv.Itoa(pc.LocalAddr().(*net.UDPAddr).Port)
+		}
+	} else {
+		errs = append(errs, err)
+	}
+	var v4 net.ListenConfig
+	if pc, err := v4.ListenPacket(ctx, "udp4", net.JoinHostPort("0.0.0.0", p)); err == nil {
+		conns = append(conns, pc.(*net.UDPConn))
+	} else {
+		errs = append(errs, err)
+	}
+	if len(conns) == 0 {
+		return nil, errors.Join(errs...)
+	}
+	return conns, nil
+}
+
+func ConnFamily(c *net.UDPConn) Family {
+	if ua, ok := c.LocalAddr().(*net.UDPAddr); ok && ua.IP.To4() != nil {
+		return IPv4
+	}
+	return IPv6
+}
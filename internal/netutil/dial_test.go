@@ -0,0 +1,126 @@
+package netutil
+
+import (
+	"context"
+	"errors"
+	"net/netip"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSortHappyEyeballs(t *testing.T) {
+	in := []netip.AddrPort{
+		netip.MustParseAddrPort("10.0.0.1:1"),
+		netip.MustParseAddrPort("10.0.0.2:1"),
+		netip.MustParseAddrPort("[2001:db8::1]:1"),
+	}
+	got := SortHappyEyeballs(in)
+	want := []string{"[2001:db8::1]:1", "10.0.0.1:1", "10.0.0.2:1"}
+	for i := range want {
+		if got[i].String() != want[i] {
+			t.Fatalf("order[%d] = %s want %s", i, got[i], want[i])
+		}
+	}
+}
+
+func TestResolveUDPLiteral(t *testing.T) {
+	got, err := ResolveUDP(context.Background(), nil, "::1", 3703)
+	if err != nil || len(got) != 1 || got[0].String() != "[::1]:3703" {
+		t.Fatalf("resolve literal: %v %v", got, err)
+	}
+	if _, err := ResolveUDP(context.Background(), nil, "::1", 0); err == nil {
+		t.Fatalf("expected port error")
+	}
+}
+
+func TestRaceFallsBackAfterDelay(t *testing.T) {
+	v6 := netip.MustParseAddrPort("[2001:db8::1]:1")
+	v4 := netip.MustParseAddrPort("192.0.2.1:1")
+	got, err := Race(context.Background(), []netip.AddrPort{v4, v6}, 10*time.Millisecond,
+		func(ctx context.Context, a netip.AddrPort) (netip.AddrPort, error) {
+			if a == v6 {
+				<-ctx.Done()
+				return a, ctx.Err()
+			}
+			return a, nil
+		}, nil)
+	if err != nil || got != v4 {
+		t.Fatalf("got %v %v, want v4 winner", got, err)
+	}
+}
+
+func TestRaceFailureStartsNextImmediately(t *testing.T) {
+	addrs := []netip.AddrPort{
+		netip.MustParseAddrPort("[2001:db8::1]:1"),
+		netip.MustParseAddrPort("192.0.2.1:1"),
+	}
+	start := time.Now()
+	got, err := Race(context.Background(), addrs, time.Hour,
+		func(ctx context.Context, a netip.AddrPort) (netip.AddrPort, error) {
+			if a.Addr().Is6() {
+				return a, errors.New("unreachable")
+			}
+			return a, nil
+		}, nil)
+	if err != nil || got != addrs[1] {
+		t.Fatalf("got %v %v", got, err)
+	}
+	if time.Since(start) > time.Second {
+		t.Fatalf("failure should not wait for the attempt delay")
+	}
+}
+
+func TestRaceDiscardsLosersAndJoinsErrors(t *testing.T) {
+	addrs := []netip.AddrPort{
+		netip.MustParseAddrPort("[2001:db8::1]:1"),
+		netip.MustParseAddrPort("192.0.2.1:1"),
+	}
+	var mu sync.Mutex
+	var discarded []netip.AddrPort
+	release := make(chan struct{})
+	got, err := Race(context.Background(), addrs, time.Millisecond,
+		func(ctx context.Context, a netip.AddrPort) (netip.AddrPort, error) {
+			if a.Addr().Is6() {
+				<-release
+				return a, nil
+			}
+			close(release)
+			return a, nil
+		}, func(a netip.AddrPort) {
+			mu.Lock()
+			discarded = append(discarded, a)
+			mu.Unlock()
+		})
+	if err != nil {
+		t.Fatalf("race: %v", err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(discarded) > 1 || (len(discarded) == 1 && discarded[0] == got) {
+		t.Fatalf("winner %v discarded %v", got, discarded)
+	}
+
+	_, err = Race(context.Background(), addrs, time.Millisecond,
+		func(ctx context.Context, a netip.AddrPort) (int, error) {
+			return 0, errors.New("fail " + a.String())
+		}, nil)
+	if err == nil {
+		t.Fatalf("expected joined error")
+	}
+}
+
+func TestListenDualStack(t *testing.T) {
+	conns, err := ListenDualStack(context.Background(), 0)
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	seen := map[Family]bool{}
+	for _, c := range conns {
+		seen[ConnFamily(c)] = true
+		c.Close()
+	}
+	if len(seen) != len(conns) {
+		t.Fatalf("expected one socket per family, got %d sockets for %v", len(conns), seen)
+	}
+}
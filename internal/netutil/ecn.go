@@ -0,0 +1,16 @@
+package netutil
+
+// ECN is the two-bit Explicit Congestion Notification field of the IP
+// TOS / traffic class byte (RFC 3168).
+type ECN uint8
+
+const (
+	ECNNotECT ECN = 0
+	ECNECT1   ECN = 1
+	ECNECT0   ECN = 2
+	ECNCE     ECN = 3
+)
+
+func ECNFromTOS(tos byte) ECN {
+	return ECN(tos & 0x03)
+}
@@ -1,23 +1,73 @@
 package netutil
 
 import (
+	"net/netip"
+
 	"golang.org/x/crypto/chacha20poly1305"
 )
 
 const (
 	IPv4UDPOverhead = 20 + 8
+	IPv6UDPOverhead = 40 + 8
+	IPv4MinMTU      = 576
+	IPv6MinMTU      = 1280
 	NonceLen        = 12
 )
 
+type Family uint8
+
+const (
+	IPv4 Family = iota
+	IPv6
+)
+
+func FamilyOf(a netip.Addr) Family {
+	if a.Unmap().Is4() {
+		return IPv4
+	}
+	return IPv6
+}
+
+func (f Family) Overhead() int {
+	if f == IPv6 {
+		return IPv6UDPOverhead
+	}
+	return IPv4UDPOverhead
+}
+
+func (f Family) MinMTU() int {
+	if f == IPv6 {
+		return IPv6MinMTU
+	}
+	return IPv4MinMTU
+}
+
+// BaseMTU is the size assumed to work before any probing: the RFC 8899
+// BASE_PLPMTU of 1200 for IPv4 and the protocol minimum for IPv6.
+func (f Family) BaseMTU() int {
+	if f == IPv6 {
+		return IPv6MinMTU
+	}
+	return 1200
+}
+
 func UDPPayloadBudget(mtu int) int {
-	if mtu <= IPv4UDPOverhead {
+	return UDPPayloadBudgetFor(IPv4, mtu)
+}
+
+func UDPPayloadBudgetFor(f Family, mtu int) int {
+	if mtu <= f.Overhead() {
 		return 0
 	}
-	return mtu - IPv4UDPOverhead
+	return mtu - f.Overhead()
 }
 
 func MaxDataPerPacket(mtu int, headerLen int) int {
-	budget := UDPPayloadBudget(mtu)
+	return MaxDataPerPacketFor(IPv4, mtu, headerLen)
+}
+
+func MaxDataPerPacketFor(f Family, mtu int, headerLen int) int {
+	budget := UDPPayloadBudgetFor(f, mtu)
 	overhead := headerLen + NonceLen + chacha20poly1305.Overhead
 	if budget <= overhead {
 		return 0
@@ -0,0 +1,39 @@
+package netutil
+
+import (
+	"net/netip"
+	"testing"
+)
+
+func TestFamilyBudgets(t *testing.T) {
+	if got := UDPPayloadBudgetFor(IPv4, 1500); got != 1472 {
+		t.Fatalf("ipv4 budget = %d", got)
+	}
+	if got := UDPPayloadBudgetFor(IPv6, 1500); got != 1452 {
+		t.Fatalf("ipv6 budget = %d", got)
+	}
+	if UDPPayloadBudget(1500) != UDPPayloadBudgetFor(IPv4, 1500) {
+		t.Fatalf("default budget should be ipv4")
+	}
+	if d := MaxDataPerPacketFor(IPv4, 1500, 32) - MaxDataPerPacketFor(IPv6, 1500, 32); d != 20 {
+		t.Fatalf("ipv6 should cost 20 more bytes, got %d", d)
+	}
+	if MaxDataPerPacketFor(IPv6, 48, 32) != 0 {
+		t.Fatalf("expected zero budget")
+	}
+	if IPv6.BaseMTU() != 1280 || IPv4.BaseMTU() != 1200 {
+		t.Fatalf("base mtu mismatch")
+	}
+}
+
+func TestFamilyOf(t *testing.T) {
+	if FamilyOf(netip.MustParseAddr("10.0.0.1")) != IPv4 {
+		t.Fatalf("v4")
+	}
+	if FamilyOf(netip.MustParseAddr("::ffff:10.0.0.1")) != IPv4 {
+		t.Fatalf("v4-mapped")
+	}
+	if FamilyOf(netip.MustParseAddr("2001:db8::1")) != IPv6 {
+		t.Fatalf("v6")
+	}
+}
@@ -0,0 +1,7 @@
+//go:build !unix
+
+package netutil
+
+func setV6Only(fd uintptr) error {
+	return nil
+}
@@ -0,0 +1,9 @@
+//go:build unix
+
+package netutil
+
+import "syscall"
+
+func setV6Only(fd uintptr) error {
+	return syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IPV6, syscall.IPV6_V6ONLY, 1)
+}
@@ -1,35 +1,93 @@
 package pipeline
 
 import (
-	"bytes"
-	"io"
+	"encoding/binary"
+	"errors"
+	"sync"
 
 	"github.com/pierrec/lz4/v4"
+
+	"riptide/internal/bufpool"
+)
+
+// Compressed chunks are a 4-byte big-endian header holding the original
+// length, with the top bit set when the rest is an LZ4 block rather than
+// the data stored as is (for chunks LZ4 cannot shrink).
+const (
+	lz4HeaderLen = 4
+	lz4Block     = 1 << 31
+	maxLZ4Chunk  = lz4Block - 1
 )
 
+var compressors = sync.Pool{New: func() any { return new(lz4.Compressor) }}
+
+// CompressLZ4 compresses each chunk into a pooled buffer.
 func CompressLZ4() Transform {
 	return func(d Descriptor) (Descriptor, error) {
-		var buf bytes.Buffer
-		w := lz4.NewWriter(&buf)
-		if _, err := w.Write(d.Data); err != nil {
-			return Descriptor{}, err
+		if len(d.Data) > maxLZ4Chunk {
+			return Descriptor{}, errors.New("chunk too large to compress")
 		}
-		if err := w.Close(); err != nil {
+		out := bufpool.Get(lz4HeaderLen + lz4.CompressBlockBound(len(d.Data)))
+		c := compressors.Get().(*lz4.Compressor)
+		n, err := c.CompressBlock(d.Data, out.B[lz4HeaderLen:])
+		compressors.Put(c)
+		if err != nil {
+			out.Release()
 			return Descriptor{}, err
 		}
-		d.Data = buf.Bytes()
-		return d, nil
+		hdr := uint32(len(d.Data))
+		if n == 0 || n >= len(d.Data) {
+			n = copy(out.B[lz4HeaderLen:], d.Data)
+		} else {
+			hdr |= lz4Block
+		}
+		binary.BigEndian.PutUint32(out.B, hdr)
+		out.B = out.B[:lz4HeaderLen+n]
+		return d.replace(out), nil
 	}
 }
 
+// DefaultMaxChunk caps the chunks DecompressLZ4 accepts.
+const DefaultMaxChunk = 4 << 20
+
+// DecompressLZ4 reverses CompressLZ4 for chunks of up to DefaultMaxChunk
+// bytes.
 func DecompressLZ4() Transform {
+	return DecompressLZ4Max(DefaultMaxChunk)
+}
+
+// DecompressLZ4Max is DecompressLZ4 for a negotiated chunk size. The length
+// in the header comes from the peer, so chunks claiming more than maxChunk
+// bytes, or an LZ4 body longer than any compression of that many bytes, are
+// refused before a buffer is taken.
+func DecompressLZ4Max(maxChunk int) Transform {
 	return func(d Descriptor) (Descriptor, error) {
-		r := lz4.NewReader(bytes.NewReader(d.Data))
-		out, err := io.ReadAll(r)
-		if err != nil {
-			return Descriptor{}, err
+		if len(d.Data) < lz4HeaderLen {
+			return Descriptor{}, errors.New("short compressed chunk")
+		}
+		hdr := binary.BigEndian.Uint32(d.Data)
+		size := int(hdr &^ lz4Block)
+		body := d.Data[lz4HeaderLen:]
+		if size > maxChunk {
+			return Descriptor{}, errors.New("compressed chunk larger than the chunk size")
+		}
+		if hdr&lz4Block != 0 && len(body) > lz4.CompressBlockBound(size) {
+			return Descriptor{}, errors.New("corrupt lz4 chunk")
+		}
+		out := bufpool.Get(size)
+		if hdr&lz4Block == 0 {
+			if len(body) != size {
+				out.Release()
+				return Descriptor{}, errors.New("stored chunk length mismatch")
+			}
+			copy(out.B, body)
+		} else {
+			n, err := lz4.UncompressBlock(body, out.B)
+			if err != nil || n != size {
+				out.Release()
+				return Descriptor{}, errors.New("corrupt lz4 chunk")
+			}
 		}
-		d.Data = out
-		return d, nil
+		return d.replace(out), nil
 	}
 }
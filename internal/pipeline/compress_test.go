@@ -1,8 +1,10 @@
 package pipeline
 
 import (
+	"bytes"
 	"testing"
 
+	"riptide/internal/bufpool"
 	"riptide/internal/cryptoutil"
 )
 
@@ -91,3 +93,57 @@ func TestCompressEncryptDecryptDecompress(t *testing.T) {
 		}
 	}
 }
+
+func TestDecompressRejectsOversizedHeader(t *testing.T) {
+	ds, err := ApplyTransforms(Chunk(make([]byte, 1024), 1024), CompressLZ4())
+	if err != nil {
+		t.Fatalf("compress: %v", err)
+	}
+	if _, err := DecompressLZ4Max(512)(ds[0]); err == nil {
+		t.Fatalf("chunk above the negotiated size accepted")
+	}
+	// A tiny body claiming 2 GiB of output.
+	huge := []byte{0xff, 0xff, 0xff, 0xff, 0x10, 0x00}
+	if _, err := DecompressLZ4Max(1 << 20)(Descriptor{Data: huge}); err == nil {
+		t.Fatalf("2 GiB header accepted")
+	}
+	if _, err := DecompressLZ4()(Descriptor{Data: huge}); err == nil {
+		t.Fatalf("2 GiB header accepted under the default cap")
+	}
+	// A body longer than any LZ4 compression of the stated size.
+	long := append([]byte{0x80, 0x00, 0x00, 0x10}, make([]byte, 64)...)
+	if _, err := DecompressLZ4Max(1024)(Descriptor{Data: long}); err == nil {
+		t.Fatalf("body past the compression bound accepted")
+	}
+}
+
+func TestTransformsLeaveInputIntact(t *testing.T) {
+	enc := testEncryptor(t)
+	const size = 1000
+	want := make([]byte, size)
+	for i := range want {
+		want[i] = byte(i % 7)
+	}
+	b := bufpool.Get(size)
+	copy(b.B, want)
+	in := Descriptor{Data: b.B, Buf: b}
+
+	if _, err := ApplyTransforms([]Descriptor{in}, CompressLZ4(), Encrypt(enc)); err != nil {
+		t.Fatalf("apply: %v", err)
+	}
+	if _, err := Compose(ComputeChecksum(), CompressLZ4())(in); err != nil {
+		t.Fatalf("compose: %v", err)
+	}
+	// Had a transform released the input, one of these would get its
+	// buffer back and scribble over it.
+	for i := 0; i < 16; i++ {
+		x := bufpool.Get(size)
+		for j := range x.B {
+			x.B[j] = 0xee
+		}
+	}
+	if !bytes.Equal(in.Data, want) {
+		t.Fatalf("input changed after being transformed")
+	}
+	in.Release()
+}
@@ -3,6 +3,7 @@ package pipeline
 import (
 	"errors"
 
+	"riptide/internal/bufpool"
 	"riptide/internal/checksum"
 	"riptide/internal/cryptoutil"
 )
@@ -17,12 +18,10 @@ func Encrypt(enc *Encryptor) Transform {
 		return func(d Descriptor) (Descriptor, error) { return Descriptor{}, errors.New("nil encryptor") }
 	}
 	return func(d Descriptor) (Descriptor, error) {
-		ct, nonce := enc.AEAD.Seal(nil, d.Data, enc.AAD)
-		out := make([]byte, 12+len(ct))
-		copy(out[:12], nonce[:])
-		copy(out[12:], ct)
-		d.Data = out
-		return d, nil
+		out := bufpool.Get(12 + len(d.Data) + cryptoutil.Overhead())
+		enc.AEAD.PutNonce(out.B[:12])
+		enc.AEAD.SealNonce(out.B[12:12], out.B[:12], d.Data, enc.AAD)
+		return d.replace(out), nil
 	}
 }
 
@@ -34,14 +33,14 @@ func Decrypt(enc *Encryptor) Transform {
 		if len(d.Data) < 12 {
 			return Descriptor{}, errors.New("ciphertext too short")
 		}
-		var nonce [12]byte
-		copy(nonce[:], d.Data[:12])
-		pt, err := enc.AEAD.Open(nil, d.Data[12:], enc.AAD, nonce)
+		out := bufpool.Get(len(d.Data) - 12)
+		pt, err := enc.AEAD.OpenNonce(out.B[:0], d.Data[:12], d.Data[12:], enc.AAD)
 		if err != nil {
+			out.Release()
 			return Descriptor{}, err
 		}
-		d.Data = pt
-		return d, nil
+		out.B = pt
+		return d.replace(out), nil
 	}
 }
 
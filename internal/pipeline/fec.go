@@ -3,6 +3,7 @@ package pipeline
 import (
 	"errors"
 
+	"riptide/internal/bufpool"
 	"riptide/internal/fec"
 )
 
@@ -19,34 +20,35 @@ func FECGroupEncode(ds []Descriptor, dataShards, parityShards int) ([]Descriptor
 			maxLen = l
 		}
 	}
-	data := make([][]byte, dataShards)
-	for i := 0; i < dataShards; i++ {
-		if len(ds[i].Data) == maxLen {
-			cp := make([]byte, maxLen)
-			copy(cp, ds[i].Data)
-			data[i] = cp
-			continue
-		}
-		p := make([]byte, maxLen)
-		copy(p, ds[i].Data)
-		data[i] = p
-	}
-	codec, err := fec.NewCodec(dataShards, parityShards)
+	codec, err := fec.CachedCodec(dataShards, parityShards)
 	if err != nil {
 		return nil, err
 	}
-	shards, err := codec.BuildShards(data)
-	if err != nil {
-		return nil, err
+	// Padded data copies only live for the encode; each parity shard is a
+	// pooled buffer owned by its descriptor and released by the sink.
+	scratch := fec.GetShards(dataShards, maxLen)
+	defer scratch.Release()
+	shards := scratch.Block(parityShards)
+	for i := 0; i < dataShards; i++ {
+		copy(scratch.S[i], ds[i].Data)
 	}
 	out := make([]Descriptor, 0, len(ds)+parityShards)
 	out = append(out, ds...)
-	for i := dataShards; i < len(shards); i++ {
+	for i := 0; i < parityShards; i++ {
+		b := bufpool.Get(maxLen)
+		shards[dataShards+i] = b.B
 		out = append(out, Descriptor{
 			Offset: ds[0].Offset,
-			Data:   shards[i],
+			Data:   b.B,
+			Buf:    b,
 		})
 	}
+	if err := codec.EncodeInPlace(shards); err != nil {
+		for _, d := range out[len(ds):] {
+			d.Release()
+		}
+		return nil, err
+	}
 	return out, nil
 }
 
@@ -64,7 +66,7 @@ func FECGroupReconstruct(shards []Descriptor, dataShards, parityShards int, lost
 		}
 		arr[idx] = nil
 	}
-	codec, err := fec.NewCodec(dataShards, parityShards)
+	codec, err := fec.CachedCodec(dataShards, parityShards)
 	if err != nil {
 		return nil, err
 	}
@@ -44,3 +44,18 @@ func TestFECGroupEncodeReconstruct(t *testing.T) {
 		}
 	}
 }
+
+func BenchmarkFECGroupEncode(b *testing.B) {
+	group := Chunk(make([]byte, 10*1200), 1200)
+	b.ReportAllocs()
+	b.SetBytes(int64(10 * 1200))
+	for i := 0; i < b.N; i++ {
+		enc, err := FECGroupEncode(group, 10, 3)
+		if err != nil {
+			b.Fatal(err)
+		}
+		for _, d := range enc[len(group):] {
+			d.Release()
+		}
+	}
+}
@@ -0,0 +1,30 @@
+package pipeline
+
+import (
+	"riptide/internal/bufpool"
+	"riptide/internal/cryptoutil"
+	"riptide/internal/proto"
+)
+
+// SealPacket encodes d as the payload of a DATA packet with header h and
+// seals it in place in one pooled buffer, releasing d. The result is what
+// goes on the wire; a caller that also keeps it for retransmission takes
+// its own reference with Retain.
+func SealPacket(d Descriptor, h proto.Header, a *cryptoutil.AEAD, aad []byte) *bufpool.Buffer {
+	payload := proto.DataPayload{ChunkID: d.ChunkID, Offset: d.Offset, Checksum: d.Sum, Data: d.Data}
+	b := bufpool.Get(proto.DataPacketLen(len(d.Data)))
+	b.B = proto.AppendDataPacket(b.B[:0], h, payload, a, aad)
+	d.Release()
+	return b
+}
+
+// OpenPacket decrypts a DATA packet in place and returns its payload as a
+// descriptor that takes over the caller's reference to pkt. On error the
+// reference stays with the caller.
+func OpenPacket(pkt *bufpool.Buffer, a *cryptoutil.AEAD, aad []byte) (proto.Header, Descriptor, error) {
+	h, p, err := proto.ParseDataPacket(pkt.B, a, aad)
+	if err != nil {
+		return proto.Header{}, Descriptor{}, err
+	}
+	return h, Descriptor{ChunkID: p.ChunkID, Offset: p.Offset, Sum: p.Checksum, Data: p.Data, Buf: pkt}, nil
+}
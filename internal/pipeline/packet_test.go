@@ -0,0 +1,81 @@
+package pipeline
+
+import (
+	"bytes"
+	"math/rand"
+	"net"
+	"testing"
+
+	"riptide/internal/bufpool"
+	"riptide/internal/proto"
+)
+
+func TestPacketHotPathDoesNotAllocate(t *testing.T) {
+	if raceEnabled {
+		t.Skip("allocation counts are unreliable under -race")
+	}
+	enc := testEncryptor(t)
+	rx, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer rx.Close()
+	tx, err := net.DialUDP("udp", nil, rx.LocalAddr().(*net.UDPAddr))
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer tx.Close()
+
+	const chunk = 1200
+	src := make([]byte, chunk)
+	rand.New(rand.NewSource(5)).Read(src[:chunk/2])
+	send := Compose(ComputeChecksum(), CompressLZ4(), Encrypt(enc))
+	recv := Compose(Decrypt(enc), DecompressLZ4(), VerifyChecksum())
+	h := proto.Header{Version: proto.Version, Type: proto.TypeData}
+	var id uint64
+
+	// One packet: read into a pooled chunk, transform, encode and seal into
+	// a single buffer, send it, receive into another pooled buffer, open it
+	// in place and undo the transforms.
+	packet := func() {
+		b := bufpool.Get(chunk)
+		copy(b.B, src)
+		d, err := send(Descriptor{ChunkID: id, Offset: id * chunk, Data: b.B, Buf: b})
+		if err != nil {
+			t.Fatal(err)
+		}
+		b.Release()
+		h.Seq = id
+		id++
+		pkt := SealPacket(d, h, enc.AEAD, enc.AAD)
+		_, err = tx.Write(pkt.B)
+		pkt.Release()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		in := bufpool.Get(proto.DataPacketLen(chunk + 64))
+		n, err := rx.Read(in.B)
+		if err != nil {
+			t.Fatal(err)
+		}
+		in.B = in.B[:n]
+		_, rd, err := OpenPacket(in, enc.AEAD, enc.AAD)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rd, err = recv(rd)
+		if err != nil {
+			t.Fatal(err)
+		}
+		in.Release()
+		if !bytes.Equal(rd.Data, src) {
+			t.Fatalf("packet %d corrupted", rd.ChunkID)
+		}
+		rd.Release()
+	}
+	packet()
+	if allocs := testing.AllocsPerRun(500, packet); allocs != 0 {
+		t.Fatalf("%.2f allocs per packet", allocs)
+	}
+}
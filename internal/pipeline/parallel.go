@@ -0,0 +1,97 @@
+package pipeline
+
+import (
+	"context"
+	"sync"
+)
+
+type parallelJob struct {
+	seq uint64
+	d   Descriptor
+}
+
+// parallelStage fans descriptors from in out to workers goroutines running
+// t and writes the results to out in the order they arrived. The
+// dispatcher numbers each descriptor; the reorderer waits for exactly the
+// next number, so at most workers+buffer descriptors are in flight.
+// ChunkIDs pass through untouched and need not be unique.
+//
+// Transforms run concurrently. Encrypt is safe here: cryptoutil.AEAD draws
+// each nonce from an atomic counter, so nonces stay unique, but they are
+// not monotonic in ChunkID order. Every sealed descriptor carries its own
+// nonce, so the receiver does not depend on that order.
+func parallelStage(ctx context.Context, t Transform, in <-chan Descriptor, out chan<- Descriptor, workers, buffer int, fail func(error)) {
+	defer close(out)
+	jobs := make(chan parallelJob, workers)
+	order := make(chan uint64, workers+buffer)
+	results := make(chan parallelJob, workers)
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		defer close(jobs)
+		defer close(order)
+		var seq uint64
+		for d := range in {
+			select {
+			case order <- seq:
+			case <-ctx.Done():
+				return
+			}
+			select {
+			case jobs <- parallelJob{seq: seq, d: d}:
+			case <-ctx.Done():
+				return
+			}
+			seq++
+		}
+	}()
+
+	var workersWG sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		workersWG.Add(1)
+		go func() {
+			defer workersWG.Done()
+			for j := range jobs {
+				nd, err := apply(t, j.d)
+				if err != nil {
+					fail(err)
+					return
+				}
+				select {
+				case results <- parallelJob{seq: j.seq, d: nd}:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		workersWG.Wait()
+		close(results)
+	}()
+	defer wg.Wait()
+
+	pending := make(map[uint64]Descriptor, workers+buffer)
+	for seq := range order {
+		for {
+			if d, ok := pending[seq]; ok {
+				delete(pending, seq)
+				select {
+				case out <- d:
+				case <-ctx.Done():
+					return
+				}
+				break
+			}
+			r, ok := <-results
+			if !ok {
+				return
+			}
+			pending[r.seq] = r.d
+		}
+	}
+}
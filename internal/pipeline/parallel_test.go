@@ -0,0 +1,155 @@
+package pipeline
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"sync"
+	"testing"
+	"time"
+
+	"riptide/internal/cryptoutil"
+)
+
+func TestStreamParallelPreservesOrder(t *testing.T) {
+	const n = 500
+	ds := make([]Descriptor, n)
+	for i := range ds {
+		ds[i] = Descriptor{ChunkID: uint64(i), Data: []byte{byte(i)}}
+	}
+	var mu sync.Mutex
+	rng := rand.New(rand.NewSource(2))
+	jitter := func(d Descriptor) (Descriptor, error) {
+		mu.Lock()
+		delay := time.Duration(rng.Intn(200)) * time.Microsecond
+		mu.Unlock()
+		time.Sleep(delay)
+		return d, nil
+	}
+	var seen []uint64
+	sink := func(_ context.Context, d Descriptor) error {
+		seen = append(seen, d.ChunkID)
+		return nil
+	}
+	if err := StreamParallel(context.Background(), SliceSource(ds), sink, 4, 8, jitter, jitter); err != nil {
+		t.Fatalf("stream: %v", err)
+	}
+	if len(seen) != n {
+		t.Fatalf("sink saw %d descriptors, want %d", len(seen), n)
+	}
+	for i, id := range seen {
+		if id != uint64(i) {
+			t.Fatalf("descriptor %d arrived as %d", i, id)
+		}
+	}
+}
+
+func TestStreamParallelEncryptRoundTrip(t *testing.T) {
+	src := make([]byte, 1<<20+13)
+	rand.New(rand.NewSource(3)).Read(src)
+	enc := testEncryptor(t)
+
+	var sealed []Descriptor
+	collect := func(_ context.Context, d Descriptor) error {
+		sealed = append(sealed, d)
+		return nil
+	}
+	send := Compose(ComputeChecksum(), CompressLZ4(), Encrypt(enc))
+	if err := StreamParallel(context.Background(), ReaderSource(bytes.NewReader(src), 4096), collect, 8, 8, send); err != nil {
+		t.Fatalf("send stream: %v", err)
+	}
+	// Workers seal concurrently, so nonces are not in ChunkID order, but
+	// each must still be used exactly once.
+	nonces := make(map[string]uint64, len(sealed))
+	for _, d := range sealed {
+		nonce := string(d.Data[:12])
+		if prev, dup := nonces[nonce]; dup {
+			t.Fatalf("chunks %d and %d share a nonce", prev, d.ChunkID)
+		}
+		nonces[nonce] = d.ChunkID
+	}
+
+	var out bytes.Buffer
+	recv := Compose(Decrypt(enc), DecompressLZ4(), VerifyChecksum())
+	if err := StreamParallel(context.Background(), SliceSource(sealed), WriterSink(&out), 8, 8, recv); err != nil {
+		t.Fatalf("receive stream: %v", err)
+	}
+	if !bytes.Equal(out.Bytes(), src) {
+		t.Fatalf("round trip mismatch: %d bytes want %d", out.Len(), len(src))
+	}
+}
+
+func TestStreamParallelErrors(t *testing.T) {
+	boom := errors.New("boom")
+	fail := func(d Descriptor) (Descriptor, error) {
+		if d.ChunkID == 40 {
+			return Descriptor{}, boom
+		}
+		return d, nil
+	}
+	var seen []uint64
+	sink := func(_ context.Context, d Descriptor) error {
+		seen = append(seen, d.ChunkID)
+		return nil
+	}
+	err := StreamParallel(context.Background(), ReaderSource(&countingReader{}, 16), sink, 2, 4, fail)
+	if !errors.Is(err, boom) {
+		t.Fatalf("expected stage error, got %v", err)
+	}
+	for i, id := range seen {
+		if id != uint64(i) || id >= 40 {
+			t.Fatalf("sink saw %v", seen)
+		}
+	}
+
+}
+
+func TestStreamParallelOverChunkOutput(t *testing.T) {
+	// Chunk leaves every ChunkID at 0; order must come from arrival alone.
+	src := make([]byte, 64<<10)
+	rand.New(rand.NewSource(8)).Read(src)
+	ds := Chunk(src, 1024)
+	// Uneven work so workers finish out of order.
+	jitter := func(d Descriptor) (Descriptor, error) {
+		if d.Offset/1024%3 == 0 {
+			time.Sleep(time.Millisecond)
+		}
+		return d, nil
+	}
+	var out bytes.Buffer
+	if err := StreamParallel(context.Background(), SliceSource(ds), WriterSink(&out), 4, 4, jitter, ComputeChecksum()); err != nil {
+		t.Fatalf("stream: %v", err)
+	}
+	if !bytes.Equal(out.Bytes(), src) {
+		t.Fatalf("chunks reordered or lost")
+	}
+}
+
+func BenchmarkStreamParallel(b *testing.B) {
+	const chunk = 64 << 10
+	data := make([]byte, 16<<20)
+	rng := rand.New(rand.NewSource(4))
+	// Half random, half zeros so the compressor does real work both ways.
+	rng.Read(data[:len(data)/2])
+	var key [32]byte
+	for _, workers := range []int{1, 2, 4, 8} {
+		b.Run(fmt.Sprintf("workers=%d", workers), func(b *testing.B) {
+			a, err := cryptoutil.NewAEAD(key)
+			if err != nil {
+				b.Fatal(err)
+			}
+			send := Compose(ComputeChecksum(), CompressLZ4(), Encrypt(&Encryptor{AEAD: a}))
+			b.SetBytes(int64(len(data)))
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				err := StreamParallel(context.Background(), ReaderSource(bytes.NewReader(data), chunk),
+					func(context.Context, Descriptor) error { return nil }, 16, workers, send)
+				if err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,7 @@
+//go:build race
+
+package pipeline
+
+// sync.Pool randomly drops items under the race detector, so allocation
+// counts are only checked in normal builds.
+const raceEnabled = true
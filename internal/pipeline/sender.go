@@ -34,16 +34,14 @@ func Chunk(data []byte, chunkSize int) []Descriptor {
 	return out
 }
 
+// ApplyTransforms runs ts over each of ds. The inputs are left untouched
+// and stay owned by the caller.
 func ApplyTransforms(ds []Descriptor, ts ...Transform) ([]Descriptor, error) {
 	out := make([]Descriptor, len(ds))
 	for i := range ds {
-		cur := ds[i]
-		var err error
-		for _, t := range ts {
-			cur, err = t(cur)
-			if err != nil {
-				return nil, err
-			}
+		cur, err := chain(ds[i], ts)
+		if err != nil {
+			return nil, err
 		}
 		out[i] = cur
 	}
@@ -0,0 +1,179 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sync"
+
+	"riptide/internal/bufpool"
+)
+
+// Source emits descriptors into out in ChunkID order and returns when it
+// runs dry (nil) or fails. It must stop promptly when ctx is cancelled.
+type Source func(ctx context.Context, out chan<- Descriptor) error
+
+// Sink consumes descriptors one at a time.
+type Sink func(ctx context.Context, d Descriptor) error
+
+// ReaderSource chunks r into descriptors of chunkSize bytes (the last one
+// may be shorter) without reading more than one chunk ahead of what the
+// downstream stages accept. Chunks are read into pooled buffers.
+func ReaderSource(r io.Reader, chunkSize int) Source {
+	if chunkSize <= 0 {
+		chunkSize = 1
+	}
+	return func(ctx context.Context, out chan<- Descriptor) error {
+		var id, off uint64
+		for {
+			buf := bufpool.Get(chunkSize)
+			n, err := io.ReadFull(r, buf.B)
+			if n > 0 {
+				buf.B = buf.B[:n]
+				d := Descriptor{ChunkID: id, Offset: off, Data: buf.B, Buf: buf}
+				select {
+				case out <- d:
+				case <-ctx.Done():
+					buf.Release()
+					return ctx.Err()
+				}
+				id++
+				off += uint64(n)
+			} else {
+				buf.Release()
+			}
+			switch {
+			case err == io.EOF || err == io.ErrUnexpectedEOF:
+				return nil
+			case err != nil:
+				return err
+			}
+		}
+	}
+}
+
+// SliceSource emits ds as is; handy for tests and small in-memory inputs.
+func SliceSource(ds []Descriptor) Source {
+	return func(ctx context.Context, out chan<- Descriptor) error {
+		for _, d := range ds {
+			select {
+			case out <- d:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+		return nil
+	}
+}
+
+// WriterSink writes each descriptor's data to w in arrival order and then
+// releases it.
+func WriterSink(w io.Writer) Sink {
+	return func(_ context.Context, d Descriptor) error {
+		_, err := w.Write(d.Data)
+		d.Release()
+		return err
+	}
+}
+
+// Stream runs src → stages → sink as a pipeline of goroutines joined by
+// channels holding at most buffer descriptors each, so a slow stage or sink
+// back-pressures the source and memory stays bounded regardless of input
+// size. Every stage handles one descriptor at a time, so the sink sees
+// descriptors in exactly the order the source emitted them. The first error
+// from any stage cancels the rest and is returned; cancelling ctx stops the
+// pipeline with ctx.Err().
+func Stream(ctx context.Context, src Source, sink Sink, buffer int, stages ...Transform) error {
+	return StreamParallel(ctx, src, sink, buffer, 1, stages...)
+}
+
+// StreamParallel is Stream with each stage run by workers goroutines, e.g.
+// cli.Config.Parallel. Descriptors leave every stage in the order they
+// entered it, so the sink's ordering guarantee is unchanged.
+func StreamParallel(ctx context.Context, src Source, sink Sink, buffer, workers int, stages ...Transform) error {
+	if src == nil || sink == nil {
+		return errors.New("nil source or sink")
+	}
+	for _, t := range stages {
+		if t == nil {
+			return errors.New("nil transform")
+		}
+	}
+	if buffer <= 0 {
+		buffer = 1
+	}
+	parent := ctx
+	ctx, cancel := context.WithCancel(parent)
+	defer cancel()
+
+	var (
+		wg       sync.WaitGroup
+		errOnce  sync.Once
+		firstErr error
+	)
+	fail := func(err error) {
+		if err == nil {
+			return
+		}
+		errOnce.Do(func() {
+			firstErr = err
+			cancel()
+		})
+	}
+
+	var in <-chan Descriptor
+	first := make(chan Descriptor, buffer)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		defer close(first)
+		fail(src(ctx, first))
+	}()
+	in = first
+
+	for _, t := range stages {
+		out := make(chan Descriptor, buffer)
+		wg.Add(1)
+		if workers > 1 {
+			go func(t Transform, in <-chan Descriptor, out chan<- Descriptor) {
+				defer wg.Done()
+				parallelStage(ctx, t, in, out, workers, buffer, fail)
+			}(t, in, out)
+			in = out
+			continue
+		}
+		go func(t Transform, in <-chan Descriptor, out chan<- Descriptor) {
+			defer wg.Done()
+			defer close(out)
+			for d := range in {
+				nd, err := apply(t, d)
+				if err != nil {
+					fail(err)
+					return
+				}
+				select {
+				case out <- nd:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}(t, in, out)
+		in = out
+	}
+
+	for d := range in {
+		if err := sink(ctx, d); err != nil {
+			fail(err)
+			break
+		}
+	}
+	cancel()
+	// Drain so upstream goroutines blocked on a full channel can exit.
+	for range in {
+	}
+	wg.Wait()
+	if firstErr != nil {
+		return firstErr
+	}
+	return parent.Err()
+}
@@ -0,0 +1,130 @@
+package pipeline
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"math/rand"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"riptide/internal/cryptoutil"
+)
+
+func testEncryptor(t *testing.T) *Encryptor {
+	t.Helper()
+	var key [32]byte
+	for i := range key {
+		key[i] = byte(i * 7)
+	}
+	a, err := cryptoutil.NewAEAD(key)
+	if err != nil {
+		t.Fatalf("aead: %v", err)
+	}
+	return &Encryptor{AEAD: a, AAD: []byte("stream")}
+}
+
+func TestStreamRoundTripFromReader(t *testing.T) {
+	src := make([]byte, 1<<20+77)
+	rand.New(rand.NewSource(1)).Read(src)
+	enc := testEncryptor(t)
+
+	var sealed []Descriptor
+	collect := func(_ context.Context, d Descriptor) error {
+		sealed = append(sealed, d)
+		return nil
+	}
+	send := Compose(ComputeChecksum(), CompressLZ4(), Encrypt(enc))
+	if err := Stream(context.Background(), ReaderSource(bytes.NewReader(src), 4096), collect, 8, send); err != nil {
+		t.Fatalf("send stream: %v", err)
+	}
+	for i, d := range sealed {
+		if d.ChunkID != uint64(i) || d.Offset != uint64(i*4096) {
+			t.Fatalf("descriptor %d out of order: id %d off %d", i, d.ChunkID, d.Offset)
+		}
+	}
+
+	var out bytes.Buffer
+	recv := []Transform{Decrypt(enc), DecompressLZ4(), VerifyChecksum()}
+	if err := Stream(context.Background(), SliceSource(sealed), WriterSink(&out), 8, recv...); err != nil {
+		t.Fatalf("receive stream: %v", err)
+	}
+	if !bytes.Equal(out.Bytes(), src) {
+		t.Fatalf("round trip mismatch: %d bytes want %d", out.Len(), len(src))
+	}
+}
+
+type countingReader struct {
+	n atomic.Int64
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	r.n.Add(int64(len(p)))
+	return len(p), nil
+}
+
+func TestStreamBackPressureBoundsReads(t *testing.T) {
+	const chunk, buffer = 1024, 4
+	r := &countingReader{}
+	release := make(chan struct{})
+	ctx, cancel := context.WithCancel(context.Background())
+	sink := func(ctx context.Context, d Descriptor) error {
+		select {
+		case <-release:
+		case <-ctx.Done():
+		}
+		return nil
+	}
+	done := make(chan error, 1)
+	go func() {
+		done <- Stream(ctx, ReaderSource(r, chunk), sink, buffer, ComputeChecksum(), ComputeChecksum())
+	}()
+	time.Sleep(50 * time.Millisecond)
+	// One descriptor in the sink, buffer in each of three channels, one in
+	// each stage, and one being read.
+	limit := int64(chunk * (1 + 3*buffer + 2 + 1))
+	if got := r.n.Load(); got > limit {
+		t.Fatalf("read %d bytes with a stalled sink, limit %d", got, limit)
+	}
+	cancel()
+	if err := <-done; !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected cancellation, got %v", err)
+	}
+}
+
+func TestStreamStopsOnFirstError(t *testing.T) {
+	boom := errors.New("boom")
+	fail := func(d Descriptor) (Descriptor, error) {
+		if d.ChunkID == 3 {
+			return Descriptor{}, boom
+		}
+		return d, nil
+	}
+	var seen []uint64
+	sink := func(_ context.Context, d Descriptor) error {
+		seen = append(seen, d.ChunkID)
+		return nil
+	}
+	err := Stream(context.Background(), ReaderSource(io.LimitReader(&countingReader{}, 1<<20), 16), sink, 2, fail)
+	if !errors.Is(err, boom) {
+		t.Fatalf("expected stage error, got %v", err)
+	}
+	for i, id := range seen {
+		if id != uint64(i) || id >= 3 {
+			t.Fatalf("sink saw %v", seen)
+		}
+	}
+
+	sinkErr := errors.New("disk full")
+	err = Stream(context.Background(), ReaderSource(&countingReader{}, 16), func(context.Context, Descriptor) error {
+		return sinkErr
+	}, 2)
+	if !errors.Is(err, sinkErr) {
+		t.Fatalf("expected sink error, got %v", err)
+	}
+	if err := Stream(context.Background(), SliceSource(nil), WriterSink(io.Discard), 1, nil); err == nil {
+		t.Fatalf("expected nil transform error")
+	}
+}
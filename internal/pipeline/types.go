@@ -3,33 +3,85 @@ package pipeline
 import (
 	"errors"
 
+	"riptide/internal/bufpool"
 	"riptide/internal/checksum"
 )
 
+// Descriptor is one chunk moving through the pipeline. When Buf is set,
+// Data lives in that pooled buffer and the descriptor owns one reference.
+// Transforms never release their input: a transform that produces new data
+// returns it in a fresh buffer, and whoever owns the input (a Stream stage,
+// or the caller of Compose or ApplyTransforms) releases it once the output
+// no longer shares its buffer. The sink releases the last reference
+// (WriterSink does). Descriptors built from plain slices leave Buf nil and
+// are left to the garbage collector.
 type Descriptor struct {
 	ChunkID uint64
 	Offset  uint64
 	Data    []byte
 	Sum     checksum.Sum128
+	Buf     *bufpool.Buffer
+}
+
+// Release drops the descriptor's reference to its pooled buffer, if any.
+func (d Descriptor) Release() {
+	d.Buf.Release()
+}
+
+// replace returns d with its data swapped for b. d's own buffer is left to
+// its owner.
+func (d Descriptor) replace(b *bufpool.Buffer) Descriptor {
+	d.Buf = b
+	d.Data = b.B
+	return d
+}
+
+// apply runs t on d, which the caller owns, and releases d's buffer unless
+// the result still uses it. On error d is released too.
+func apply(t Transform, d Descriptor) (Descriptor, error) {
+	nd, err := t(d)
+	if err != nil {
+		d.Release()
+		return Descriptor{}, err
+	}
+	if nd.Buf != d.Buf {
+		d.Release()
+	}
+	return nd, nil
 }
 
 type Transform func(Descriptor) (Descriptor, error)
 
+// Compose chains ts into one transform. Intermediate results are owned by
+// the chain and released as soon as the next transform replaces them; the
+// input stays with the caller like for any other transform.
 func Compose(ts ...Transform) Transform {
 	return func(d Descriptor) (Descriptor, error) {
-		cur := d
-		for _, t := range ts {
-			if t == nil {
-				return Descriptor{}, errors.New("nil transform")
-			}
-			var err error
-			cur, err = t(cur)
+		return chain(d, ts)
+	}
+}
+
+func chain(d Descriptor, ts []Transform) (Descriptor, error) {
+	cur := d
+	for _, t := range ts {
+		if t == nil {
+			return Descriptor{}, errors.New("nil transform")
+		}
+		if cur.Buf == d.Buf {
+			// Still the caller's buffer: not ours to release.
+			next, err := t(cur)
 			if err != nil {
 				return Descriptor{}, err
 			}
+			cur = next
+			continue
+		}
+		var err error
+		if cur, err = apply(t, cur); err != nil {
+			return Descriptor{}, err
 		}
-		return cur, nil
 	}
+	return cur, nil
 }
 
 type Queue[T any] interface {
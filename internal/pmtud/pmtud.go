@@ -0,0 +1,272 @@
+package pmtud
+
+import (
+	"time"
+
+	"riptide/internal/congestion"
+	"riptide/internal/netutil"
+)
+
+// State follows the DPLPMTUD phases of RFC 8899 section 5.2.
+type State uint8
+
+const (
+	StateBase State = iota + 1
+	StateSearch
+	StateSearchComplete
+	StateError
+)
+
+type Config struct {
+	Family        netutil.Family
+	Floor         int
+	Ceiling       int
+	MinStep       int
+	MaxProbes     int
+	ProbeTimeout  time.Duration
+	RaiseInterval time.Duration
+	BlackHoleLoss int
+}
+
+func DefaultConfig(f netutil.Family, ceiling int) Config {
+	return Config{
+		Family:        f,
+		Floor:         f.BaseMTU(),
+		Ceiling:       ceiling,
+		MinStep:       16,
+		MaxProbes:     3,
+		ProbeTimeout:  time.Second,
+		RaiseInterval: 600 * time.Second,
+		BlackHoleLoss: 4,
+	}
+}
+
+// Prober searches for the largest packet size the path delivers. Sizes are
+// IP-level MTUs; callers convert with netutil before building datagrams.
+type Prober struct {
+	cfg        Config
+	state      State
+	plpmtu     int
+	low        int
+	high       int
+	searchHigh int
+	probe      int
+	sentAt     time.Time
+	tries      int
+	raiseAt    time.Time
+	dataLoss   int
+}
+
+func New(cfg Config) *Prober {
+	if cfg.Ceiling <= 0 {
+		cfg.Ceiling = cfg.Floor
+	}
+	if cfg.Floor <= 0 || cfg.Floor > cfg.Ceiling {
+		cfg.Floor = cfg.Ceiling
+	}
+	if cfg.MinStep <= 0 {
+		cfg.MinStep = 1
+	}
+	if cfg.MaxProbes <= 0 {
+		cfg.MaxProbes = 3
+	}
+	if cfg.ProbeTimeout <= 0 {
+		cfg.ProbeTimeout = time.Second
+	}
+	if cfg.RaiseInterval <= 0 {
+		cfg.RaiseInterval = 600 * time.Second
+	}
+	if cfg.BlackHoleLoss <= 0 {
+		cfg.BlackHoleLoss = 4
+	}
+	return &Prober{
+		cfg:        cfg,
+		state:      StateBase,
+		plpmtu:     cfg.Floor,
+		searchHigh: cfg.Ceiling,
+	}
+}
+
+func (p *Prober) State() State { return p.state }
+func (p *Prober) PLPMTU() int  { return p.plpmtu }
+
+// NextProbe reports the size of a probe to send now, if any. A probe that
+// has not been acknowledged within ProbeTimeout is resent at the same size
+// until MaxProbes attempts have failed.
+func (p *Prober) NextProbe(now time.Time) (int, bool) {
+	if p.probe != 0 {
+		if now.Sub(p.sentAt) < p.cfg.ProbeTimeout {
+			return 0, false
+		}
+		if p.tries >= p.cfg.MaxProbes {
+			p.probeFailed(now)
+		} else {
+			p.tries++
+			p.sentAt = now
+			return p.probe, true
+		}
+	}
+	switch p.state {
+	case StateSearchComplete, StateError:
+		if now.Before(p.raiseAt) {
+			return 0, false
+		}
+		if p.state == StateError {
+			p.state = StateBase
+		} else {
+			p.searchHigh = p.cfg.Ceiling
+			p.startSearch(now)
+			if p.state != StateSearch {
+				return 0, false
+			}
+		}
+	}
+	var size int
+	switch p.state {
+	case StateBase:
+		size = p.cfg.Floor
+	case StateSearch:
+		size = p.low + (p.high-p.low+1)/2
+	default:
+		return 0, false
+	}
+	p.probe = size
+	p.tries = 1
+	p.sentAt = now
+	return size, true
+}
+
+func (p *Prober) OnProbeAck(size int, now time.Time) {
+	if size <= 0 || size > p.cfg.Ceiling {
+		return
+	}
+	p.dataLoss = 0
+	if size == p.probe {
+		p.probe = 0
+		p.tries = 0
+	}
+	switch p.state {
+	case StateBase, StateError:
+		if size >= p.cfg.Floor {
+			p.plpmtu = size
+			p.startSearch(now)
+		}
+	case StateSearch:
+		if size > p.low {
+			p.low = size
+			p.plpmtu = size
+		}
+		if p.high-p.low < p.cfg.MinStep {
+			p.complete(now)
+		}
+	}
+}
+
+// OnDataAck resets black hole detection; any delivered packet proves the
+// current PLPMTU still works.
+func (p *Prober) OnDataAck() {
+	p.dataLoss = 0
+}
+
+// OnDataLoss records a lost packet of the given IP-level size. Enough
+// consecutive losses above the floor are treated as a black hole: the
+// PLPMTU falls back to the floor and the search restarts below the size
+// that stopped getting through.
+func (p *Prober) OnDataLoss(size int, now time.Time) {
+	if size <= p.cfg.Floor {
+		return
+	}
+	p.dataLoss++
+	if p.dataLoss < p.cfg.BlackHoleLoss {
+		return
+	}
+	p.dataLoss = 0
+	p.searchHigh = size - 1
+	if p.searchHigh < p.cfg.Floor {
+		p.searchHigh = p.cfg.Floor
+	}
+	p.plpmtu = p.cfg.Floor
+	p.probe = 0
+	p.tries = 0
+	p.state = StateBase
+}
+
+func (p *Prober) startSearch(now time.Time) {
+	p.low = p.plpmtu
+	p.high = p.searchHigh
+	p.probe = 0
+	p.tries = 0
+	if p.high-p.low < p.cfg.MinStep {
+		p.complete(now)
+		return
+	}
+	p.state = StateSearch
+}
+
+func (p *Prober) probeFailed(now time.Time) {
+	size := p.probe
+	p.probe = 0
+	p.tries = 0
+	switch p.state {
+	case StateBase:
+		p.state = StateError
+		p.raiseAt = now.Add(p.cfg.RaiseInterval)
+	case StateSearch:
+		p.high = size - 1
+		if p.high-p.low < p.cfg.MinStep {
+			p.complete(now)
+		}
+	}
+}
+
+func (p *Prober) complete(now time.Time) {
+	p.state = StateSearchComplete
+	p.raiseAt = now.Add(p.cfg.RaiseInterval)
+}
+
+// Sizer derives the DATA payload size from the discovered PLPMTU and the
+// loss-driven congestion.AdjustPayload step. Shrinking takes effect at
+// once so packets stay under the path MTU; growth is held until the next
+// FEC block boundary so every shard in a coding block has the same size.
+type Sizer struct {
+	prober    *Prober
+	headerLen int
+	min       int
+	current   int
+	pending   int
+}
+
+func NewSizer(p *Prober, headerLen int, min int) *Sizer {
+	s := &Sizer{prober: p, headerLen: headerLen, min: min}
+	s.current = s.Max()
+	s.pending = s.current
+	return s
+}
+
+func (s *Sizer) Max() int {
+	return netutil.MaxDataPerPacketFor(s.prober.cfg.Family, s.prober.PLPMTU(), s.headerLen)
+}
+
+func (s *Sizer) Adjust(lossRate float64, corruptionRate float64) {
+	max := s.Max()
+	min := s.min
+	if min > max {
+		min = max
+	}
+	s.pending = congestion.AdjustPayload(s.pending, min, max, lossRate, corruptionRate)
+	if s.pending < s.current {
+		s.current = s.pending
+	}
+}
+
+func (s *Sizer) Payload() int {
+	if max := s.Max(); s.current > max {
+		return max
+	}
+	return s.current
+}
+
+func (s *Sizer) BlockBoundary() int {
+	s.current = s.pending
+	return s.Payload()
+}
@@ -0,0 +1,134 @@
+package pmtud
+
+import (
+	"testing"
+	"time"
+
+	"riptide/internal/netutil"
+)
+
+// runSearch drives the prober against a path that delivers packets up to
+// pathMTU and drops anything larger.
+func runSearch(t *testing.T, p *Prober, pathMTU int, now time.Time) time.Time {
+	t.Helper()
+	for i := 0; i < 200; i++ {
+		size, ok := p.NextProbe(now)
+		if ok && size <= pathMTU {
+			p.OnProbeAck(size, now)
+		}
+		if p.State() == StateSearchComplete || p.State() == StateError {
+			return now
+		}
+		now = now.Add(p.cfg.ProbeTimeout)
+	}
+	t.Fatalf("search did not converge, state=%d plpmtu=%d", p.State(), p.PLPMTU())
+	return now
+}
+
+func TestProberConvergesBetweenFloorAndCeiling(t *testing.T) {
+	p := New(DefaultConfig(netutil.IPv4, 9000))
+	now := runSearch(t, p, 1500, time.Unix(0, 0))
+	if p.State() != StateSearchComplete {
+		t.Fatalf("state = %d", p.State())
+	}
+	if got := p.PLPMTU(); got > 1500 || got < 1500-p.cfg.MinStep {
+		t.Fatalf("plpmtu = %d, want within %d of 1500", got, p.cfg.MinStep)
+	}
+	if _, ok := p.NextProbe(now.Add(time.Second)); ok {
+		t.Fatalf("no probes expected before raise timer")
+	}
+}
+
+func TestProberCeilingBoundsSearch(t *testing.T) {
+	p := New(DefaultConfig(netutil.IPv4, 1400))
+	runSearch(t, p, 9000, time.Unix(0, 0))
+	if got := p.PLPMTU(); got > 1400 || got < 1400-p.cfg.MinStep {
+		t.Fatalf("plpmtu = %d, want near ceiling 1400", got)
+	}
+}
+
+func TestProberBaseFailureEntersError(t *testing.T) {
+	p := New(DefaultConfig(netutil.IPv4, 1500))
+	runSearch(t, p, 1000, time.Unix(0, 0))
+	if p.State() != StateError {
+		t.Fatalf("state = %d, want error", p.State())
+	}
+	if p.PLPMTU() != 1200 {
+		t.Fatalf("plpmtu = %d", p.PLPMTU())
+	}
+}
+
+func TestProberBlackHoleAndReprobe(t *testing.T) {
+	p := New(DefaultConfig(netutil.IPv4, 1500))
+	now := runSearch(t, p, 1500, time.Unix(0, 0))
+	before := p.PLPMTU()
+
+	for i := 0; i < p.cfg.BlackHoleLoss-1; i++ {
+		p.OnDataLoss(before, now)
+	}
+	p.OnDataAck()
+	p.OnDataLoss(before, now)
+	if p.PLPMTU() != before {
+		t.Fatalf("ack should reset black hole detection")
+	}
+
+	for i := 0; i < p.cfg.BlackHoleLoss; i++ {
+		p.OnDataLoss(before, now)
+	}
+	if p.PLPMTU() != p.cfg.Floor || p.State() != StateBase {
+		t.Fatalf("black hole not detected: plpmtu=%d state=%d", p.PLPMTU(), p.State())
+	}
+
+	now = runSearch(t, p, 1300, now)
+	if got := p.PLPMTU(); got > 1300 || got < 1300-p.cfg.MinStep {
+		t.Fatalf("plpmtu after black hole = %d", got)
+	}
+
+	// The raise timer restarts the search up to the ceiling.
+	now = now.Add(p.cfg.RaiseInterval)
+	runSearch(t, p, 1500, now)
+	if got := p.PLPMTU(); got < 1500-p.cfg.MinStep {
+		t.Fatalf("plpmtu after raise = %d", got)
+	}
+}
+
+func TestSizerDefersGrowthToBlockBoundary(t *testing.T) {
+	p := New(DefaultConfig(netutil.IPv4, 1500))
+	runSearch(t, p, 1500, time.Unix(0, 0))
+	s := NewSizer(p, 64, 256)
+	max := netutil.MaxDataPerPacket(p.PLPMTU(), 64)
+	if s.Payload() != max {
+		t.Fatalf("initial payload = %d want %d", s.Payload(), max)
+	}
+
+	s.Adjust(0.05, 0)
+	shrunk := s.Payload()
+	if shrunk >= max {
+		t.Fatalf("loss should shrink payload immediately, got %d", shrunk)
+	}
+	s.Adjust(0, 0)
+	if s.Payload() != shrunk {
+		t.Fatalf("growth must wait for block boundary")
+	}
+	if got := s.BlockBoundary(); got <= shrunk || got > max {
+		t.Fatalf("boundary payload = %d", got)
+	}
+
+	for i := 0; i < p.cfg.BlackHoleLoss; i++ {
+		p.OnDataLoss(p.PLPMTU(), time.Unix(0, 0))
+	}
+	if got := s.Payload(); got != netutil.MaxDataPerPacket(p.cfg.Floor, 64) {
+		t.Fatalf("payload not clamped after black hole: %d", got)
+	}
+}
+
+func TestIPv6FloorAndBudget(t *testing.T) {
+	p := New(DefaultConfig(netutil.IPv6, 1500))
+	if p.PLPMTU() != netutil.IPv6MinMTU {
+		t.Fatalf("ipv6 floor = %d", p.PLPMTU())
+	}
+	s := NewSizer(p, 64, 256)
+	if s.Max() != netutil.MaxDataPerPacketFor(netutil.IPv6, netutil.IPv6MinMTU, 64) {
+		t.Fatalf("ipv6 sizer max = %d", s.Max())
+	}
+}
@@ -6,38 +6,173 @@ import (
 	"riptide/internal/cryptoutil"
 )
 
-const headerLen = 32
 const nonceLen = 12
 
 func EncodeDataPacket(h Header, payload DataPayload, a *cryptoutil.AEAD, aad []byte) ([]byte, error) {
-	hb := h.Encode()
-	pb := payload.Encode()
-	ct, nonce := a.Seal(nil, pb, aad)
-	out := make([]byte, 0, len(hb)+nonceLen+len(ct))
-	out = append(out, hb...)
-	out = append(out, nonce[:]...)
-	out = append(out, ct...)
-	return out, nil
+	return sealPacket(h, payload.Encode(), a, aad), nil
 }
 
 func DecodeDataPacket(b []byte, a *cryptoutil.AEAD, aad []byte) (Header, DataPayload, error) {
-	if len(b) < headerLen+nonceLen {
+	h, pt, err := openPacket(b, a, aad)
+	if err != nil {
+		return Header{}, DataPayload{}, err
+	}
+	dp, err := DecodeDataPayload(pt)
+	if err != nil {
+		return Header{}, DataPayload{}, err
+	}
+	return h, dp, nil
+}
+
+// DataPacketLen is the sealed size of a DATA packet carrying n data bytes.
+func DataPacketLen(n int) int {
+	return HeaderLen + nonceLen + DataPayloadHeaderLen + n + cryptoutil.Overhead()
+}
+
+// AppendDataPacket appends a sealed DATA packet to dst. The payload is
+// encoded straight into dst and sealed in place, so when dst has
+// DataPacketLen spare capacity the packet is built without allocating.
+func AppendDataPacket(dst []byte, h Header, payload DataPayload, a *cryptoutil.AEAD, aad []byte) []byte {
+	if need := DataPacketLen(len(payload.Data)); cap(dst)-len(dst) < need {
+		nd := make([]byte, len(dst), len(dst)+need)
+		copy(nd, dst)
+		dst = nd
+	}
+	start := len(dst)
+	dst = h.AppendEncode(dst)
+	dst = dst[:len(dst)+nonceLen]
+	a.PutNonce(dst[start+HeaderLen:])
+	return sealAppended(payload.AppendEncode(dst), start, a, aad)
+}
+
+// ParseDataPacket is DecodeDataPacket decrypting in place: b is overwritten
+// and the payload's Data aliases it.
+func ParseDataPacket(b []byte, a *cryptoutil.AEAD, aad []byte) (Header, DataPayload, error) {
+	if len(b) < HeaderLen+nonceLen {
 		return Header{}, DataPayload{}, errors.New("short packet")
 	}
 	var h Header
-	if err := h.Decode(b[:headerLen]); err != nil {
+	if err := h.Decode(b[:HeaderLen]); err != nil {
 		return Header{}, DataPayload{}, err
 	}
-	var n [nonceLen]byte
-	copy(n[:], b[headerLen:headerLen+nonceLen])
-	ct := b[headerLen+nonceLen:]
-	pt, err := a.Open(nil, ct, aad, n)
+	ct := b[HeaderLen+nonceLen:]
+	pt, err := a.OpenNonce(ct[:0], b[HeaderLen:HeaderLen+nonceLen], ct, aad)
 	if err != nil {
 		return Header{}, DataPayload{}, err
 	}
-	dp, err := DecodeDataPayload(pt)
+	dp, err := ParseDataPayload(pt)
 	if err != nil {
 		return Header{}, DataPayload{}, err
 	}
 	return h, dp, nil
 }
+
+func EncodeControlPacket(h Header, payload ControlPayload, a *cryptoutil.AEAD, aad []byte) ([]byte, error) {
+	return sealPacket(h, payload.Encode(), a, aad), nil
+}
+
+func EncodeMTUProbePacket(h Header, probe int, datagramLen int, a *cryptoutil.AEAD, aad []byte) ([]byte, error) {
+	if probe <= 0 || probe > 0xffff {
+		return nil, errors.New("invalid probe size")
+	}
+	pb := ControlPayload{MTUProbe: uint16(probe)}.Encode()
+	fixed := HeaderLen + nonceLen + cryptoutil.Overhead()
+	if datagramLen < fixed+len(pb) {
+		return nil, errors.New("probe datagram too small")
+	}
+	padded := make([]byte, datagramLen-fixed)
+	copy(padded, pb)
+	return sealPacket(h, padded, a, aad), nil
+}
+
+func DecodeControlPacket(b []byte, a *cryptoutil.AEAD, aad []byte) (Header, ControlPayload, error) {
+	h, pt, err := openPacket(b, a, aad)
+	if err != nil {
+		return Header{}, ControlPayload{}, err
+	}
+	c, err := DecodeControlPayload(pt)
+	if err != nil {
+		return Header{}, ControlPayload{}, err
+	}
+	return h, c, nil
+}
+
+func EncodePathPacket(h Header, payload PathPayload, a *cryptoutil.AEAD, aad []byte) ([]byte, error) {
+	return sealPacket(h, payload.Encode(), a, aad), nil
+}
+
+func DecodePathPacket(b []byte, a *cryptoutil.AEAD, aad []byte) (Header, PathPayload, error) {
+	h, pt, err := openPacket(b, a, aad)
+	if err != nil {
+		return Header{}, PathPayload{}, err
+	}
+	p, err := DecodePathPayload(pt)
+	if err != nil {
+		return Header{}, PathPayload{}, err
+	}
+	return h, p, nil
+}
+
+func EncodeHeartbeatPacket(h Header, payload HeartbeatPayload, a *cryptoutil.AEAD, aad []byte) ([]byte, error) {
+	return sealPacket(h, payload.Encode(), a, aad), nil
+}
+
+func DecodeHeartbeatPacket(b []byte, a *cryptoutil.AEAD, aad []byte) (Header, HeartbeatPayload, error) {
+	h, pt, err := openPacket(b, a, aad)
+	if err != nil {
+		return Header{}, HeartbeatPayload{}, err
+	}
+	hb, err := DecodeHeartbeatPayload(pt)
+	if err != nil {
+		return Header{}, HeartbeatPayload{}, err
+	}
+	return h, hb, nil
+}
+
+func EncodeFECParityPacket(h Header, payload FECParityPayload, a *cryptoutil.AEAD, aad []byte) ([]byte, error) {
+	return sealPacket(h, payload.Encode(), a, aad), nil
+}
+
+func DecodeFECParityPacket(b []byte, a *cryptoutil.AEAD, aad []byte) (Header, FECParityPayload, error) {
+	h, pt, err := openPacket(b, a, aad)
+	if err != nil {
+		return Header{}, FECParityPayload{}, err
+	}
+	p, err := DecodeFECParityPayload(pt)
+	if err != nil {
+		return Header{}, FECParityPayload{}, err
+	}
+	return h, p, nil
+}
+
+func sealPacket(h Header, pb []byte, a *cryptoutil.AEAD, aad []byte) []byte {
+	out := make([]byte, 0, HeaderLen+nonceLen+len(pb)+cryptoutil.Overhead())
+	out = h.AppendEncode(out)
+	out = out[:HeaderLen+nonceLen]
+	a.PutNonce(out[HeaderLen:])
+	return sealAppended(append(out, pb...), 0, a, aad)
+}
+
+// sealAppended seals, in place, the plaintext that follows the header and
+// nonce of the packet starting at dst[start:].
+func sealAppended(dst []byte, start int, a *cryptoutil.AEAD, aad []byte) []byte {
+	pt := start + HeaderLen + nonceLen
+	return a.SealNonce(dst[:pt], dst[start+HeaderLen:pt], dst[pt:], aad)
+}
+
+func openPacket(b []byte, a *cryptoutil.AEAD, aad []byte) (Header, []byte, error) {
+	if len(b) < HeaderLen+nonceLen {
+		return Header{}, nil, errors.New("short packet")
+	}
+	var h Header
+	if err := h.Decode(b[:HeaderLen]); err != nil {
+		return Header{}, nil, err
+	}
+	var n [nonceLen]byte
+	copy(n[:], b[HeaderLen:HeaderLen+nonceLen])
+	pt, err := a.Open(nil, b[HeaderLen+nonceLen:], aad, n)
+	if err != nil {
+		return Header{}, nil, err
+	}
+	return h, pt, nil
+}
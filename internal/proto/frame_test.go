@@ -0,0 +1,125 @@
+package proto
+
+import (
+	"bytes"
+	"testing"
+
+	"riptide/internal/checksum"
+	"riptide/internal/cryptoutil"
+)
+
+func testAEAD(t *testing.T) *cryptoutil.AEAD {
+	var key [32]byte
+	for i := range key {
+		key[i] = byte(i + 3)
+	}
+	a, err := cryptoutil.NewAEAD(key)
+	if err != nil {
+		t.Fatalf("aead: %v", err)
+	}
+	return a
+}
+
+func TestDataPacketRoundTrip(t *testing.T) {
+	a := testAEAD(t)
+	data := []byte("payload")
+	h := Header{Version: Version, Type: TypeData, Seq: 4}
+	dp := DataPayload{ChunkID: 1, Offset: 2, Checksum: checksum.Compute128(data), Data: data}
+	pkt, err := EncodeDataPacket(h, dp, a, []byte("aad"))
+	if err != nil {
+		t.Fatalf("encode: %v", err)
+	}
+	gh, gd, err := DecodeDataPacket(pkt, a, []byte("aad"))
+	if err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if gh.Seq != 4 || gd.ChunkID != 1 || !bytes.Equal(gd.Data, data) {
+		t.Fatalf("mismatch")
+	}
+}
+
+func TestAppendDataPacketInPlace(t *testing.T) {
+	a := testAEAD(t)
+	aad := []byte("aad")
+	data := bytes.Repeat([]byte{0x5a}, 1200)
+	h := Header{Version: Version, Type: TypeData, Seq: 9}
+	dp := DataPayload{ChunkID: 3, Offset: 4, Checksum: checksum.Compute128(data), Data: data}
+
+	buf := make([]byte, 0, DataPacketLen(len(data)))
+	pkt := AppendDataPacket(buf, h, dp, a, aad)
+	if len(pkt) != DataPacketLen(len(data)) || &pkt[0] != &buf[:1][0] {
+		t.Fatalf("packet len %d, reused buffer %v", len(pkt), &pkt[0] == &buf[:1][0])
+	}
+	if _, gd, err := DecodeDataPacket(pkt, a, aad); err != nil || !bytes.Equal(gd.Data, data) {
+		t.Fatalf("append-encoded packet does not decode: %v", err)
+	}
+	gh, gd, err := ParseDataPacket(pkt, a, aad)
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	if gh.Seq != 9 || gd.ChunkID != 3 || gd.Offset != 4 || !bytes.Equal(gd.Data, data) {
+		t.Fatalf("mismatch")
+	}
+
+	allocs := testing.AllocsPerRun(200, func() {
+		pkt := AppendDataPacket(buf, h, dp, a, aad)
+		if _, _, err := ParseDataPacket(pkt, a, aad); err != nil {
+			t.Fatal(err)
+		}
+	})
+	if allocs != 0 {
+		t.Fatalf("%.1f allocs per packet", allocs)
+	}
+}
+
+func TestMTUProbePacketExactSize(t *testing.T) {
+	a := testAEAD(t)
+	h := Header{Version: Version, Type: TypeControl, Seq: 9}
+	pkt, err := EncodeMTUProbePacket(h, 1400, 1372, a, nil)
+	if err != nil {
+		t.Fatalf("encode: %v", err)
+	}
+	if len(pkt) != 1372 {
+		t.Fatalf("probe datagram len = %d want 1372", len(pkt))
+	}
+	gh, c, err := DecodeControlPacket(pkt, a, nil)
+	if err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if gh.Type != TypeControl || c.MTUProbe != 1400 {
+		t.Fatalf("mismatch: %+v %+v", gh, c)
+	}
+	if _, err := EncodeMTUProbePacket(h, 1400, 40, a, nil); err == nil {
+		t.Fatalf("expected error for undersized probe")
+	}
+}
+
+func TestControlPacketRoundTrip(t *testing.T) {
+	a := testAEAD(t)
+	h := Header{Version: Version, Type: TypeControl, Seq: 1}
+	c := ControlPayload{WindowSize: 8, MTUProbe: 1280}
+	pkt, err := EncodeControlPacket(h, c, a, nil)
+	if err != nil {
+		t.Fatalf("encode: %v", err)
+	}
+	_, got, err := DecodeControlPacket(pkt, a, nil)
+	if err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if got != c {
+		t.Fatalf("mismatch")
+	}
+}
+
+func TestHeartbeatPacketRoundTrip(t *testing.T) {
+	a := testAEAD(t)
+	h := Header{Version: Version, Type: TypeHeartbeat, ConnID: 5}
+	pkt, err := EncodeHeartbeatPacket(h, HeartbeatPayload{Seq: 77}, a, nil)
+	if err != nil {
+		t.Fatalf("encode: %v", err)
+	}
+	gh, hb, err := DecodeHeartbeatPacket(pkt, a, nil)
+	if err != nil || gh.ConnID != 5 || hb.Seq != 77 {
+		t.Fatalf("mismatch: %+v %+v %v", gh, hb, err)
+	}
+}
@@ -6,10 +6,12 @@ import (
 	"hash/crc32"
 
 	"riptide/internal/checksum"
+	"riptide/internal/netutil"
 )
 
 const (
-	Version uint8 = 1
+	Version   uint8 = 2
+	HeaderLen       = 40
 )
 
 type Type uint8
@@ -27,12 +29,28 @@ const (
 	TypeFECParity
 	TypeHeartbeat
 	TypeClose
+	TypePathChallenge
+	TypePathResponse
 )
 
+// FlagFECRepaired is set on an ACK whose DATA packet was lost on the wire
+// and rebuilt from parity, so the sender's FEC controller sees the raw
+// channel loss that the ACK would otherwise hide.
+const FlagFECRepaired uint16 = 1 << 0
+
+// FlagBlockDecoded is set on an ACK whose Seq is the BlockID of a rateless
+// (fountain) FEC block the receiver has fully decoded; the sender stops
+// generating repair symbols for it.
+const FlagBlockDecoded uint16 = 1 << 1
+
+// Header carries a connection ID chosen by the receiving endpoint so a
+// session is identified independently of the peer's address and survives
+// NAT rebinding or a switch between networks.
 type Header struct {
 	Version   uint8
 	Type      Type
 	Flags     uint16
+	ConnID    uint64
 	Seq       uint64
 	Total     uint64
 	Timestamp uint64
@@ -40,32 +58,52 @@ type Header struct {
 }
 
 func (h *Header) Encode() []byte {
-	b := make([]byte, 32)
+	return h.AppendEncode(make([]byte, 0, HeaderLen))
+}
+
+// AppendEncode appends the encoded header to dst, growing it only when it
+// lacks HeaderLen bytes of spare capacity.
+func (h *Header) AppendEncode(dst []byte) []byte {
+	dst, b := grow(dst, HeaderLen)
 	b[0] = h.Version
 	b[1] = byte(h.Type)
 	binary.BigEndian.PutUint16(b[2:4], h.Flags)
-	binary.BigEndian.PutUint64(b[4:12], h.Seq)
-	binary.BigEndian.PutUint64(b[12:20], h.Total)
-	binary.BigEndian.PutUint64(b[20:28], h.Timestamp)
-	binary.BigEndian.PutUint32(b[28:32], 0)
-	cs := crc32.ChecksumIEEE(b[:28])
-	binary.BigEndian.PutUint32(b[28:32], cs)
+	binary.BigEndian.PutUint64(b[4:12], h.ConnID)
+	binary.BigEndian.PutUint64(b[12:20], h.Seq)
+	binary.BigEndian.PutUint64(b[20:28], h.Total)
+	binary.BigEndian.PutUint64(b[28:36], h.Timestamp)
+	binary.BigEndian.PutUint32(b[36:40], 0)
+	cs := crc32.ChecksumIEEE(b[:36])
+	binary.BigEndian.PutUint32(b[36:40], cs)
 	h.Checksum = cs
-	return b
+	return dst
+}
+
+// grow extends dst by n bytes and returns it along with the new tail.
+func grow(dst []byte, n int) ([]byte, []byte) {
+	l := len(dst)
+	if cap(dst)-l < n {
+		nd := make([]byte, l, l+n)
+		copy(nd, dst)
+		dst = nd
+	}
+	dst = dst[:l+n]
+	return dst, dst[l:]
 }
 
 func (h *Header) Decode(b []byte) error {
-	if len(b) < 32 {
+	if len(b) < HeaderLen {
 		return errors.New("short header")
 	}
 	h.Version = b[0]
 	h.Type = Type(b[1])
 	h.Flags = binary.BigEndian.Uint16(b[2:4])
-	h.Seq = binary.BigEndian.Uint64(b[4:12])
-	h.Total = binary.BigEndian.Uint64(b[12:20])
-	h.Timestamp = binary.BigEndian.Uint64(b[20:28])
-	got := binary.BigEndian.Uint32(b[28:32])
-	calc := crc32.ChecksumIEEE(b[:28])
+	h.ConnID = binary.BigEndian.Uint64(b[4:12])
+	h.Seq = binary.BigEndian.Uint64(b[12:20])
+	h.Total = binary.BigEndian.Uint64(b[20:28])
+	h.Timestamp = binary.BigEndian.Uint64(b[28:36])
+	got := binary.BigEndian.Uint32(b[36:40])
+	calc := crc32.ChecksumIEEE(b[:36])
 	if got != calc {
 		return errors.New("bad header checksum")
 	}
@@ -73,16 +111,64 @@ func (h *Header) Decode(b []byte) error {
 	return nil
 }
 
+// PeekConnID reads the connection ID without verifying the header, for
+// demultiplexing before a session is looked up.
+func PeekConnID(b []byte) (uint64, bool) {
+	if len(b) < HeaderLen {
+		return 0, false
+	}
+	return binary.BigEndian.Uint64(b[4:12]), true
+}
+
 type Ack struct {
 	Seq uint64
 	Sum checksum.Sum128
+	ECN ECNCounts
+}
+
+// ECNCounts are the receiver's cumulative counts of packets seen with each
+// ECN codepoint, echoed in ACKs so the sender can react to CE marks.
+type ECNCounts struct {
+	ECT0 uint64
+	ECT1 uint64
+	CE   uint64
+}
+
+func (c *ECNCounts) Record(e netutil.ECN) {
+	switch e {
+	case netutil.ECNECT0:
+		c.ECT0++
+	case netutil.ECNECT1:
+		c.ECT1++
+	case netutil.ECNCE:
+		c.CE++
+	}
+}
+
+func (c ECNCounts) Total() uint64 {
+	return c.ECT0 + c.ECT1 + c.CE
 }
 
+// Encode appends the ECN counts only when any are set, so ACKs from peers
+// without ECN keep the original 24-byte layout.
 func (a Ack) Encode() []byte {
-	b := make([]byte, 24)
+	return a.AppendEncode(nil)
+}
+
+func (a Ack) AppendEncode(dst []byte) []byte {
+	l := 24
+	if a.ECN != (ECNCounts{}) {
+		l = 48
+	}
+	dst, b := grow(dst, l)
 	binary.BigEndian.PutUint64(b[:8], a.Seq)
 	copy(b[8:24], a.Sum[:])
-	return b
+	if l == 48 {
+		binary.BigEndian.PutUint64(b[24:32], a.ECN.ECT0)
+		binary.BigEndian.PutUint64(b[32:40], a.ECN.ECT1)
+		binary.BigEndian.PutUint64(b[40:48], a.ECN.CE)
+	}
+	return dst
 }
 
 func DecodeAck(b []byte) (Ack, error) {
@@ -92,6 +178,11 @@ func DecodeAck(b []byte) (Ack, error) {
 	var a Ack
 	a.Seq = binary.BigEndian.Uint64(b[:8])
 	copy(a.Sum[:], b[8:24])
+	if len(b) >= 48 {
+		a.ECN.ECT0 = binary.BigEndian.Uint64(b[24:32])
+		a.ECN.ECT1 = binary.BigEndian.Uint64(b[32:40])
+		a.ECN.CE = binary.BigEndian.Uint64(b[40:48])
+	}
 	return a, nil
 }
 
@@ -102,11 +193,15 @@ type Nak struct {
 }
 
 func (n Nak) Encode() []byte {
-	b := make([]byte, 26)
+	return n.AppendEncode(nil)
+}
+
+func (n Nak) AppendEncode(dst []byte) []byte {
+	dst, b := grow(dst, 26)
 	binary.BigEndian.PutUint64(b[:8], n.Seq)
 	copy(b[8:24], n.Sum[:])
 	binary.BigEndian.PutUint16(b[24:26], n.Code)
-	return b
+	return dst
 }
 
 func DecodeNak(b []byte) (Nak, error) {
@@ -127,26 +222,41 @@ type DataPayload struct {
 	Data     []byte
 }
 
+// DataPayloadHeaderLen is the encoded size of a DataPayload before Data.
+const DataPayloadHeaderLen = 32
+
 func (d DataPayload) Encode() []byte {
-	l := 8 + 8 + 16 + len(d.Data)
-	b := make([]byte, l)
+	return d.AppendEncode(nil)
+}
+
+func (d DataPayload) AppendEncode(dst []byte) []byte {
+	dst, b := grow(dst, DataPayloadHeaderLen+len(d.Data))
 	binary.BigEndian.PutUint64(b[:8], d.ChunkID)
 	binary.BigEndian.PutUint64(b[8:16], d.Offset)
 	copy(b[16:32], d.Checksum[:])
 	copy(b[32:], d.Data)
-	return b
+	return dst
 }
 
 func DecodeDataPayload(b []byte) (DataPayload, error) {
-	if len(b) < 32 {
+	d, err := ParseDataPayload(b)
+	if err != nil {
+		return DataPayload{}, err
+	}
+	d.Data = append([]byte(nil), d.Data...)
+	return d, nil
+}
+
+// ParseDataPayload is DecodeDataPayload without the copy: Data aliases b.
+func ParseDataPayload(b []byte) (DataPayload, error) {
+	if len(b) < DataPayloadHeaderLen {
 		return DataPayload{}, errors.New("short data")
 	}
 	var d DataPayload
 	d.ChunkID = binary.BigEndian.Uint64(b[:8])
 	d.Offset = binary.BigEndian.Uint64(b[8:16])
 	copy(d.Checksum[:], b[16:32])
-	d.Data = make([]byte, len(b)-32)
-	copy(d.Data, b[32:])
+	d.Data = b[32:]
 	return d, nil
 }
 
@@ -155,9 +265,11 @@ type HeartbeatPayload struct {
 }
 
 func (h HeartbeatPayload) Encode() []byte {
-	b := make([]byte, 8)
-	binary.BigEndian.PutUint64(b[:8], h.Seq)
-	return b
+	return h.AppendEncode(nil)
+}
+
+func (h HeartbeatPayload) AppendEncode(dst []byte) []byte {
+	return binary.BigEndian.AppendUint64(dst, h.Seq)
 }
 
 func DecodeHeartbeatPayload(b []byte) (HeartbeatPayload, error) {
@@ -172,9 +284,11 @@ type AckAck struct {
 }
 
 func (a AckAck) Encode() []byte {
-	b := make([]byte, 8)
-	binary.BigEndian.PutUint64(b[:8], a.Seq)
-	return b
+	return a.AppendEncode(nil)
+}
+
+func (a AckAck) AppendEncode(dst []byte) []byte {
+	return binary.BigEndian.AppendUint64(dst, a.Seq)
 }
 
 func DecodeAckAck(b []byte) (AckAck, error) {
@@ -184,22 +298,40 @@ func DecodeAckAck(b []byte) (AckAck, error) {
 	return AckAck{Seq: binary.BigEndian.Uint64(b[:8])}, nil
 }
 
+// ControlPayload optionally announces the sender's FEC profile: FECData
+// and FECParity are the shard counts it uses from the next coding block on.
+// They are only encoded when FECData is set, so plain control frames keep
+// the original 20-byte layout.
 type ControlPayload struct {
 	WindowSize uint32
 	PacingRate uint32
 	RTT        uint64
 	LossRate   uint16
 	MTUProbe   uint16
+	FECData    uint16
+	FECParity  uint16
 }
 
 func (c ControlPayload) Encode() []byte {
-	b := make([]byte, 20)
+	return c.AppendEncode(nil)
+}
+
+func (c ControlPayload) AppendEncode(dst []byte) []byte {
+	l := 20
+	if c.FECData != 0 {
+		l = 24
+	}
+	dst, b := grow(dst, l)
 	binary.BigEndian.PutUint32(b[0:4], c.WindowSize)
 	binary.BigEndian.PutUint32(b[4:8], c.PacingRate)
 	binary.BigEndian.PutUint64(b[8:16], c.RTT)
 	binary.BigEndian.PutUint16(b[16:18], c.LossRate)
 	binary.BigEndian.PutUint16(b[18:20], c.MTUProbe)
-	return b
+	if l == 24 {
+		binary.BigEndian.PutUint16(b[20:22], c.FECData)
+		binary.BigEndian.PutUint16(b[22:24], c.FECParity)
+	}
+	return dst
 }
 
 func DecodeControlPayload(b []byte) (ControlPayload, error) {
@@ -212,34 +344,78 @@ func DecodeControlPayload(b []byte) (ControlPayload, error) {
 	c.RTT = binary.BigEndian.Uint64(b[8:16])
 	c.LossRate = binary.BigEndian.Uint16(b[16:18])
 	c.MTUProbe = binary.BigEndian.Uint16(b[18:20])
+	if len(b) >= 24 {
+		c.FECData = binary.BigEndian.Uint16(b[20:22])
+		c.FECParity = binary.BigEndian.Uint16(b[22:24])
+	}
 	return c, nil
 }
 
+// FECParityPayload carries one parity shard of a coding block. BlockID is
+// the sequence number of the block's first DATA packet; the block covers
+// DataShards sequence numbers from there, Stride apart (1 for consecutive
+// packets, the interleaving depth otherwise). Index is the shard's position
+// in the block (parity shards start at DataShards) and Total is the data
+// plus parity shard count.
 type FECParityPayload struct {
-	BlockID uint64
-	Index   uint16
-	Total   uint16
-	Parity  []byte
+	BlockID    uint64
+	Index      uint16
+	Total      uint16
+	DataShards uint16
+	Stride     uint16
+	Parity     []byte
 }
 
 func (p FECParityPayload) Encode() []byte {
-	b := make([]byte, 12+len(p.Parity))
+	return p.AppendEncode(nil)
+}
+
+func (p FECParityPayload) AppendEncode(dst []byte) []byte {
+	dst, b := grow(dst, 16+len(p.Parity))
 	binary.BigEndian.PutUint64(b[0:8], p.BlockID)
 	binary.BigEndian.PutUint16(b[8:10], p.Index)
 	binary.BigEndian.PutUint16(b[10:12], p.Total)
-	copy(b[12:], p.Parity)
-	return b
+	binary.BigEndian.PutUint16(b[12:14], p.DataShards)
+	binary.BigEndian.PutUint16(b[14:16], p.Stride)
+	copy(b[16:], p.Parity)
+	return dst
 }
 
 func DecodeFECParityPayload(b []byte) (FECParityPayload, error) {
-	if len(b) < 12 {
+	if len(b) < 16 {
 		return FECParityPayload{}, errors.New("short fec_parity")
 	}
 	var p FECParityPayload
 	p.BlockID = binary.BigEndian.Uint64(b[0:8])
 	p.Index = binary.BigEndian.Uint16(b[8:10])
 	p.Total = binary.BigEndian.Uint16(b[10:12])
-	p.Parity = make([]byte, len(b)-12)
-	copy(p.Parity, b[12:])
+	p.DataShards = binary.BigEndian.Uint16(b[12:14])
+	p.Stride = binary.BigEndian.Uint16(b[14:16])
+	if p.Stride == 0 {
+		p.Stride = 1
+	}
+	p.Parity = make([]byte, len(b)-16)
+	copy(p.Parity, b[16:])
+	return p, nil
+}
+
+type PathPayload struct {
+	Data [8]byte
+}
+
+func (p PathPayload) Encode() []byte {
+	return p.AppendEncode(nil)
+}
+
+func (p PathPayload) AppendEncode(dst []byte) []byte {
+	return append(dst, p.Data[:]...)
+}
+
+func DecodePathPayload(b []byte) (PathPayload, error) {
+	if len(b) < 8 {
+		return PathPayload{}, errors.New("short path payload")
+	}
+	var p PathPayload
+	copy(p.Data[:], b[:8])
 	return p, nil
 }
@@ -5,6 +5,7 @@ import (
 	"testing"
 
 	"riptide/internal/checksum"
+	"riptide/internal/netutil"
 )
 
 func TestHeaderEncodeDecode(t *testing.T) {
@@ -12,11 +13,18 @@ func TestHeaderEncodeDecode(t *testing.T) {
 		Version:   Version,
 		Type:      TypeData,
 		Flags:     3,
+		ConnID:    0xfeedface,
 		Seq:       123,
 		Total:     456,
 		Timestamp: 789,
 	}
 	enc := h.Encode()
+	if len(enc) != HeaderLen {
+		t.Fatalf("header len = %d", len(enc))
+	}
+	if id, ok := PeekConnID(enc); !ok || id != h.ConnID {
+		t.Fatalf("peek conn id = %x", id)
+	}
 	var dec Header
 	if err := dec.Decode(enc); err != nil {
 		t.Fatalf("decode error: %v", err)
@@ -44,6 +52,31 @@ func TestAckEncodeDecode(t *testing.T) {
 	}
 }
 
+func TestAckECNCounts(t *testing.T) {
+	var c ECNCounts
+	for _, e := range []netutil.ECN{netutil.ECNECT0, netutil.ECNECT0, netutil.ECNCE, netutil.ECNNotECT, netutil.ECNECT1} {
+		c.Record(e)
+	}
+	if c != (ECNCounts{ECT0: 2, ECT1: 1, CE: 1}) || c.Total() != 4 {
+		t.Fatalf("counts mismatch: %+v", c)
+	}
+	a := Ack{Seq: 3, ECN: c}
+	enc := a.Encode()
+	if len(enc) != 48 {
+		t.Fatalf("ecn ack len = %d", len(enc))
+	}
+	out, err := DecodeAck(enc)
+	if err != nil {
+		t.Fatalf("decode err: %v", err)
+	}
+	if out != a {
+		t.Fatalf("mismatch: %+v", out)
+	}
+	if plain := (Ack{Seq: 3}).Encode(); len(plain) != 24 {
+		t.Fatalf("ack without ecn should stay 24 bytes, got %d", len(plain))
+	}
+}
+
 func TestNakEncodeDecode(t *testing.T) {
 	s := checksum.Compute128([]byte("y"))
 	n := Nak{Seq: 9, Sum: s, Code: 2}
@@ -116,22 +149,46 @@ func TestControlPayloadEncodeDecode(t *testing.T) {
 	if out != c {
 		t.Fatalf("mismatch")
 	}
+	if len(enc) != 20 {
+		t.Fatalf("control without fec profile should stay 20 bytes, got %d", len(enc))
+	}
+	c.FECData, c.FECParity = 16, 3
+	out, err = DecodeControlPayload(c.Encode())
+	if err != nil || out != c {
+		t.Fatalf("fec profile mismatch: %+v %v", out, err)
+	}
 }
 
 func TestFECParityPayloadEncodeDecode(t *testing.T) {
 	parity := []byte{1, 2, 3, 4, 5}
 	p := FECParityPayload{
-		BlockID: 55,
-		Index:   2,
-		Total:   8,
-		Parity:  parity,
+		BlockID:    55,
+		Index:      2,
+		Total:      8,
+		DataShards: 6,
+		Stride:     3,
+		Parity:     parity,
 	}
 	enc := p.Encode()
 	out, err := DecodeFECParityPayload(enc)
 	if err != nil {
 		t.Fatalf("decode err: %v", err)
 	}
-	if out.BlockID != p.BlockID || out.Index != p.Index || out.Total != p.Total || !bytes.Equal(out.Parity, p.Parity) {
+	if out.BlockID != p.BlockID || out.Index != p.Index || out.Total != p.Total || out.DataShards != p.DataShards || out.Stride != p.Stride || !bytes.Equal(out.Parity, p.Parity) {
+		t.Fatalf("mismatch")
+	}
+}
+
+func TestPathPayloadEncodeDecode(t *testing.T) {
+	p := PathPayload{Data: [8]byte{1, 2, 3, 4, 5, 6, 7, 8}}
+	out, err := DecodePathPayload(p.Encode())
+	if err != nil {
+		t.Fatalf("decode err: %v", err)
+	}
+	if out != p {
 		t.Fatalf("mismatch")
 	}
+	if _, err := DecodePathPayload([]byte{1}); err == nil {
+		t.Fatalf("expected short error")
+	}
 }
@@ -0,0 +1,221 @@
+package queue
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+)
+
+// MPMCRing is a bounded lock-free queue safe for any number of producers
+// and consumers (Vyukov's design). Each slot carries a sequence number that
+// says whose turn it is: seq == pos means free for the producer claiming
+// pos, seq == pos+1 means filled for the consumer claiming pos. Producers
+// and consumers claim positions by CAS on head and tail and only touch the
+// slot they won, so there is no shared lock and no ABA problem.
+type MPMCRing[T any] struct {
+	_     [64]byte
+	mask  uint64
+	slots []mpmcSlot[T]
+	_     [64]byte
+	head  atomic.Uint64
+	_     [64]byte
+	tail  atomic.Uint64
+	_     [64]byte
+}
+
+type mpmcSlot[T any] struct {
+	seq atomic.Uint64
+	val T
+}
+
+// NewMPMCRing rounds size up to a power of two, with a minimum of two (a
+// single slot cannot tell full from empty by sequence alone).
+func NewMPMCRing[T any](size int) *MPMCRing[T] {
+	if size < 2 {
+		size = 2
+	}
+	c := nextPow2(size)
+	r := &MPMCRing[T]{mask: uint64(c - 1), slots: make([]mpmcSlot[T], c)}
+	for i := range r.slots {
+		r.slots[i].seq.Store(uint64(i))
+	}
+	return r
+}
+
+func (r *MPMCRing[T]) Cap() int {
+	return len(r.slots)
+}
+
+// Len is a snapshot and may be stale by the time it returns.
+func (r *MPMCRing[T]) Len() int {
+	t := r.tail.Load()
+	h := r.head.Load()
+	if h < t {
+		return 0
+	}
+	return int(h - t)
+}
+
+func (r *MPMCRing[T]) Enqueue(v T) bool {
+	pos := r.head.Load()
+	for {
+		s := &r.slots[pos&r.mask]
+		seq := s.seq.Load()
+		switch diff := int64(seq - pos); {
+		case diff == 0:
+			if r.head.CompareAndSwap(pos, pos+1) {
+				s.val = v
+				s.seq.Store(pos + 1)
+				return true
+			}
+			pos = r.head.Load()
+		case diff < 0:
+			// The slot still holds the value from a lap ago: full.
+			return false
+		default:
+			pos = r.head.Load()
+		}
+	}
+}
+
+func (r *MPMCRing[T]) Dequeue() (T, bool) {
+	var zero T
+	pos := r.tail.Load()
+	for {
+		s := &r.slots[pos&r.mask]
+		seq := s.seq.Load()
+		switch diff := int64(seq - (pos + 1)); {
+		case diff == 0:
+			if r.tail.CompareAndSwap(pos, pos+1) {
+				v := s.val
+				s.val = zero
+				s.seq.Store(pos + r.mask + 1)
+				return v, true
+			}
+			pos = r.tail.Load()
+		case diff < 0:
+			return zero, false
+		default:
+			pos = r.tail.Load()
+		}
+	}
+}
+
+// BlockingRing adds EnqueueWait and DequeueWait to an MPMCRing. Callers
+// that find the ring full or empty park on a channel instead of spinning;
+// the other side only takes the wakeup lock when someone is parked, so the
+// uncontended path costs one atomic load on top of the ring operation.
+type BlockingRing[T any] struct {
+	r        *MPMCRing[T]
+	notEmpty notifier
+	notFull  notifier
+}
+
+func NewBlockingRing[T any](size int) *BlockingRing[T] {
+	return &BlockingRing[T]{r: NewMPMCRing[T](size)}
+}
+
+func (b *BlockingRing[T]) Cap() int { return b.r.Cap() }
+func (b *BlockingRing[T]) Len() int { return b.r.Len() }
+
+// Enqueue is the non-blocking form; it wakes parked consumers on success.
+func (b *BlockingRing[T]) Enqueue(v T) bool {
+	if !b.r.Enqueue(v) {
+		return false
+	}
+	b.notEmpty.broadcast()
+	return true
+}
+
+// Dequeue is the non-blocking form; it wakes parked producers on success.
+func (b *BlockingRing[T]) Dequeue() (T, bool) {
+	v, ok := b.r.Dequeue()
+	if ok {
+		b.notFull.broadcast()
+	}
+	return v, ok
+}
+
+// EnqueueWait blocks until v is queued or ctx is done.
+func (b *BlockingRing[T]) EnqueueWait(ctx context.Context, v T) error {
+	for {
+		if b.Enqueue(v) {
+			return nil
+		}
+		ch := b.notFull.park()
+		// Re-check after parking: a consumer that freed a slot before we
+		// registered would not have woken us.
+		if b.Enqueue(v) {
+			b.notFull.unpark()
+			return nil
+		}
+		select {
+		case <-ch:
+			b.notFull.unpark()
+		case <-ctx.Done():
+			b.notFull.unpark()
+			return ctx.Err()
+		}
+	}
+}
+
+// DequeueWait blocks until a value is available or ctx is done.
+func (b *BlockingRing[T]) DequeueWait(ctx context.Context) (T, error) {
+	for {
+		if v, ok := b.Dequeue(); ok {
+			return v, nil
+		}
+		ch := b.notEmpty.park()
+		if v, ok := b.Dequeue(); ok {
+			b.notEmpty.unpark()
+			return v, nil
+		}
+		select {
+		case <-ch:
+			b.notEmpty.unpark()
+		case <-ctx.Done():
+			b.notEmpty.unpark()
+			var zero T
+			return zero, ctx.Err()
+		}
+	}
+}
+
+// notifier wakes every parked goroutine by closing the current channel.
+// Woken goroutines retry their operation, so a spurious wakeup only costs
+// a loop iteration.
+type notifier struct {
+	waiters atomic.Int32
+	mu      sync.Mutex
+	ch      chan struct{}
+}
+
+// park registers the caller as a waiter and returns the channel to wait
+// on. The caller must re-check its condition before blocking and call
+// unpark once it stops waiting.
+func (n *notifier) park() <-chan struct{} {
+	n.waiters.Add(1)
+	n.mu.Lock()
+	if n.ch == nil {
+		n.ch = make(chan struct{})
+	}
+	ch := n.ch
+	n.mu.Unlock()
+	return ch
+}
+
+func (n *notifier) unpark() {
+	n.waiters.Add(-1)
+}
+
+func (n *notifier) broadcast() {
+	if n.waiters.Load() == 0 {
+		return
+	}
+	n.mu.Lock()
+	if n.ch != nil {
+		close(n.ch)
+		n.ch = nil
+	}
+	n.mu.Unlock()
+}
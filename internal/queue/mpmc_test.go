@@ -0,0 +1,275 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestMPMCRingBasic(t *testing.T) {
+	r := NewMPMCRing[int](3)
+	if r.Cap() != 4 {
+		t.Fatalf("cap %d want 4", r.Cap())
+	}
+	if _, ok := r.Dequeue(); ok {
+		t.Fatalf("expected empty")
+	}
+	for lap := 0; lap < 3; lap++ {
+		for i := 0; i < 4; i++ {
+			if !r.Enqueue(lap*10 + i) {
+				t.Fatalf("lap %d enqueue %d", lap, i)
+			}
+		}
+		if r.Enqueue(99) {
+			t.Fatalf("lap %d: enqueue into full ring", lap)
+		}
+		if r.Len() != 4 {
+			t.Fatalf("len %d", r.Len())
+		}
+		for i := 0; i < 4; i++ {
+			v, ok := r.Dequeue()
+			if !ok || v != lap*10+i {
+				t.Fatalf("lap %d dequeue %d: %d %v", lap, i, v, ok)
+			}
+		}
+		if _, ok := r.Dequeue(); ok {
+			t.Fatalf("lap %d: expected empty", lap)
+		}
+	}
+	if NewMPMCRing[int](1).Cap() != 2 {
+		t.Fatalf("minimum capacity is 2")
+	}
+}
+
+// stressMPMC runs producers × perProducer values through enqueue/dequeue
+// and checks every value arrives exactly once and each consumer sees each
+// producer's values in order.
+func stressMPMC(t *testing.T, producers, consumers, perProducer int,
+	enqueue func(int), dequeue func() (int, bool)) {
+	t.Helper()
+	total := producers * perProducer
+	seen := make([]int32, total)
+	var got sync.WaitGroup
+	got.Add(total)
+	var wg sync.WaitGroup
+	for p := 0; p < producers; p++ {
+		wg.Add(1)
+		go func(p int) {
+			defer wg.Done()
+			for i := 0; i < perProducer; i++ {
+				enqueue(p*perProducer + i)
+			}
+		}(p)
+	}
+	done := make(chan struct{})
+	errs := make(chan error, consumers)
+	for c := 0; c < consumers; c++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			last := make([]int, producers)
+			for i := range last {
+				last[i] = -1
+			}
+			for {
+				v, ok := dequeue()
+				if !ok {
+					select {
+					case <-done:
+						return
+					default:
+						continue
+					}
+				}
+				p, i := v/perProducer, v%perProducer
+				if i <= last[p] {
+					errs <- fmt.Errorf("producer %d: %d after %d", p, i, last[p])
+					return
+				}
+				last[p] = i
+				seen[v]++
+				got.Done()
+			}
+		}()
+	}
+	got.Wait()
+	close(done)
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatal(err)
+	}
+	for v, n := range seen {
+		if n != 1 {
+			t.Fatalf("value %d seen %d times", v, n)
+		}
+	}
+}
+
+func TestMPMCRingStress(t *testing.T) {
+	r := NewMPMCRing[int](64)
+	stressMPMC(t, 4, 4, 20000, func(v int) {
+		for !r.Enqueue(v) {
+			runtime.Gosched()
+		}
+	}, func() (int, bool) {
+		v, ok := r.Dequeue()
+		if !ok {
+			runtime.Gosched()
+		}
+		return v, ok
+	})
+	if r.Len() != 0 {
+		t.Fatalf("ring not drained: %d", r.Len())
+	}
+}
+
+func TestBlockingRingStress(t *testing.T) {
+	r := NewBlockingRing[int](8)
+	ctx := context.Background()
+	stressMPMC(t, 4, 4, 5000, func(v int) {
+		if err := r.EnqueueWait(ctx, v); err != nil {
+			t.Error(err)
+		}
+	}, func() (int, bool) {
+		// A short timeout lets consumers notice the end of the test.
+		ctx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+		defer cancel()
+		v, err := r.DequeueWait(ctx)
+		return v, err == nil
+	})
+}
+
+func TestBlockingRingWakeup(t *testing.T) {
+	r := NewBlockingRing[int](2)
+	ctx := context.Background()
+	got := make(chan int)
+	go func() {
+		v, err := r.DequeueWait(ctx)
+		if err != nil {
+			t.Error(err)
+		}
+		got <- v
+	}()
+	time.Sleep(10 * time.Millisecond)
+	if !r.Enqueue(7) {
+		t.Fatalf("enqueue")
+	}
+	select {
+	case v := <-got:
+		if v != 7 {
+			t.Fatalf("got %d", v)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("consumer not woken")
+	}
+
+	r.Enqueue(1)
+	r.Enqueue(2)
+	queued := make(chan error)
+	go func() { queued <- r.EnqueueWait(ctx, 3) }()
+	time.Sleep(10 * time.Millisecond)
+	select {
+	case <-queued:
+		t.Fatalf("enqueue into full ring returned")
+	default:
+	}
+	if v, ok := r.Dequeue(); !ok || v != 1 {
+		t.Fatalf("dequeue %d %v", v, ok)
+	}
+	select {
+	case err := <-queued:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("producer not woken")
+	}
+}
+
+func TestBlockingRingCancel(t *testing.T) {
+	r := NewBlockingRing[int](2)
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := r.DequeueWait(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("dequeue on empty ring: %v", err)
+	}
+	r.Enqueue(1)
+	r.Enqueue(2)
+	ctx, cancel = context.WithCancel(context.Background())
+	cancel()
+	if err := r.EnqueueWait(ctx, 3); !errors.Is(err, context.Canceled) {
+		t.Fatalf("enqueue on full ring: %v", err)
+	}
+	if r.Len() != 2 {
+		t.Fatalf("len %d", r.Len())
+	}
+}
+
+// benchMPMC moves b.N values from producers to consumers.
+func benchMPMC(b *testing.B, producers, consumers int, send func(int), recv func()) {
+	var wg sync.WaitGroup
+	per := b.N / producers
+	b.ResetTimer()
+	for p := 0; p < producers; p++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < per; i++ {
+				send(i)
+			}
+		}()
+	}
+	for c := 0; c < consumers; c++ {
+		n := per * producers / consumers
+		if c == 0 {
+			n += per*producers - n*consumers
+		}
+		wg.Add(1)
+		go func(n int) {
+			defer wg.Done()
+			for i := 0; i < n; i++ {
+				recv()
+			}
+		}(n)
+	}
+	wg.Wait()
+}
+
+func BenchmarkQueues(b *testing.B) {
+	ctx := context.Background()
+	for _, pc := range [][2]int{{1, 1}, {4, 4}} {
+		p, c := pc[0], pc[1]
+		b.Run(fmt.Sprintf("mpmc-yield/%dx%d", p, c), func(b *testing.B) {
+			r := NewMPMCRing[int](1024)
+			benchMPMC(b, p, c, func(v int) {
+				for !r.Enqueue(v) {
+					runtime.Gosched()
+				}
+			}, func() {
+				for {
+					if _, ok := r.Dequeue(); ok {
+						return
+					}
+					runtime.Gosched()
+				}
+			})
+		})
+		b.Run(fmt.Sprintf("blocking/%dx%d", p, c), func(b *testing.B) {
+			r := NewBlockingRing[int](1024)
+			benchMPMC(b, p, c, func(v int) {
+				_ = r.EnqueueWait(ctx, v)
+			}, func() {
+				_, _ = r.DequeueWait(ctx)
+			})
+		})
+		b.Run(fmt.Sprintf("chan/%dx%d", p, c), func(b *testing.B) {
+			ch := make(chan int, 1024)
+			benchMPMC(b, p, c, func(v int) { ch <- v }, func() { <-ch })
+		})
+	}
+}
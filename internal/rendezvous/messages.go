@@ -0,0 +1,66 @@
+package rendezvous
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"net/netip"
+)
+
+type Kind uint8
+
+const (
+	KindRegister Kind = iota + 1
+	KindPeer
+	KindPunch
+	KindPunchAck
+)
+
+const magic = 0x5254
+
+type Token [16]byte
+
+func NewToken() Token {
+	var t Token
+	_, _ = rand.Read(t[:])
+	return t
+}
+
+// Message is the unencrypted pre-session exchange with the rendezvous
+// helper and the peer. Addr is only meaningful for KindPeer, where it is
+// the other client's reflexive address.
+type Message struct {
+	Kind  Kind
+	Token Token
+	Addr  netip.AddrPort
+}
+
+func (m Message) Encode() []byte {
+	b := make([]byte, 2+1+16+16+2)
+	binary.BigEndian.PutUint16(b[0:2], magic)
+	b[2] = byte(m.Kind)
+	copy(b[3:19], m.Token[:])
+	a16 := m.Addr.Addr().As16()
+	copy(b[19:35], a16[:])
+	binary.BigEndian.PutUint16(b[35:37], m.Addr.Port())
+	return b
+}
+
+func DecodeMessage(b []byte) (Message, error) {
+	if len(b) < 37 {
+		return Message{}, errors.New("short rendezvous message")
+	}
+	if binary.BigEndian.Uint16(b[0:2]) != magic {
+		return Message{}, errors.New("bad rendezvous magic")
+	}
+	var m Message
+	m.Kind = Kind(b[2])
+	if m.Kind < KindRegister || m.Kind > KindPunchAck {
+		return Message{}, errors.New("unknown rendezvous kind")
+	}
+	copy(m.Token[:], b[3:19])
+	var a16 [16]byte
+	copy(a16[:], b[19:35])
+	m.Addr = netip.AddrPortFrom(netip.AddrFrom16(a16).Unmap(), binary.BigEndian.Uint16(b[35:37]))
+	return m, nil
+}
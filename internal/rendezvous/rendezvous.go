@@ -0,0 +1,337 @@
+package rendezvous
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net"
+	"net/netip"
+	"os"
+	"time"
+
+	"riptide/internal/proto"
+)
+
+// KeepaliveInterval keeps NAT bindings open between peers. RFC 4787 asks
+// for at least two minutes of idle time, but many home and carrier NATs
+// drop UDP mappings after 30 seconds.
+const KeepaliveInterval = 15 * time.Second
+
+const (
+	retryInterval = 200 * time.Millisecond
+	pairTTL       = 2 * time.Minute
+
+	// Registrations are unauthenticated, so the tables are bounded: a
+	// sender spraying random tokens fills at most maxPerAddr waiting
+	// slots, and the tables never exceed maxWaiting and maxPaired.
+	maxWaiting = 4096
+	maxPaired  = 4096
+	maxPerAddr = 8
+)
+
+type waiting struct {
+	addr netip.AddrPort
+	at   time.Time
+}
+
+type pair struct {
+	a, b netip.AddrPort
+	at   time.Time
+}
+
+// Server pairs clients that register with the same token and tells each the
+// other's reflexive address, as seen from the server.
+type Server struct {
+	// ErrorLog receives per-client failures that do not stop the server;
+	// nil uses the log package's standard logger.
+	ErrorLog *log.Logger
+
+	conn    net.PacketConn
+	waiting map[Token]waiting
+	paired  map[Token]pair
+	perAddr map[netip.Addr]int // waiting registrations per source address
+}
+
+func NewServer(conn net.PacketConn) *Server {
+	return &Server{
+		conn:    conn,
+		waiting: make(map[Token]waiting),
+		paired:  make(map[Token]pair),
+		perAddr: make(map[netip.Addr]int),
+	}
+}
+
+func (s *Server) logf(format string, args ...any) {
+	if s.ErrorLog != nil {
+		s.ErrorLog.Printf(format, args...)
+	} else {
+		log.Printf(format, args...)
+	}
+}
+
+func (s *Server) Serve(ctx context.Context) error {
+	buf := make([]byte, 512)
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		_ = s.conn.SetReadDeadline(time.Now().Add(retryInterval))
+		n, from, err := s.conn.ReadFrom(buf)
+		if err != nil {
+			if errors.Is(err, os.ErrDeadlineExceeded) {
+				continue
+			}
+			return err
+		}
+		m, err := DecodeMessage(buf[:n])
+		if err != nil || m.Kind != KindRegister {
+			continue
+		}
+		// A failed send to one client (say, ICMP unreachable reported on
+		// the socket) must not take the helper down for everyone else.
+		if err := s.register(m.Token, addrPort(from), time.Now()); err != nil {
+			s.logf("rendezvous: %v: %v", from, err)
+		}
+	}
+}
+
+func (s *Server) register(tok Token, from netip.AddrPort, now time.Time) error {
+	s.expire(now)
+	if p, ok := s.paired[tok]; ok {
+		// A retransmitted registration; repeat the introduction.
+		switch from {
+		case p.a:
+			return s.send(from, Message{Kind: KindPeer, Token: tok, Addr: p.b})
+		case p.b:
+			return s.send(from, Message{Kind: KindPeer, Token: tok, Addr: p.a})
+		}
+		return nil
+	}
+	w, ok := s.waiting[tok]
+	if ok && w.addr == from {
+		s.waiting[tok] = waiting{addr: from, at: now}
+		return nil
+	}
+	if !ok {
+		if len(s.waiting) >= maxWaiting {
+			return errors.New("waiting table full")
+		}
+		if s.perAddr[from.Addr()] >= maxPerAddr {
+			return errors.New("too many pending tokens from this address")
+		}
+		s.waiting[tok] = waiting{addr: from, at: now}
+		s.perAddr[from.Addr()]++
+		return nil
+	}
+	s.unwait(tok, w)
+	// Without room to remember the pair, a retransmitted registration
+	// just starts over; the introduction below still goes out.
+	if len(s.paired) < maxPaired {
+		s.paired[tok] = pair{a: w.addr, b: from, at: now}
+	}
+	if err := s.send(w.addr, Message{Kind: KindPeer, Token: tok, Addr: from}); err != nil {
+		return err
+	}
+	return s.send(from, Message{Kind: KindPeer, Token: tok, Addr: w.addr})
+}
+
+func (s *Server) expire(now time.Time) {
+	for t, w := range s.waiting {
+		if now.Sub(w.at) > pairTTL {
+			s.unwait(t, w)
+		}
+	}
+	for t, p := range s.paired {
+		if now.Sub(p.at) > pairTTL {
+			delete(s.paired, t)
+		}
+	}
+}
+
+func (s *Server) unwait(tok Token, w waiting) {
+	delete(s.waiting, tok)
+	if s.perAddr[w.addr.Addr()]--; s.perAddr[w.addr.Addr()] <= 0 {
+		delete(s.perAddr, w.addr.Addr())
+	}
+}
+
+func (s *Server) send(to netip.AddrPort, m Message) error {
+	_, err := s.conn.WriteTo(m.Encode(), net.UDPAddrFromAddrPort(to))
+	return err
+}
+
+// Client registers with a rendezvous server and punches a path to the peer
+// sharing its token. Connect leaves Conn ready for the normal handshake;
+// stray KindPunch packets that arrive afterwards should be ignored.
+type Client struct {
+	Conn     net.PacketConn
+	Server   netip.AddrPort
+	Token    Token
+	Interval time.Duration
+}
+
+func (c *Client) Connect(ctx context.Context) (netip.AddrPort, error) {
+	peer, err := c.Register(ctx)
+	if err != nil {
+		return netip.AddrPort{}, err
+	}
+	if err := c.Punch(ctx, peer); err != nil {
+		return netip.AddrPort{}, err
+	}
+	return peer, nil
+}
+
+func (c *Client) Register(ctx context.Context) (netip.AddrPort, error) {
+	var peer netip.AddrPort
+	reg := Message{Kind: KindRegister, Token: c.Token}
+	err := c.exchange(ctx, c.Server, reg, func(m Message, from netip.AddrPort) bool {
+		if m.Kind != KindPeer || from != c.Server {
+			return false
+		}
+		peer = m.Addr
+		return true
+	})
+	return peer, err
+}
+
+// Punch sends to the peer until something from it gets through. Both sides
+// punch at once so each NAT sees outbound traffic before the inbound; a
+// received punch is answered so the side still sending learns the path is
+// open.
+func (c *Client) Punch(ctx context.Context, peer netip.AddrPort) error {
+	punch := Message{Kind: KindPunch, Token: c.Token}
+	answered := false
+	err := c.exchange(ctx, peer, punch, func(m Message, from netip.AddrPort) bool {
+		if from != peer {
+			return false
+		}
+		if m.Kind == KindPunch {
+			_ = c.send(peer, Message{Kind: KindPunchAck, Token: c.Token})
+			answered = true
+			return true
+		}
+		return m.Kind == KindPunchAck
+	})
+	if err != nil || !answered {
+		return err
+	}
+	return c.linger(ctx, peer)
+}
+
+// linger keeps answering the peer's punches after this side saw the path
+// open, because the peer stops only once a PUNCH_ACK gets through and the
+// first one may be lost. It returns when the peer has been quiet for two
+// intervals, acknowledges one of our punches, or sends anything else: its
+// session has started. That packet is consumed; the handshake retransmits.
+func (c *Client) linger(ctx context.Context, peer netip.AddrPort) error {
+	defer c.Conn.SetReadDeadline(time.Time{})
+	buf := make([]byte, 512)
+	for {
+		deadline := time.Now().Add(2 * c.interval())
+		if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+			deadline = d
+		}
+		_ = c.Conn.SetReadDeadline(deadline)
+		n, from, err := c.Conn.ReadFrom(buf)
+		if errors.Is(err, os.ErrDeadlineExceeded) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if addrPort(from) != peer {
+			continue
+		}
+		m, err := DecodeMessage(buf[:n])
+		if err != nil || m.Token != c.Token || m.Kind != KindPunch {
+			return nil
+		}
+		if err := c.send(peer, Message{Kind: KindPunchAck, Token: c.Token}); err != nil {
+			return err
+		}
+	}
+}
+
+func (c *Client) interval() time.Duration {
+	if c.Interval <= 0 {
+		return retryInterval
+	}
+	return c.Interval
+}
+
+func (c *Client) exchange(ctx context.Context, to netip.AddrPort, out Message, done func(Message, netip.AddrPort) bool) error {
+	interval := c.interval()
+	buf := make([]byte, 512)
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := c.send(to, out); err != nil {
+			return err
+		}
+		deadline := time.Now().Add(interval)
+		if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+			deadline = d
+		}
+		_ = c.Conn.SetReadDeadline(deadline)
+		for {
+			n, from, err := c.Conn.ReadFrom(buf)
+			if err != nil {
+				if errors.Is(err, os.ErrDeadlineExceeded) {
+					break
+				}
+				return err
+			}
+			m, err := DecodeMessage(buf[:n])
+			if err != nil || m.Token != c.Token {
+				continue
+			}
+			if done(m, addrPort(from)) {
+				_ = c.Conn.SetReadDeadline(time.Time{})
+				return nil
+			}
+		}
+	}
+}
+
+func (c *Client) send(to netip.AddrPort, m Message) error {
+	_, err := c.Conn.WriteTo(m.Encode(), net.UDPAddrFromAddrPort(to))
+	return err
+}
+
+func addrPort(a net.Addr) netip.AddrPort {
+	if ua, ok := a.(*net.UDPAddr); ok {
+		ap := ua.AddrPort()
+		return netip.AddrPortFrom(ap.Addr().Unmap(), ap.Port())
+	}
+	ap, _ := netip.ParseAddrPort(a.String())
+	return ap
+}
+
+// Keepalive schedules HEARTBEAT packets on an otherwise idle path so NAT
+// bindings stay open. Any outbound packet resets the timer.
+type Keepalive struct {
+	interval time.Duration
+	last     time.Time
+	seq      uint64
+}
+
+func NewKeepalive(interval time.Duration, now time.Time) *Keepalive {
+	if interval <= 0 {
+		interval = KeepaliveInterval
+	}
+	return &Keepalive{interval: interval, last: now}
+}
+
+func (k *Keepalive) OnSend(now time.Time) {
+	k.last = now
+}
+
+func (k *Keepalive) Due(now time.Time) (proto.HeartbeatPayload, bool) {
+	if now.Sub(k.last) < k.interval {
+		return proto.HeartbeatPayload{}, false
+	}
+	k.seq++
+	k.last = now
+	return proto.HeartbeatPayload{Seq: k.seq}, true
+}
@@ -0,0 +1,348 @@
+package rendezvous
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"net/netip"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"riptide/internal/cryptoutil"
+	"riptide/internal/proto"
+)
+
+// natConn stands in for a port-restricted cone NAT: the mapping is the
+// socket's own address, and inbound packets are dropped unless the inside
+// host has already sent to that exact address and port.
+type natConn struct {
+	*net.UDPConn
+	mu        sync.Mutex
+	contacted map[netip.AddrPort]bool
+	dropped   int
+}
+
+func newNATConn(t *testing.T) *natConn {
+	t.Helper()
+	c, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	return &natConn{UDPConn: c, contacted: make(map[netip.AddrPort]bool)}
+}
+
+func (n *natConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	n.mu.Lock()
+	n.contacted[addrPort(addr)] = true
+	n.mu.Unlock()
+	return n.UDPConn.WriteTo(b, addr)
+}
+
+func (n *natConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	for {
+		l, from, err := n.UDPConn.ReadFrom(b)
+		if err != nil {
+			return l, from, err
+		}
+		n.mu.Lock()
+		ok := n.contacted[addrPort(from)]
+		if !ok {
+			n.dropped++
+		}
+		n.mu.Unlock()
+		if ok {
+			return l, from, nil
+		}
+	}
+}
+
+func (n *natConn) public() netip.AddrPort {
+	return n.LocalAddr().(*net.UDPAddr).AddrPort()
+}
+
+func TestMessageEncodeDecode(t *testing.T) {
+	m := Message{Kind: KindPeer, Token: NewToken(), Addr: netip.MustParseAddrPort("[2001:db8::1]:3703")}
+	out, err := DecodeMessage(m.Encode())
+	if err != nil || out != m {
+		t.Fatalf("mismatch: %+v %v", out, err)
+	}
+	v4 := Message{Kind: KindPunch, Addr: netip.MustParseAddrPort("10.0.0.1:9")}
+	if out, _ := DecodeMessage(v4.Encode()); out.Addr != v4.Addr {
+		t.Fatalf("ipv4 addr not unmapped: %v", out.Addr)
+	}
+	bad := m.Encode()
+	bad[0] = 0
+	if _, err := DecodeMessage(bad); err == nil {
+		t.Fatalf("expected magic error")
+	}
+	if _, err := DecodeMessage(bad[:5]); err == nil {
+		t.Fatalf("expected short error")
+	}
+}
+
+func TestHolePunchThroughStandInNAT(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	sc, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer sc.Close()
+	srv := NewServer(sc)
+	srvDone := make(chan error, 1)
+	go func() { srvDone <- srv.Serve(ctx) }()
+	srvAddr := sc.LocalAddr().(*net.UDPAddr).AddrPort()
+
+	natA, natB := newNATConn(t), newNATConn(t)
+	defer natA.Close()
+	defer natB.Close()
+
+	// Before any punching, B's NAT drops unsolicited traffic from A.
+	if _, err := natA.WriteTo([]byte("early"), net.UDPAddrFromAddrPort(natB.public())); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	_ = natB.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+	if _, _, err := natB.ReadFrom(make([]byte, 64)); !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Fatalf("unsolicited packet should be filtered, got %v", err)
+	}
+
+	tok := NewToken()
+	type result struct {
+		peer netip.AddrPort
+		err  error
+	}
+	results := make(chan result, 2)
+	for _, nc := range []*natConn{natA, natB} {
+		c := &Client{Conn: nc, Server: srvAddr, Token: tok, Interval: 20 * time.Millisecond}
+		go func() {
+			p, err := c.Connect(ctx)
+			results <- result{p, err}
+		}()
+	}
+	peers := map[netip.AddrPort]bool{}
+	for i := 0; i < 2; i++ {
+		r := <-results
+		if r.err != nil {
+			t.Fatalf("connect: %v", r.err)
+		}
+		peers[r.peer] = true
+	}
+	if !peers[natA.public()] || !peers[natB.public()] {
+		t.Fatalf("peers learned wrong reflexive addresses: %v", peers)
+	}
+
+	// The punched path now carries session traffic directly.
+	var key [32]byte
+	a, _ := cryptoutil.NewAEAD(key)
+	h := proto.Header{Version: proto.Version, Type: proto.TypeHeartbeat, ConnID: 1}
+	pkt, _ := proto.EncodeHeartbeatPacket(h, proto.HeartbeatPayload{Seq: 1}, a, nil)
+	if _, err := natA.WriteTo(pkt, net.UDPAddrFromAddrPort(natB.public())); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	buf := make([]byte, 256)
+	_ = natB.SetReadDeadline(time.Now().Add(2 * time.Second))
+	for {
+		n, _, err := natB.ReadFrom(buf)
+		if err != nil {
+			t.Fatalf("heartbeat not delivered: %v", err)
+		}
+		if _, err := DecodeMessage(buf[:n]); err == nil {
+			continue
+		}
+		if _, hb, err := proto.DecodeHeartbeatPacket(buf[:n], a, nil); err != nil || hb.Seq != 1 {
+			t.Fatalf("bad heartbeat: %v", err)
+		}
+		break
+	}
+	cancel()
+	<-srvDone
+}
+
+func TestKeepalive(t *testing.T) {
+	now := time.Unix(0, 0)
+	k := NewKeepalive(0, now)
+	if _, ok := k.Due(now.Add(KeepaliveInterval - time.Second)); ok {
+		t.Fatalf("not due yet")
+	}
+	hb, ok := k.Due(now.Add(KeepaliveInterval))
+	if !ok || hb.Seq != 1 {
+		t.Fatalf("expected heartbeat 1, got %+v %v", hb, ok)
+	}
+	k.OnSend(now.Add(KeepaliveInterval + 10*time.Second))
+	if _, ok := k.Due(now.Add(2 * KeepaliveInterval)); ok {
+		t.Fatalf("outbound traffic should defer the keepalive")
+	}
+	if hb, ok := k.Due(now.Add(3 * KeepaliveInterval)); !ok || hb.Seq != 2 {
+		t.Fatalf("expected heartbeat 2, got %+v", hb)
+	}
+}
+
+// failingConn fails every write to one address.
+type failingConn struct {
+	*net.UDPConn
+	bad netip.AddrPort
+}
+
+func (f *failingConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	if addrPort(addr) == f.bad {
+		return 0, errors.New("connection refused")
+	}
+	return f.UDPConn.WriteTo(b, addr)
+}
+
+func TestServerSurvivesSendFailure(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	sc, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer sc.Close()
+	broken := newNATConn(t)
+	defer broken.Close()
+	srv := NewServer(&failingConn{UDPConn: sc, bad: broken.public()})
+	srv.ErrorLog = log.New(io.Discard, "", 0)
+	srvDone := make(chan error, 1)
+	go func() { srvDone <- srv.Serve(ctx) }()
+	srvAddr := sc.LocalAddr().(*net.UDPAddr).AddrPort()
+
+	// Pairing with the broken client fails to introduce one side.
+	peer := newNATConn(t)
+	defer peer.Close()
+	tok := NewToken()
+	reg := Message{Kind: KindRegister, Token: tok}.Encode()
+	broken.WriteTo(reg, net.UDPAddrFromAddrPort(srvAddr))
+	time.Sleep(50 * time.Millisecond)
+	peer.WriteTo(reg, net.UDPAddrFromAddrPort(srvAddr))
+
+	// Other clients are still served.
+	natA, natB := newNATConn(t), newNATConn(t)
+	defer natA.Close()
+	defer natB.Close()
+	tok2 := NewToken()
+	errs := make(chan error, 2)
+	for _, nc := range []*natConn{natA, natB} {
+		c := &Client{Conn: nc, Server: srvAddr, Token: tok2, Interval: 20 * time.Millisecond}
+		go func() {
+			_, err := c.Register(ctx)
+			errs <- err
+		}()
+	}
+	for i := 0; i < 2; i++ {
+		if err := <-errs; err != nil {
+			t.Fatalf("register after a failed send: %v", err)
+		}
+	}
+	select {
+	case err := <-srvDone:
+		t.Fatalf("server stopped: %v", err)
+	default:
+	}
+	cancel()
+	<-srvDone
+}
+
+// punchLoser loses every PUNCH it sends and the first PUNCH_ACK, as when
+// the peer's NAT has not opened yet and the answer is then lost.
+type punchLoser struct {
+	*net.UDPConn
+	mu      sync.Mutex
+	dropped bool
+}
+
+func (d *punchLoser) WriteTo(b []byte, addr net.Addr) (int, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if m, err := DecodeMessage(b); err == nil {
+		if m.Kind == KindPunch {
+			return len(b), nil
+		}
+		if m.Kind == KindPunchAck && !d.dropped {
+			d.dropped = true
+			return len(b), nil
+		}
+	}
+	return d.UDPConn.WriteTo(b, addr)
+}
+
+func TestPunchSurvivesLostAck(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	listen := func() *net.UDPConn {
+		c, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+		if err != nil {
+			t.Fatalf("listen: %v", err)
+		}
+		return c
+	}
+	a := &punchLoser{UDPConn: listen()}
+	defer a.Close()
+	b := listen()
+	defer b.Close()
+	tok := NewToken()
+
+	// B can only learn the path is open from A's PUNCH_ACK.
+	errs := make(chan error, 2)
+	for _, c := range []struct {
+		conn net.PacketConn
+		peer netip.AddrPort
+	}{{a, b.LocalAddr().(*net.UDPAddr).AddrPort()}, {b, a.LocalAddr().(*net.UDPAddr).AddrPort()}} {
+		cl := &Client{Conn: c.conn, Token: tok, Interval: 20 * time.Millisecond}
+		go func() { errs <- cl.Punch(ctx, c.peer) }()
+	}
+	for i := 0; i < 2; i++ {
+		if err := <-errs; err != nil {
+			t.Fatalf("punch: %v", err)
+		}
+	}
+	if !a.dropped {
+		t.Fatalf("no PUNCH_ACK was dropped")
+	}
+}
+
+func TestServerTablesBounded(t *testing.T) {
+	sc, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer sc.Close()
+	srv := NewServer(sc)
+	now := time.Unix(1_000, 0)
+
+	flooder := netip.MustParseAddrPort("192.0.2.1:4000")
+	for i := 0; i < maxPerAddr; i++ {
+		if err := srv.register(NewToken(), flooder, now); err != nil {
+			t.Fatalf("registration %d: %v", i, err)
+		}
+	}
+	if err := srv.register(NewToken(), flooder, now); err == nil {
+		t.Fatalf("per-address limit not enforced")
+	}
+	if len(srv.waiting) != maxPerAddr {
+		t.Fatalf("waiting %d", len(srv.waiting))
+	}
+
+	for i := 0; len(srv.waiting) < maxWaiting; i++ {
+		from := netip.AddrPortFrom(netip.AddrFrom4([4]byte{10, byte(i >> 16), byte(i >> 8), byte(i)}), 5000)
+		if err := srv.register(NewToken(), from, now); err != nil {
+			t.Fatalf("filling: %v", err)
+		}
+	}
+	if err := srv.register(NewToken(), netip.MustParseAddrPort("198.51.100.7:1"), now); err == nil {
+		t.Fatalf("waiting table grew past %d", maxWaiting)
+	}
+
+	// Expiry frees both the table and the per-address allowance.
+	later := now.Add(pairTTL + time.Second)
+	if err := srv.register(NewToken(), flooder, later); err != nil {
+		t.Fatalf("after expiry: %v", err)
+	}
+	if len(srv.waiting) != 1 || len(srv.perAddr) != 1 || srv.perAddr[flooder.Addr()] != 1 {
+		t.Fatalf("expiry left %d waiting, %d addresses", len(srv.waiting), len(srv.perAddr))
+	}
+}
@@ -0,0 +1,137 @@
+package session
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"net/netip"
+	"time"
+
+	"riptide/internal/congestion"
+)
+
+func NewConnID() uint64 {
+	var b [8]byte
+	_, _ = rand.Read(b[:])
+	return binary.BigEndian.Uint64(b[:])
+}
+
+type Path struct {
+	Addr      netip.AddrPort
+	CC        *congestion.State
+	challenge [8]byte
+	sentAt    time.Time
+	tries     int
+}
+
+type Challenge struct {
+	Addr netip.AddrPort
+	Data [8]byte
+}
+
+// Migrator binds a session to its connection ID rather than the peer
+// address. Packets from a new address start path validation; bulk data
+// keeps flowing to the old address until the new one answers a challenge,
+// after which it becomes active with fresh congestion state.
+type Migrator struct {
+	connID   uint64
+	active   *Path
+	probing  *Path
+	timeout  time.Duration
+	maxTries int
+}
+
+func NewMigrator(connID uint64, addr netip.AddrPort, timeout time.Duration, maxTries int) *Migrator {
+	if timeout <= 0 {
+		timeout = 500 * time.Millisecond
+	}
+	if maxTries <= 0 {
+		maxTries = 3
+	}
+	return &Migrator{
+		connID:   connID,
+		active:   &Path{Addr: addr, CC: congestion.New()},
+		timeout:  timeout,
+		maxTries: maxTries,
+	}
+}
+
+func (m *Migrator) ConnID() uint64 { return m.connID }
+func (m *Migrator) Active() *Path  { return m.active }
+func (m *Migrator) Validating() bool {
+	return m.probing != nil
+}
+
+// OnPacket is called for every authenticated packet carrying this session's
+// connection ID. It returns a challenge to send when the source address is
+// new.
+func (m *Migrator) OnPacket(from netip.AddrPort, now time.Time) (Challenge, bool) {
+	if from == m.active.Addr {
+		return Challenge{}, false
+	}
+	if m.probing != nil && m.probing.Addr == from {
+		return Challenge{}, false
+	}
+	m.probing = &Path{Addr: from}
+	return m.challenge(now), true
+}
+
+// OnPathResponse completes validation when the echoed data matches the
+// outstanding challenge and arrives from the address being probed.
+func (m *Migrator) OnPathResponse(from netip.AddrPort, data [8]byte) bool {
+	p := m.probing
+	if p == nil || p.Addr != from || p.challenge != data {
+		return false
+	}
+	p.CC = congestion.New()
+	m.active = p
+	m.probing = nil
+	return true
+}
+
+// Tick resends an unanswered challenge and abandons the candidate path after
+// maxTries, leaving the session on its previous address.
+func (m *Migrator) Tick(now time.Time) (Challenge, bool) {
+	p := m.probing
+	if p == nil || now.Sub(p.sentAt) < m.timeout {
+		return Challenge{}, false
+	}
+	if p.tries >= m.maxTries {
+		m.probing = nil
+		return Challenge{}, false
+	}
+	return m.challenge(now), true
+}
+
+func (m *Migrator) challenge(now time.Time) Challenge {
+	p := m.probing
+	_, _ = rand.Read(p.challenge[:])
+	p.sentAt = now
+	p.tries++
+	return Challenge{Addr: p.Addr, Data: p.challenge}
+}
+
+// Table demultiplexes incoming packets to sessions by connection ID.
+type Table struct {
+	byID map[uint64]*Migrator
+}
+
+func NewTable() *Table {
+	return &Table{byID: make(map[uint64]*Migrator)}
+}
+
+func (t *Table) Add(m *Migrator) {
+	t.byID[m.connID] = m
+}
+
+func (t *Table) Lookup(connID uint64) (*Migrator, bool) {
+	m, ok := t.byID[connID]
+	return m, ok
+}
+
+func (t *Table) Remove(connID uint64) {
+	delete(t.byID, connID)
+}
+
+func (t *Table) Len() int {
+	return len(t.byID)
+}
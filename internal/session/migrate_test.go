@@ -0,0 +1,204 @@
+package session
+
+import (
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+
+	"riptide/internal/cryptoutil"
+	"riptide/internal/proto"
+)
+
+func TestMigratorValidatesNewPath(t *testing.T) {
+	old := netip.MustParseAddrPort("10.0.0.1:4000")
+	moved := netip.MustParseAddrPort("10.0.0.2:5000")
+	now := time.Unix(0, 0)
+	m := NewMigrator(7, old, time.Second, 2)
+	oldCC := m.Active().CC
+
+	if _, ok := m.OnPacket(old, now); ok {
+		t.Fatalf("no challenge expected for the active address")
+	}
+	ch, ok := m.OnPacket(moved, now)
+	if !ok || ch.Addr != moved {
+		t.Fatalf("expected challenge to new address, got %+v", ch)
+	}
+	if _, ok := m.OnPacket(moved, now); ok {
+		t.Fatalf("challenge already outstanding")
+	}
+	if m.Active().Addr != old {
+		t.Fatalf("must keep sending to the old path until validated")
+	}
+	if m.OnPathResponse(old, ch.Data) {
+		t.Fatalf("response from wrong address must not validate")
+	}
+	bad := ch.Data
+	bad[0] ^= 1
+	if m.OnPathResponse(moved, bad) {
+		t.Fatalf("wrong challenge data must not validate")
+	}
+	if !m.OnPathResponse(moved, ch.Data) {
+		t.Fatalf("valid response rejected")
+	}
+	if m.Active().Addr != moved || m.Active().CC == oldCC || m.Validating() {
+		t.Fatalf("path not switched with fresh congestion state")
+	}
+}
+
+func TestMigratorAbandonsUnansweredPath(t *testing.T) {
+	old := netip.MustParseAddrPort("10.0.0.1:4000")
+	spoof := netip.MustParseAddrPort("192.0.2.9:1")
+	now := time.Unix(0, 0)
+	m := NewMigrator(7, old, time.Second, 2)
+	first, _ := m.OnPacket(spoof, now)
+	if _, ok := m.Tick(now.Add(time.Millisecond)); ok {
+		t.Fatalf("retry before timeout")
+	}
+	second, ok := m.Tick(now.Add(time.Second))
+	if !ok || second.Data == first.Data {
+		t.Fatalf("expected a fresh challenge on retry")
+	}
+	if _, ok := m.Tick(now.Add(2 * time.Second)); ok || m.Validating() {
+		t.Fatalf("candidate path should be abandoned after max tries")
+	}
+	if m.Active().Addr != old {
+		t.Fatalf("active path changed without validation")
+	}
+}
+
+func TestTable(t *testing.T) {
+	tb := NewTable()
+	m := NewMigrator(NewConnID(), netip.MustParseAddrPort("10.0.0.1:1"), 0, 0)
+	tb.Add(m)
+	if got, ok := tb.Lookup(m.ConnID()); !ok || got != m {
+		t.Fatalf("lookup failed")
+	}
+	tb.Remove(m.ConnID())
+	if tb.Len() != 0 {
+		t.Fatalf("remove failed")
+	}
+}
+
+func listen(t *testing.T) *net.UDPConn {
+	t.Helper()
+	c, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	_ = c.SetReadDeadline(time.Now().Add(5 * time.Second))
+	return c
+}
+
+func readFrom(t *testing.T, c *net.UDPConn) ([]byte, netip.AddrPort) {
+	t.Helper()
+	buf := make([]byte, 2048)
+	n, from, err := c.ReadFromUDPAddrPort(buf)
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	return buf[:n], netip.AddrPortFrom(from.Addr().Unmap(), from.Port())
+}
+
+// TestLoopbackRebind moves the client to a new socket mid-transfer and
+// checks the server validates the new address before switching to it.
+func TestLoopbackRebind(t *testing.T) {
+	var key [32]byte
+	key[0] = 9
+	a, err := cryptoutil.NewAEAD(key)
+	if err != nil {
+		t.Fatalf("aead: %v", err)
+	}
+	server := listen(t)
+	defer server.Close()
+	srvAddr := server.LocalAddr().(*net.UDPAddr).AddrPort()
+	cid := NewConnID()
+	tb := NewTable()
+
+	send := func(c *net.UDPConn, seq uint64) {
+		h := proto.Header{Version: proto.Version, Type: proto.TypeData, ConnID: cid, Seq: seq}
+		pkt, _ := proto.EncodeDataPacket(h, proto.DataPayload{Data: []byte{byte(seq)}}, a, nil)
+		if _, err := c.WriteToUDPAddrPort(pkt, srvAddr); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+	}
+	received := map[uint64]bool{}
+	serve := func() {
+		pkt, from := readFrom(t, server)
+		id, _ := proto.PeekConnID(pkt)
+		m, ok := tb.Lookup(id)
+		if !ok {
+			m = NewMigrator(id, from, time.Second, 3)
+			tb.Add(m)
+		}
+		var h proto.Header
+		if err := h.Decode(pkt); err != nil {
+			t.Fatalf("header: %v", err)
+		}
+		switch h.Type {
+		case proto.TypeData:
+			h, _, err := proto.DecodeDataPacket(pkt, a, nil)
+			if err != nil {
+				t.Fatalf("data: %v", err)
+			}
+			received[h.Seq] = true
+			if ch, ok := m.OnPacket(from, time.Now()); ok {
+				ph := proto.Header{Version: proto.Version, Type: proto.TypePathChallenge, ConnID: id}
+				out, _ := proto.EncodePathPacket(ph, proto.PathPayload{Data: ch.Data}, a, nil)
+				_, _ = server.WriteToUDPAddrPort(out, ch.Addr)
+			}
+		case proto.TypePathResponse:
+			_, pp, err := proto.DecodePathPacket(pkt, a, nil)
+			if err != nil {
+				t.Fatalf("path response: %v", err)
+			}
+			m.OnPathResponse(from, pp.Data)
+		default:
+			t.Fatalf("unexpected packet type %v", h.Type)
+		}
+	}
+
+	clientA := listen(t)
+	for seq := uint64(0); seq < 5; seq++ {
+		send(clientA, seq)
+		serve()
+	}
+	m, _ := tb.Lookup(cid)
+	addrA := clientA.LocalAddr().(*net.UDPAddr).AddrPort()
+	if m.Active().Addr != addrA {
+		t.Fatalf("active = %v want %v", m.Active().Addr, addrA)
+	}
+	clientA.Close()
+
+	clientB := listen(t)
+	defer clientB.Close()
+	addrB := clientB.LocalAddr().(*net.UDPAddr).AddrPort()
+	send(clientB, 5)
+	serve()
+	if m.Active().Addr != addrA || !m.Validating() {
+		t.Fatalf("new address must be validated before use")
+	}
+
+	pkt, _ := readFrom(t, clientB)
+	h, pp, err := proto.DecodePathPacket(pkt, a, nil)
+	if err != nil || h.Type != proto.TypePathChallenge || h.ConnID != cid {
+		t.Fatalf("expected path challenge: %+v %v", h, err)
+	}
+	rh := proto.Header{Version: proto.Version, Type: proto.TypePathResponse, ConnID: cid}
+	resp, _ := proto.EncodePathPacket(rh, pp, a, nil)
+	if _, err := clientB.WriteToUDPAddrPort(resp, srvAddr); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	serve()
+	if m.Active().Addr != addrB {
+		t.Fatalf("active = %v want %v", m.Active().Addr, addrB)
+	}
+
+	for seq := uint64(6); seq < 10; seq++ {
+		send(clientB, seq)
+		serve()
+	}
+	if len(received) != 10 {
+		t.Fatalf("received %d packets, want 10", len(received))
+	}
+}
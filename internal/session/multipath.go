@@ -0,0 +1,271 @@
+package session
+
+import (
+	"errors"
+	"net"
+	"net/netip"
+	"time"
+
+	"riptide/internal/congestion"
+)
+
+var ErrNoWindow = errors.New("no path has congestion window available")
+
+// Subflow is one UDP path of a multipath session with its own congestion
+// and RTT state.
+type Subflow struct {
+	ID       int
+	Local    netip.AddrPort
+	Remote   netip.AddrPort
+	Conn     *net.UDPConn
+	CC       *congestion.State
+	RTT      congestion.RTTEstimator
+	inflight int
+	sent     uint64
+	lost     uint64
+	acked    uint64
+	lastAck  time.Time
+}
+
+func (s *Subflow) Inflight() int { return s.inflight }
+
+func (s *Subflow) LossRate() float64 {
+	if s.sent == 0 {
+		return 0
+	}
+	return float64(s.lost) / float64(s.sent)
+}
+
+func (s *Subflow) HasWindow(payload int) bool {
+	return s.inflight < s.CC.CongestionWindow(payload)
+}
+
+type Scheduler interface {
+	Pick(paths []*Subflow, payload int) *Subflow
+}
+
+// MinRTTScheduler sends on the lowest-RTT path that has window space.
+// Paths without an RTT sample yet are tried first so they get measured.
+type MinRTTScheduler struct{}
+
+func (MinRTTScheduler) Pick(paths []*Subflow, payload int) *Subflow {
+	var best *Subflow
+	for _, p := range paths {
+		if !p.HasWindow(payload) {
+			continue
+		}
+		if best == nil || p.RTT.SRTT() < best.RTT.SRTT() {
+			best = p
+		}
+	}
+	return best
+}
+
+// WeightedScheduler spreads packets in proportion to each path's pacing
+// rate using smooth weighted round robin.
+type WeightedScheduler struct {
+	credit map[int]float64
+}
+
+func (w *WeightedScheduler) Pick(paths []*Subflow, payload int) *Subflow {
+	if w.credit == nil {
+		w.credit = make(map[int]float64)
+	}
+	var best *Subflow
+	total := 0.0
+	for _, p := range paths {
+		if !p.HasWindow(payload) {
+			continue
+		}
+		weight := p.CC.PacingRate()
+		if weight <= 0 {
+			weight = 1
+		}
+		total += weight
+		w.credit[p.ID] += weight
+		if best == nil || w.credit[p.ID] > w.credit[best.ID] {
+			best = p
+		}
+	}
+	if best != nil {
+		w.credit[best.ID] -= total
+	}
+	return best
+}
+
+type sentPacket struct {
+	path *Subflow
+	size int
+	at   time.Time
+	retx bool
+}
+
+// PathManager runs one session over several subflows, attributing ACKs and
+// losses to the path each packet was sent on.
+type PathManager struct {
+	paths   []*Subflow
+	sched   Scheduler
+	payload int
+	sent    map[uint64]sentPacket
+	// lostOn remembers the path of packets declared lost until they are
+	// retransmitted, so the retransmission can avoid it.
+	lostOn map[uint64]*Subflow
+}
+
+func NewPathManager(sched Scheduler, payload int) *PathManager {
+	if sched == nil {
+		sched = MinRTTScheduler{}
+	}
+	return &PathManager{
+		sched:   sched,
+		payload: payload,
+		sent:    make(map[uint64]sentPacket),
+		lostOn:  make(map[uint64]*Subflow),
+	}
+}
+
+// Open binds one socket per local address and adds it as a subflow to
+// remote. Addresses that cannot be bound are skipped; it fails only if no
+// subflow could be opened.
+func (m *PathManager) Open(locals []netip.Addr, remote netip.AddrPort) error {
+	var errs []error
+	opened := 0
+	for _, a := range locals {
+		c, err := net.ListenUDP("udp", net.UDPAddrFromAddrPort(netip.AddrPortFrom(a, 0)))
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		m.Add(c, remote)
+		opened++
+	}
+	if opened == 0 {
+		return errors.Join(append(errs, errors.New("no subflows opened"))...)
+	}
+	return nil
+}
+
+func (m *PathManager) Add(c *net.UDPConn, remote netip.AddrPort) *Subflow {
+	s := &Subflow{
+		ID:     len(m.paths),
+		Remote: remote,
+		Conn:   c,
+		CC:     congestion.New(),
+	}
+	if ua, ok := c.LocalAddr().(*net.UDPAddr); ok {
+		s.Local = ua.AddrPort()
+	}
+	m.paths = append(m.paths, s)
+	return s
+}
+
+func (m *PathManager) Paths() []*Subflow { return m.paths }
+
+func (m *PathManager) Send(seq uint64, pkt []byte, now time.Time) (*Subflow, error) {
+	p := m.sched.Pick(m.paths, m.payload)
+	if p == nil {
+		return nil, ErrNoWindow
+	}
+	return p, m.transmit(p, seq, pkt, now, false)
+}
+
+// Retransmit resends seq on the healthiest path with window space: lowest
+// loss rate, then lowest RTT, preferring a path other than the one the
+// packet was lost on.
+func (m *PathManager) Retransmit(seq uint64, pkt []byte, now time.Time) (*Subflow, error) {
+	orig := m.lostOn[seq]
+	if sp, ok := m.sent[seq]; ok {
+		orig = sp.path
+	}
+	var best *Subflow
+	for _, p := range m.paths {
+		if !p.HasWindow(m.payload) {
+			continue
+		}
+		if best == nil || healthier(p, best, orig) {
+			best = p
+		}
+	}
+	if best == nil {
+		return nil, ErrNoWindow
+	}
+	return best, m.transmit(best, seq, pkt, now, true)
+}
+
+func healthier(a, b, orig *Subflow) bool {
+	if (a == orig) != (b == orig) {
+		return b == orig
+	}
+	if a.LossRate() != b.LossRate() {
+		return a.LossRate() < b.LossRate()
+	}
+	return a.RTT.SRTT() < b.RTT.SRTT()
+}
+
+func (m *PathManager) transmit(p *Subflow, seq uint64, pkt []byte, now time.Time, retx bool) error {
+	if _, err := p.Conn.WriteToUDPAddrPort(pkt, p.Remote); err != nil {
+		return err
+	}
+	if prev, ok := m.sent[seq]; ok {
+		prev.path.inflight--
+	}
+	delete(m.lostOn, seq)
+	p.inflight++
+	p.sent++
+	m.sent[seq] = sentPacket{path: p, size: len(pkt), at: now, retx: retx}
+	return nil
+}
+
+// OnAck credits the path seq was last sent on. Following Karn's algorithm,
+// retransmitted packets give no RTT sample.
+func (m *PathManager) OnAck(seq uint64, now time.Time) (*Subflow, bool) {
+	// A late ACK for a packet already declared lost ends its tracking.
+	delete(m.lostOn, seq)
+	sp, ok := m.sent[seq]
+	if !ok {
+		return nil, false
+	}
+	delete(m.sent, seq)
+	p := sp.path
+	p.inflight--
+	p.acked++
+	var rtt time.Duration
+	if !sp.retx {
+		rtt = now.Sub(sp.at)
+		p.RTT.Update(rtt)
+	}
+	interval := now.Sub(p.lastAck)
+	if p.lastAck.IsZero() || interval <= 0 {
+		interval = rtt
+	}
+	p.CC.Update(uint64(sp.size), interval, rtt, now)
+	p.lastAck = now
+	return p, true
+}
+
+// OnLoss takes seq out of flight on the path it was sent on and tells that
+// path's congestion controller. The packet is no longer tracked until it
+// is retransmitted.
+func (m *PathManager) OnLoss(seq uint64, now time.Time) (*Subflow, bool) {
+	sp, ok := m.sent[seq]
+	if !ok {
+		return nil, false
+	}
+	delete(m.sent, seq)
+	m.lostOn[seq] = sp.path
+	p := sp.path
+	p.inflight--
+	p.lost++
+	p.CC.OnLoss(now)
+	return p, true
+}
+
+func (m *PathManager) Close() error {
+	var errs []error
+	for _, p := range m.paths {
+		if err := p.Conn.Close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,171 @@
+package session
+
+import (
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+
+	"riptide/internal/congestion"
+)
+
+func openLoopbackPaths(t *testing.T, sched Scheduler) (*PathManager, *net.UDPConn) {
+	t.Helper()
+	server := listen(t)
+	remote := server.LocalAddr().(*net.UDPAddr).AddrPort()
+	m := NewPathManager(sched, 1200)
+	locals := []netip.Addr{netip.MustParseAddr("127.0.0.1"), netip.MustParseAddr("127.0.0.2")}
+	if err := m.Open(locals, remote); err != nil {
+		server.Close()
+		t.Fatalf("open: %v", err)
+	}
+	if len(m.Paths()) != 2 {
+		server.Close()
+		m.Close()
+		t.Skipf("need two loopback addresses, got %d paths", len(m.Paths()))
+	}
+	return m, server
+}
+
+func TestPathManagerSpreadsAcrossLoopbackPaths(t *testing.T) {
+	m, server := openLoopbackPaths(t, MinRTTScheduler{})
+	defer server.Close()
+	defer m.Close()
+
+	now := time.Unix(0, 0)
+	// Path 0 is fast, path 1 is slow; each ACK arrives after its path RTT.
+	rtts := []time.Duration{10 * time.Millisecond, 80 * time.Millisecond}
+	from := map[netip.Addr]int{}
+	for seq := uint64(0); seq < 40; seq++ {
+		p, err := m.Send(seq, []byte{byte(seq)}, now)
+		if err == ErrNoWindow {
+			t.Fatalf("blocked at seq %d", seq)
+		}
+		if err != nil {
+			t.Fatalf("send: %v", err)
+		}
+		_, src := readFrom(t, server)
+		from[src.Addr()]++
+		m.OnAck(seq, now.Add(rtts[p.ID]))
+		now = now.Add(time.Millisecond)
+	}
+	if len(from) != 2 {
+		t.Fatalf("expected traffic from both local addresses, got %v", from)
+	}
+	fast, slow := m.Paths()[0], m.Paths()[1]
+	if fast.RTT.SRTT() >= slow.RTT.SRTT() {
+		t.Fatalf("per-path RTT not tracked: %v vs %v", fast.RTT.SRTT(), slow.RTT.SRTT())
+	}
+	if fast.CC == slow.CC {
+		t.Fatalf("paths must have separate congestion state")
+	}
+	if fast.acked <= slow.acked {
+		t.Fatalf("min-rtt scheduler should favour the fast path: %d vs %d", fast.acked, slow.acked)
+	}
+}
+
+func TestPathManagerRetransmitsOnHealthierPath(t *testing.T) {
+	m, server := openLoopbackPaths(t, &WeightedScheduler{})
+	defer server.Close()
+	defer m.Close()
+	now := time.Unix(0, 0)
+
+	lossy := m.Paths()[0]
+	lossy.sent, lossy.lost = 10, 5
+	if err := m.transmit(lossy, 1, []byte("x"), now, false); err != nil {
+		t.Fatalf("send: %v", err)
+	}
+	readFrom(t, server)
+	if _, ok := m.OnLoss(1, now); !ok {
+		t.Fatalf("loss not attributed")
+	}
+	p, err := m.Retransmit(1, []byte("x"), now)
+	if err != nil {
+		t.Fatalf("retransmit: %v", err)
+	}
+	if p == lossy {
+		t.Fatalf("retransmission should move off the lossy path")
+	}
+	_, src := readFrom(t, server)
+	if src.Addr() != p.Local.Addr() {
+		t.Fatalf("retransmit from %v want %v", src, p.Local)
+	}
+	if lossy.Inflight() != 0 || p.Inflight() != 1 {
+		t.Fatalf("inflight not moved: %d %d", lossy.Inflight(), p.Inflight())
+	}
+	rtt := p.RTT.SRTT()
+	m.OnAck(1, now.Add(time.Second))
+	if p.RTT.SRTT() != rtt {
+		t.Fatalf("retransmitted packet must not produce an RTT sample")
+	}
+}
+
+func TestPathManagerLossAccounting(t *testing.T) {
+	m, server := openLoopbackPaths(t, MinRTTScheduler{})
+	defer server.Close()
+	defer m.Close()
+	now := time.Unix(0, 0)
+
+	a, b := m.Paths()[0], m.Paths()[1]
+	for _, p := range m.Paths() {
+		p.CC.Update(120000, 100*time.Millisecond, 100*time.Millisecond, now)
+	}
+	if err := m.transmit(a, 1, []byte("x"), now, false); err != nil {
+		t.Fatalf("send: %v", err)
+	}
+	readFrom(t, server)
+	rate := a.CC.PacingRate()
+	if p, ok := m.OnLoss(1, now); !ok || p != a {
+		t.Fatalf("loss not attributed")
+	}
+	if a.Inflight() != 0 || a.lost != 1 {
+		t.Fatalf("lost packet still in flight: inflight %d lost %d", a.Inflight(), a.lost)
+	}
+	if _, ok := m.sent[1]; ok {
+		t.Fatalf("lost packet still tracked")
+	}
+	if a.CC.PacingRate() >= rate {
+		t.Fatalf("congestion controller not told of the loss: %v -> %v", rate, a.CC.PacingRate())
+	}
+	if _, ok := m.OnLoss(1, now); ok {
+		t.Fatalf("loss counted twice")
+	}
+
+	// Fill b's window: the retransmission must stay on a, which has room,
+	// even though b has never lost anything.
+	for seq := uint64(100); b.HasWindow(m.payload); seq++ {
+		if err := m.transmit(b, seq, []byte("y"), now, false); err != nil {
+			t.Fatalf("send: %v", err)
+		}
+		readFrom(t, server)
+	}
+	p, err := m.Retransmit(1, []byte("x"), now)
+	if err != nil || p != a {
+		t.Fatalf("retransmit went to %v (%v), want the path with window", p, err)
+	}
+	readFrom(t, server)
+	for a.HasWindow(m.payload) {
+		if err := m.transmit(a, uint64(200+a.Inflight()), []byte("z"), now, false); err != nil {
+			t.Fatalf("send: %v", err)
+		}
+		readFrom(t, server)
+	}
+	if _, err := m.Retransmit(2, []byte("x"), now); err != ErrNoWindow {
+		t.Fatalf("retransmit without window: %v", err)
+	}
+}
+
+func TestWeightedSchedulerFollowsPacingRate(t *testing.T) {
+	now := time.Unix(0, 0)
+	paths := []*Subflow{{ID: 0, CC: congestion.New()}, {ID: 1, CC: congestion.New()}}
+	paths[0].CC.Update(300000, 100*time.Millisecond, 100*time.Millisecond, now)
+	paths[1].CC.Update(100000, 100*time.Millisecond, 100*time.Millisecond, now)
+	var w WeightedScheduler
+	counts := map[int]int{}
+	for i := 0; i < 400; i++ {
+		counts[w.Pick(paths, 1200).ID]++
+	}
+	if counts[0] < 280 || counts[0] > 320 {
+		t.Fatalf("expected ~3:1 split, got %v", counts)
+	}
+}
@@ -0,0 +1,461 @@
+//go:build linux
+
+package sockio
+
+import (
+	"encoding/binary"
+	"errors"
+	"net"
+	"net/netip"
+	"syscall"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+
+	"riptide/internal/netutil"
+)
+
+const (
+	maxBatch    = 64
+	maxSegments = 64
+	maxGSOBytes = 65000
+	groBatch    = 8
+	groBufSize  = 65536
+	oobLen      = 64
+)
+
+type mmsghdr struct {
+	hdr unix.Msghdr
+	len uint32
+}
+
+type mmsgBufs struct {
+	hdrs  []mmsghdr
+	iovs  []unix.Iovec
+	names []unix.RawSockaddrInet6
+	oob   []byte
+}
+
+func newMmsgBufs(entries, iovsPerEntry int) mmsgBufs {
+	return mmsgBufs{
+		hdrs:  make([]mmsghdr, entries),
+		iovs:  make([]unix.Iovec, entries*iovsPerEntry),
+		names: make([]unix.RawSockaddrInet6, entries),
+		oob:   make([]byte, entries*oobLen),
+	}
+}
+
+type batchWriter struct {
+	rc  syscall.RawConn
+	v6  bool
+	gso bool
+	// gsoOK is set once a segmented send has gone through; from then on
+	// EIO is a real error rather than a device that cannot segment.
+	gsoOK  bool
+	bufs   mmsgBufs
+	counts []int
+}
+
+type batchReader struct {
+	rc      syscall.RawConn
+	gro     bool
+	bufs    mmsgBufs
+	scratch [][]byte
+	groBufs [][]byte
+	pending []Message
+}
+
+func newBatch(c *net.UDPConn, want Mode) (*batchWriter, *batchReader) {
+	if want == ModePlain {
+		return nil, nil
+	}
+	rc, err := c.SyscallConn()
+	if err != nil {
+		return nil, nil
+	}
+	w := &batchWriter{
+		rc:     rc,
+		v6:     netutil.ConnFamily(c) == netutil.IPv6,
+		counts: make([]int, maxBatch),
+	}
+	r := &batchReader{rc: rc}
+	if want == ModeGSO {
+		_ = rc.Control(func(fd uintptr) {
+			if _, err := unix.GetsockoptInt(int(fd), unix.SOL_UDP, unix.UDP_SEGMENT); err == nil {
+				w.gso = true
+			}
+			if err := unix.SetsockoptInt(int(fd), unix.SOL_UDP, unix.UDP_GRO, 1); err == nil {
+				r.gro = true
+			}
+		})
+	}
+	iovs := 1
+	if w.gso {
+		iovs = maxSegments
+	}
+	w.bufs = newMmsgBufs(maxBatch, iovs)
+	if r.gro {
+		r.bufs = newMmsgBufs(groBatch, 1)
+		r.groBufs = make([][]byte, groBatch)
+		for i := range r.groBufs {
+			r.groBufs[i] = make([]byte, groBufSize)
+		}
+	} else {
+		r.bufs = newMmsgBufs(maxBatch, 1)
+	}
+	return w, r
+}
+
+func (w *batchWriter) mode() Mode {
+	if w.gso {
+		return ModeGSO
+	}
+	return ModeBatch
+}
+
+func (w *batchWriter) downgrade() Mode {
+	if w.gso {
+		w.gso = false
+		return ModeBatch
+	}
+	return ModePlain
+}
+
+func (w *batchWriter) write(msgs []Message) (int, error) {
+	done := 0
+	for done < len(msgs) {
+		entries, err := w.pack(msgs[done:])
+		if err != nil {
+			return done, err
+		}
+		sent, err := w.sendmmsg(entries)
+		for i := 0; i < sent; i++ {
+			done += w.counts[i]
+			if w.counts[i] > 1 {
+				w.gsoOK = true
+			}
+		}
+		if err != nil {
+			return done, err
+		}
+	}
+	return done, nil
+}
+
+// pack fills the mmsghdr array from msgs. With GSO, runs of equal-sized
+// datagrams to the same address become one entry whose iovecs the kernel
+// splits at the UDP_SEGMENT size; only the last datagram of a run may be
+// shorter.
+func (w *batchWriter) pack(msgs []Message) (int, error) {
+	iovsPer := len(w.bufs.iovs) / len(w.bufs.hdrs)
+	e, i := 0, 0
+	for i < len(msgs) && e < len(w.bufs.hdrs) {
+		seg := len(msgs[i].Buf)
+		j, total := i+1, seg
+		if w.gso {
+			for j < len(msgs) && j-i < iovsPer && msgs[j].Addr == msgs[i].Addr {
+				l := len(msgs[j].Buf)
+				if l > seg || total+l > maxGSOBytes || len(msgs[j-1].Buf) < seg {
+					break
+				}
+				total += l
+				j++
+			}
+		}
+		nameLen, err := w.putAddr(e, msgs[i].Addr)
+		if err != nil {
+			return 0, err
+		}
+		iovs := w.bufs.iovs[e*iovsPer : e*iovsPer+(j-i)]
+		for k := range iovs {
+			b := msgs[i+k].Buf
+			iovs[k] = unix.Iovec{}
+			if len(b) > 0 {
+				iovs[k].Base = &b[0]
+			}
+			iovs[k].SetLen(len(b))
+		}
+		h := &w.bufs.hdrs[e]
+		*h = mmsghdr{}
+		h.hdr.Name = (*byte)(unsafe.Pointer(&w.bufs.names[e]))
+		h.hdr.Namelen = nameLen
+		h.hdr.Iov = &iovs[0]
+		h.hdr.SetIovlen(len(iovs))
+		if j-i > 1 {
+			oob := w.bufs.oob[e*oobLen:]
+			ch := (*unix.Cmsghdr)(unsafe.Pointer(&oob[0]))
+			ch.Level = unix.SOL_UDP
+			ch.Type = unix.UDP_SEGMENT
+			ch.SetLen(unix.CmsgLen(2))
+			binary.NativeEndian.PutUint16(oob[unix.CmsgLen(0):], uint16(seg))
+			h.hdr.Control = &oob[0]
+			h.hdr.SetControllen(unix.CmsgSpace(2))
+		}
+		w.counts[e] = j - i
+		e++
+		i = j
+	}
+	return e, nil
+}
+
+func (w *batchWriter) putAddr(e int, a netip.AddrPort) (uint32, error) {
+	sa := &w.bufs.names[e]
+	if w.v6 {
+		*sa = unix.RawSockaddrInet6{Family: unix.AF_INET6, Addr: a.Addr().As16()}
+		putPort(&sa.Port, a.Port())
+		return unix.SizeofSockaddrInet6, nil
+	}
+	ip := a.Addr().Unmap()
+	if !ip.Is4() {
+		return 0, errors.New("ipv6 destination on ipv4 socket")
+	}
+	sa4 := (*unix.RawSockaddrInet4)(unsafe.Pointer(sa))
+	*sa4 = unix.RawSockaddrInet4{Family: unix.AF_INET, Addr: ip.As4()}
+	putPort(&sa4.Port, a.Port())
+	return unix.SizeofSockaddrInet4, nil
+}
+
+func (w *batchWriter) sendmmsg(entries int) (int, error) {
+	var sent int
+	var serr error
+	err := w.rc.Write(func(fd uintptr) bool {
+		for {
+			r, _, e := unix.Syscall6(unix.SYS_SENDMMSG, fd, uintptr(unsafe.Pointer(&w.bufs.hdrs[0])), uintptr(entries), 0, 0, 0)
+			switch e {
+			case 0:
+				sent = int(r)
+				return true
+			case unix.EINTR:
+				continue
+			case unix.EAGAIN:
+				return false
+			default:
+				serr = e
+				return true
+			}
+		}
+	})
+	if err != nil {
+		return sent, err
+	}
+	return sent, serr
+}
+
+// unsupported reports whether err from write means the fast path does not
+// work on this socket. UDP GSO fails with EIO when the egress device cannot
+// checksum the segments, which shows on the first segmented send; any
+// other error, including EIO later on, is a failed send.
+func (w *batchWriter) unsupported(err error) bool {
+	if errors.Is(err, unix.ENOSYS) {
+		return true
+	}
+	return w.gso && !w.gsoOK && errors.Is(err, unix.EIO)
+}
+
+func (r *batchReader) mode() Mode {
+	if r.gro {
+		return ModeGSO
+	}
+	return ModeBatch
+}
+
+func (r *batchReader) downgrade() Mode {
+	if r.gro {
+		_ = r.rc.Control(func(fd uintptr) {
+			_ = unix.SetsockoptInt(int(fd), unix.SOL_UDP, unix.UDP_GRO, 0)
+		})
+		r.gro = false
+		r.pending = nil
+		r.bufs = newMmsgBufs(maxBatch, 1)
+		return ModeBatch
+	}
+	return ModePlain
+}
+
+func (r *batchReader) read(msgs []Message) (int, error) {
+	if !r.gro {
+		r.scratch = r.scratch[:0]
+		for i := 0; i < len(msgs) && i < len(r.bufs.hdrs); i++ {
+			r.scratch = append(r.scratch, msgs[i].Buf)
+		}
+		n, err := r.recvmmsg(r.scratch)
+		for i := 0; i < n; i++ {
+			h := &r.bufs.hdrs[i]
+			msgs[i].N = int(h.len)
+			msgs[i].Trunc = h.hdr.Flags&unix.MSG_TRUNC != 0
+			msgs[i].Addr = sockaddrAddrPort(&r.bufs.names[i])
+			_, msgs[i].ECN = parseOOB(r.bufs.oob[i*oobLen : i*oobLen+int(h.hdr.Controllen)])
+		}
+		return n, err
+	}
+	if len(r.pending) == 0 {
+		n, err := r.recvmmsg(r.groBufs)
+		if err != nil {
+			return 0, err
+		}
+		for i := 0; i < n; i++ {
+			h := &r.bufs.hdrs[i]
+			total := int(h.len)
+			seg, ecn := parseOOB(r.bufs.oob[i*oobLen : i*oobLen+int(h.hdr.Controllen)])
+			if seg <= 0 {
+				seg = total
+			}
+			addr := sockaddrAddrPort(&r.bufs.names[i])
+			for off := 0; off < total; off += seg {
+				end := off + seg
+				if end > total {
+					end = total
+				}
+				r.pending = append(r.pending, Message{Buf: r.groBufs[i][off:end], N: end - off, Addr: addr, ECN: ecn})
+			}
+			// Only the last segment of a coalesced buffer can be cut short.
+			if h.hdr.Flags&unix.MSG_TRUNC != 0 && total > 0 {
+				r.pending[len(r.pending)-1].Trunc = true
+			}
+		}
+	}
+	n := 0
+	for n < len(msgs) && n < len(r.pending) {
+		p := r.pending[n]
+		msgs[n].N = copy(msgs[n].Buf, p.Buf[:p.N])
+		msgs[n].Trunc = p.Trunc || msgs[n].N < p.N
+		msgs[n].Addr = p.Addr
+		msgs[n].ECN = p.ECN
+		n++
+	}
+	if n == len(r.pending) {
+		r.pending = r.pending[:0]
+	} else {
+		r.pending = r.pending[n:]
+	}
+	return n, nil
+}
+
+func (r *batchReader) recvmmsg(bufs [][]byte) (int, error) {
+	if len(bufs) > len(r.bufs.hdrs) {
+		bufs = bufs[:len(r.bufs.hdrs)]
+	}
+	for i, b := range bufs {
+		iov := &r.bufs.iovs[i]
+		*iov = unix.Iovec{}
+		if len(b) > 0 {
+			iov.Base = &b[0]
+		}
+		iov.SetLen(len(b))
+		h := &r.bufs.hdrs[i]
+		*h = mmsghdr{}
+		h.hdr.Name = (*byte)(unsafe.Pointer(&r.bufs.names[i]))
+		h.hdr.Namelen = unix.SizeofSockaddrInet6
+		h.hdr.Iov = iov
+		h.hdr.SetIovlen(1)
+		h.hdr.Control = &r.bufs.oob[i*oobLen]
+		h.hdr.SetControllen(oobLen)
+	}
+	var got int
+	var serr error
+	err := r.rc.Read(func(fd uintptr) bool {
+		for {
+			n, _, e := unix.Syscall6(unix.SYS_RECVMMSG, fd, uintptr(unsafe.Pointer(&r.bufs.hdrs[0])), uintptr(len(bufs)), 0, 0, 0)
+			switch e {
+			case 0:
+				got = int(n)
+				return true
+			case unix.EINTR:
+				continue
+			case unix.EAGAIN:
+				return false
+			default:
+				serr = e
+				return true
+			}
+		}
+	})
+	if err != nil {
+		return 0, err
+	}
+	return got, serr
+}
+
+// parseOOB extracts the GRO segment size and the ECN codepoint from the
+// control messages of one received datagram.
+func parseOOB(oob []byte) (int, netutil.ECN) {
+	cms, err := unix.ParseSocketControlMessage(oob)
+	if err != nil {
+		return 0, netutil.ECNNotECT
+	}
+	seg, ecn := 0, netutil.ECNNotECT
+	for _, m := range cms {
+		switch {
+		case m.Header.Level == unix.SOL_UDP && m.Header.Type == unix.UDP_GRO && len(m.Data) >= 4:
+			seg = int(binary.NativeEndian.Uint32(m.Data))
+		case m.Header.Level == unix.IPPROTO_IP && m.Header.Type == unix.IP_TOS && len(m.Data) >= 1:
+			ecn = netutil.ECNFromTOS(m.Data[0])
+		case m.Header.Level == unix.IPPROTO_IPV6 && m.Header.Type == unix.IPV6_TCLASS && len(m.Data) >= 4:
+			ecn = netutil.ECNFromTOS(byte(binary.NativeEndian.Uint32(m.Data)))
+		}
+	}
+	return seg, ecn
+}
+
+// setECN marks outgoing packets ECT(0) and asks the kernel to report the
+// TOS / traffic class byte of incoming packets. Dual-stack IPv6 sockets
+// also get the IPv4 options so v4-mapped peers are covered.
+func setECN(c *net.UDPConn, on bool) error {
+	rc, err := c.SyscallConn()
+	if err != nil {
+		return err
+	}
+	tos, recv := 0, 0
+	if on {
+		tos, recv = int(netutil.ECNECT0), 1
+	}
+	v6 := netutil.ConnFamily(c) == netutil.IPv6
+	var serr error
+	if err := rc.Control(func(fd uintptr) {
+		s := int(fd)
+		if v6 {
+			if serr = unix.SetsockoptInt(s, unix.IPPROTO_IPV6, unix.IPV6_TCLASS, tos); serr != nil {
+				return
+			}
+			if serr = unix.SetsockoptInt(s, unix.IPPROTO_IPV6, unix.IPV6_RECVTCLASS, recv); serr != nil {
+				return
+			}
+			_ = unix.SetsockoptInt(s, unix.IPPROTO_IP, unix.IP_TOS, tos)
+			_ = unix.SetsockoptInt(s, unix.IPPROTO_IP, unix.IP_RECVTOS, recv)
+			return
+		}
+		if serr = unix.SetsockoptInt(s, unix.IPPROTO_IP, unix.IP_TOS, tos); serr != nil {
+			return
+		}
+		serr = unix.SetsockoptInt(s, unix.IPPROTO_IP, unix.IP_RECVTOS, recv)
+	}); err != nil {
+		return err
+	}
+	return serr
+}
+
+func putPort(p *uint16, port uint16) {
+	b := (*[2]byte)(unsafe.Pointer(p))
+	b[0] = byte(port >> 8)
+	b[1] = byte(port)
+}
+
+func sockaddrAddrPort(sa *unix.RawSockaddrInet6) netip.AddrPort {
+	switch sa.Family {
+	case unix.AF_INET:
+		sa4 := (*unix.RawSockaddrInet4)(unsafe.Pointer(sa))
+		pb := (*[2]byte)(unsafe.Pointer(&sa4.Port))
+		return netip.AddrPortFrom(netip.AddrFrom4(sa4.Addr), uint16(pb[0])<<8|uint16(pb[1]))
+	case unix.AF_INET6:
+		pb := (*[2]byte)(unsafe.Pointer(&sa.Port))
+		return netip.AddrPortFrom(netip.AddrFrom16(sa.Addr).Unmap(), uint16(pb[0])<<8|uint16(pb[1]))
+	}
+	return netip.AddrPort{}
+}
+
+// unsupported reports whether err from read means the fast path does not
+// work on this socket. GRO needs nothing at read time beyond the UDP_GRO
+// option checked in newBatch, so only a missing recvmmsg qualifies.
+func (r *batchReader) unsupported(err error) bool {
+	return errors.Is(err, unix.ENOSYS)
+}
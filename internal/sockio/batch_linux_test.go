@@ -0,0 +1,32 @@
+package sockio
+
+import (
+	"fmt"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestOnlyGSOFailuresDowngrade(t *testing.T) {
+	w := &batchWriter{gso: true}
+	wrapped := fmt.Errorf("sendmmsg: %w", unix.EIO)
+	if !w.unsupported(wrapped) {
+		t.Fatalf("EIO on the first GSO send should fall back")
+	}
+	for _, err := range []error{unix.EINVAL, unix.EOPNOTSUPP, unix.ENOPROTOOPT, unix.EMSGSIZE} {
+		if w.unsupported(err) {
+			t.Fatalf("%v is a send error, not missing GSO", err)
+		}
+	}
+	w.gsoOK = true
+	if w.unsupported(unix.EIO) {
+		t.Fatalf("EIO after GSO worked is a send error")
+	}
+	if !w.unsupported(unix.ENOSYS) || (&batchWriter{}).unsupported(unix.EIO) {
+		t.Fatalf("ENOSYS must fall back; EIO without GSO must not")
+	}
+	r := &batchReader{gro: true}
+	if r.unsupported(unix.EINVAL) || r.unsupported(unix.EIO) || !r.unsupported(unix.ENOSYS) {
+		t.Fatalf("reader fallback")
+	}
+}
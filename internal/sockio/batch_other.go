@@ -0,0 +1,39 @@
+//go:build !linux
+
+package sockio
+
+import (
+	"errors"
+	"net"
+
+	"riptide/internal/netutil"
+)
+
+type batchWriter struct{}
+
+type batchReader struct{}
+
+func newBatch(c *net.UDPConn, want Mode) (*batchWriter, *batchReader) {
+	return nil, nil
+}
+
+func (w *batchWriter) mode() Mode                        { return ModePlain }
+func (w *batchWriter) downgrade() Mode                   { return ModePlain }
+func (w *batchWriter) write(msgs []Message) (int, error) { return 0, nil }
+func (r *batchReader) mode() Mode                        { return ModePlain }
+func (r *batchReader) downgrade() Mode                   { return ModePlain }
+func (r *batchReader) read(msgs []Message) (int, error)  { return 0, nil }
+
+func (w *batchWriter) unsupported(err error) bool { return false }
+func (r *batchReader) unsupported(err error) bool { return false }
+
+func parseOOB(oob []byte) (int, netutil.ECN) {
+	return 0, netutil.ECNNotECT
+}
+
+func setECN(c *net.UDPConn, on bool) error {
+	if !on {
+		return nil
+	}
+	return errors.New("ecn not supported on this platform")
+}
@@ -0,0 +1,67 @@
+package sockio
+
+import (
+	"net"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func cpuTime() time.Duration {
+	var ru syscall.Rusage
+	_ = syscall.Getrusage(syscall.RUSAGE_SELF, &ru)
+	return time.Duration(ru.Utime.Nano() + ru.Stime.Nano())
+}
+
+// BenchmarkLoopback sends 1200-byte datagrams over loopback in batches of
+// 32 and reports received packets/sec and process CPU time per byte.
+func BenchmarkLoopback(b *testing.B) {
+	for _, mode := range []Mode{ModePlain, ModeBatch, ModeGSO} {
+		b.Run(mode.String(), func(b *testing.B) {
+			a, r := loopbackPair(b, "udp4", "127.0.0.1")
+			defer a.Close()
+			defer r.Close()
+			tx := New(a, mode)
+			rx := New(r, mode)
+			dst := r.LocalAddr().(*net.UDPAddr).AddrPort()
+
+			const batch = 32
+			const size = 1200
+			out := make([]Message, batch)
+			payload := make([]byte, size)
+			for i := range out {
+				out[i] = Message{Buf: payload, Addr: dst}
+			}
+			in := make([]Message, batch)
+			for i := range in {
+				in[i].Buf = make([]byte, 2048)
+			}
+
+			received := 0
+			b.SetBytes(size * batch)
+			b.ResetTimer()
+			start, cpu0 := time.Now(), cpuTime()
+			for i := 0; i < b.N; i++ {
+				if _, err := tx.WriteBatch(out); err != nil {
+					b.Fatalf("write: %v", err)
+				}
+				got := 0
+				_ = r.SetReadDeadline(time.Now().Add(50 * time.Millisecond))
+				for got < batch {
+					n, err := rx.ReadBatch(in)
+					if err != nil {
+						break
+					}
+					got += n
+				}
+				received += got
+			}
+			elapsed, cpu := time.Since(start), cpuTime()-cpu0
+			b.StopTimer()
+			b.ReportMetric(float64(received)/elapsed.Seconds(), "pkts/s")
+			if received > 0 {
+				b.ReportMetric(float64(cpu.Nanoseconds())/float64(received*size), "cpu-ns/B")
+			}
+		})
+	}
+}
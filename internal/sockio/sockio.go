@@ -0,0 +1,139 @@
+package sockio
+
+import (
+	"errors"
+	"net"
+	"net/netip"
+	"sync/atomic"
+
+	"riptide/internal/netutil"
+)
+
+type Mode uint8
+
+const (
+	ModePlain Mode = iota
+	ModeBatch
+	ModeGSO
+)
+
+func (m Mode) String() string {
+	switch m {
+	case ModeBatch:
+		return "batch"
+	case ModeGSO:
+		return "gso"
+	default:
+		return "plain"
+	}
+}
+
+// Message is one datagram. On write Buf holds the payload; on read Buf is
+// the receive buffer and N is set to the number of bytes received. ECN is
+// only reported on read once SetECN has been enabled. Trunc is set when a
+// read had to cut a datagram that did not fit in Buf; the plain path can
+// only tell where the platform has MSG_TRUNC.
+type Message struct {
+	Buf   []byte
+	N     int
+	Addr  netip.AddrPort
+	ECN   netutil.ECN
+	Trunc bool
+}
+
+// Conn sends and receives datagrams in batches, using sendmmsg/recvmmsg
+// and UDP GSO/GRO where the platform supports them. Each direction falls
+// back independently to per-packet calls when the kernel rejects the fast
+// path. One goroutine may write while another reads.
+type Conn struct {
+	conn  *net.UDPConn
+	wmode atomic.Uint32
+	rmode atomic.Uint32
+	w     *batchWriter
+	r     *batchReader
+	ecn   atomic.Bool
+	oob   []byte
+}
+
+func New(c *net.UDPConn, want Mode) *Conn {
+	bc := &Conn{conn: c}
+	bc.w, bc.r = newBatch(c, want)
+	wm, rm := ModePlain, ModePlain
+	if bc.w != nil {
+		wm = bc.w.mode()
+	}
+	if bc.r != nil {
+		rm = bc.r.mode()
+	}
+	bc.wmode.Store(uint32(wm))
+	bc.rmode.Store(uint32(rm))
+	return bc
+}
+
+// SetECN turns ECT(0) marking of outgoing packets and reporting of the
+// received ECN codepoint on or off.
+func (c *Conn) SetECN(on bool) error {
+	if err := setECN(c.conn, on); err != nil {
+		return err
+	}
+	c.ecn.Store(on)
+	return nil
+}
+
+func (c *Conn) ECN() bool { return c.ecn.Load() }
+
+func (c *Conn) WriteMode() Mode { return Mode(c.wmode.Load()) }
+func (c *Conn) ReadMode() Mode  { return Mode(c.rmode.Load()) }
+func (c *Conn) UDPConn() *net.UDPConn {
+	return c.conn
+}
+
+func (c *Conn) WriteBatch(msgs []Message) (int, error) {
+	sent := 0
+	for c.WriteMode() != ModePlain && sent < len(msgs) {
+		n, err := c.w.write(msgs[sent:])
+		sent += n
+		if err == nil {
+			return sent, nil
+		}
+		if !c.w.unsupported(err) {
+			return sent, err
+		}
+		c.wmode.Store(uint32(c.w.downgrade()))
+	}
+	for ; sent < len(msgs); sent++ {
+		if _, err := c.conn.WriteToUDPAddrPort(msgs[sent].Buf, msgs[sent].Addr); err != nil {
+			return sent, err
+		}
+	}
+	return sent, nil
+}
+
+func (c *Conn) ReadBatch(msgs []Message) (int, error) {
+	if len(msgs) == 0 {
+		return 0, errors.New("empty batch")
+	}
+	for c.ReadMode() != ModePlain {
+		n, err := c.r.read(msgs)
+		if err == nil || !c.r.unsupported(err) {
+			return n, err
+		}
+		c.rmode.Store(uint32(c.r.downgrade()))
+	}
+	var oob []byte
+	if c.ECN() {
+		if c.oob == nil {
+			c.oob = make([]byte, 64)
+		}
+		oob = c.oob
+	}
+	n, oobn, flags, addr, err := c.conn.ReadMsgUDPAddrPort(msgs[0].Buf, oob)
+	if err != nil {
+		return 0, err
+	}
+	msgs[0] = Message{Buf: msgs[0].Buf, N: n, Addr: addr, Trunc: flags&msgTrunc != 0}
+	if oob != nil {
+		_, msgs[0].ECN = parseOOB(oob[:oobn])
+	}
+	return 1, nil
+}
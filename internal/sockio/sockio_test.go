@@ -0,0 +1,179 @@
+package sockio
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+
+	"riptide/internal/netutil"
+)
+
+func loopbackPair(t testing.TB, network, host string) (*net.UDPConn, *net.UDPConn) {
+	t.Helper()
+	a, err := net.ListenUDP(network, &net.UDPAddr{IP: net.ParseIP(host)})
+	if err != nil {
+		t.Skipf("listen %s: %v", network, err)
+	}
+	b, err := net.ListenUDP(network, &net.UDPAddr{IP: net.ParseIP(host)})
+	if err != nil {
+		a.Close()
+		t.Skipf("listen %s: %v", network, err)
+	}
+	_ = b.SetReadBuffer(4 << 20)
+	return a, b
+}
+
+func testRoundTrip(t *testing.T, network, host string, mode Mode) {
+	a, b := loopbackPair(t, network, host)
+	defer a.Close()
+	defer b.Close()
+	tx := New(a, mode)
+	rx := New(b, mode)
+	dst := b.LocalAddr().(*net.UDPAddr).AddrPort()
+
+	const count = 100
+	msgs := make([]Message, count)
+	for i := range msgs {
+		size := 1200
+		if i == count-1 {
+			size = 700
+		}
+		buf := bytes.Repeat([]byte{byte(i)}, size)
+		msgs[i] = Message{Buf: buf, Addr: dst}
+	}
+	n, err := tx.WriteBatch(msgs)
+	if err != nil || n != count {
+		t.Fatalf("write: n=%d err=%v", n, err)
+	}
+
+	_ = b.SetReadDeadline(time.Now().Add(5 * time.Second))
+	got := 0
+	src := a.LocalAddr().(*net.UDPAddr).AddrPort()
+	for got < count {
+		in := make([]Message, 16)
+		for i := range in {
+			in[i].Buf = make([]byte, 2048)
+		}
+		n, err := rx.ReadBatch(in)
+		if err != nil {
+			t.Fatalf("read after %d: %v", got, err)
+		}
+		for _, m := range in[:n] {
+			want := msgs[got].Buf
+			if !bytes.Equal(m.Buf[:m.N], want) {
+				t.Fatalf("datagram %d: len %d want %d", got, m.N, len(want))
+			}
+			if m.Addr.Port() != src.Port() || m.Addr.Addr().Unmap() != src.Addr().Unmap() {
+				t.Fatalf("datagram %d: from %v want %v", got, m.Addr, src)
+			}
+			got++
+		}
+	}
+}
+
+func TestRoundTripModes(t *testing.T) {
+	for _, mode := range []Mode{ModePlain, ModeBatch, ModeGSO} {
+		for _, fam := range []struct{ network, host string }{{"udp4", "127.0.0.1"}, {"udp6", "::1"}} {
+			t.Run(fmt.Sprintf("%s/%s", mode, fam.network), func(t *testing.T) {
+				testRoundTrip(t, fam.network, fam.host, mode)
+			})
+		}
+	}
+}
+
+func TestPlainModeHasNoFastPath(t *testing.T) {
+	a, b := loopbackPair(t, "udp4", "127.0.0.1")
+	defer a.Close()
+	defer b.Close()
+	c := New(a, ModePlain)
+	if c.WriteMode() != ModePlain || c.ReadMode() != ModePlain {
+		t.Fatalf("modes = %s/%s", c.WriteMode(), c.ReadMode())
+	}
+	if _, err := c.ReadBatch(nil); err == nil {
+		t.Fatalf("expected error for empty batch")
+	}
+	var zero netip.AddrPort
+	if _, err := c.WriteBatch([]Message{{Buf: []byte("x"), Addr: zero}}); err == nil {
+		t.Fatalf("expected error for invalid address")
+	}
+}
+
+func TestECNMarkedOnLoopback(t *testing.T) {
+	for _, mode := range []Mode{ModePlain, ModeBatch, ModeGSO} {
+		for _, fam := range []struct{ network, host string }{{"udp4", "127.0.0.1"}, {"udp6", "::1"}} {
+			t.Run(fmt.Sprintf("%s/%s", mode, fam.network), func(t *testing.T) {
+				a, b := loopbackPair(t, fam.network, fam.host)
+				defer a.Close()
+				defer b.Close()
+				tx, rx := New(a, mode), New(b, mode)
+				if err := tx.SetECN(true); err != nil {
+					t.Skipf("ecn: %v", err)
+				}
+				if err := rx.SetECN(true); err != nil {
+					t.Skipf("ecn: %v", err)
+				}
+				dst := b.LocalAddr().(*net.UDPAddr).AddrPort()
+				out := []Message{{Buf: []byte("a"), Addr: dst}, {Buf: []byte("b"), Addr: dst}}
+				if _, err := tx.WriteBatch(out); err != nil {
+					t.Fatalf("write: %v", err)
+				}
+				_ = b.SetReadDeadline(time.Now().Add(5 * time.Second))
+				in := []Message{{Buf: make([]byte, 16)}}
+				n, err := rx.ReadBatch(in)
+				if err != nil || n != 1 {
+					t.Fatalf("read: %d %v", n, err)
+				}
+				if in[0].ECN != netutil.ECNECT0 {
+					t.Fatalf("ecn = %d want ECT(0)", in[0].ECN)
+				}
+			})
+		}
+	}
+}
+
+func TestReadReportsTruncation(t *testing.T) {
+	for _, mode := range []Mode{ModePlain, ModeBatch, ModeGSO} {
+		t.Run(mode.String(), func(t *testing.T) {
+			a, b := loopbackPair(t, "udp4", "127.0.0.1")
+			defer a.Close()
+			defer b.Close()
+			tx, rx := New(a, mode), New(b, mode)
+			if mode != ModePlain && rx.ReadMode() == ModePlain {
+				t.Skip("no batched reads on this platform")
+			}
+			if mode == ModePlain && msgTrunc == 0 {
+				t.Skip("no MSG_TRUNC on this platform")
+			}
+			dst := b.LocalAddr().(*net.UDPAddr).AddrPort()
+			out := []Message{
+				{Buf: bytes.Repeat([]byte{1}, 1200), Addr: dst},
+				{Buf: bytes.Repeat([]byte{2}, 1200), Addr: dst},
+				{Buf: bytes.Repeat([]byte{3}, 100), Addr: dst},
+			}
+			if _, err := tx.WriteBatch(out); err != nil {
+				t.Fatalf("write: %v", err)
+			}
+			_ = b.SetReadDeadline(time.Now().Add(5 * time.Second))
+			for got := 0; got < len(out); {
+				in := make([]Message, 4)
+				for i := range in {
+					in[i].Buf = make([]byte, 500)
+				}
+				n, err := rx.ReadBatch(in)
+				if err != nil {
+					t.Fatalf("read: %v", err)
+				}
+				for _, m := range in[:n] {
+					want := len(out[got].Buf) > 500
+					if m.Trunc != want || m.Buf[0] != out[got].Buf[0] {
+						t.Fatalf("datagram %d: trunc %v want %v, n %d", got, m.Trunc, want, m.N)
+					}
+					got++
+				}
+			}
+		})
+	}
+}
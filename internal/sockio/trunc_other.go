@@ -0,0 +1,7 @@
+//go:build !unix
+
+package sockio
+
+// msgTrunc is zero where recvmsg reports no truncation flag, so plain
+// reads never set Message.Trunc.
+const msgTrunc = 0
@@ -0,0 +1,8 @@
+//go:build unix
+
+package sockio
+
+import "syscall"
+
+// msgTrunc is the recvmsg flag marking a datagram cut to fit the buffer.
+const msgTrunc = syscall.MSG_TRUNC